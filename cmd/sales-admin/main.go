@@ -4,22 +4,22 @@ import (
 	"bufio"
 	"bytes"
 	"context"
-	"crypto/rand"
-	"crypto/rsa"
-	"crypto/x509"
-	"encoding/pem"
 	"flag"
 	"fmt"
 	"garagesale/internal/platform/auth"
 	"garagesale/internal/platform/database"
+	"garagesale/internal/platform/organization"
 	"garagesale/internal/platform/user"
 	"garagesale/internal/schema"
+	"io/ioutil"
 	"log"
 	"os"
+	"path/filepath"
 	"strings"
 	"syscall"
 	"time"
 
+	"github.com/google/uuid"
 	"github.com/kelseyhightower/envconfig"
 	"github.com/pkg/errors"
 	"golang.org/x/term"
@@ -35,12 +35,21 @@ func run() error {
 	log := log.New(os.Stdout, "ADMIN: ", log.LstdFlags|log.Lshortfile)
 
 	var cfg struct {
-		DB database.Config
+		DB   database.Config
+		Auth struct {
+			KeysDir   string `default:"keys" split_words:"true"`
+			Algorithm string `default:"RS256"`
+			Password  auth.PasswordPolicy
+		}
 	}
 	if err := envconfig.Process("garagesale", &cfg); err != nil {
 		return errors.Wrap(err, "generating config usage")
 	}
 
+	if err := cfg.Auth.Password.Validate(); err != nil {
+		return errors.Wrap(err, "validating password policy")
+	}
+
 	var err error
 
 	flag.Parse()
@@ -57,15 +66,47 @@ func run() error {
 		}
 	case "useradd":
 		role := flag.Arg(1)
-		err = useradd(cfg.DB, role)
+		err = useradd(cfg.DB, cfg.Auth.Password, role, flag.Arg(2))
 		if err == nil {
 			log.Print("user added")
 		}
+	case "userstatus":
+		err = userstatus(cfg.DB, flag.Arg(1), flag.Arg(2))
+		if err == nil {
+			log.Print("user status updated")
+		}
+	case "certadd":
+		err = certadd(cfg.DB, flag.Arg(1), flag.Arg(2), flag.Arg(3))
+		if err == nil {
+			log.Print("client certificate registered")
+		}
+	case "certremove":
+		err = certremove(cfg.DB, flag.Arg(1))
+		if err == nil {
+			log.Print("client certificate removed")
+		}
+	case "orgadd":
+		err = orgadd(cfg.DB, flag.Arg(1))
+	case "orgmove":
+		err = orgmove(cfg.DB, flag.Arg(1), flag.Arg(2), flag.Arg(3))
+		if err == nil {
+			log.Print("user moved")
+		}
 	case "keygen":
-		err = keygen()
+		err = keygen(cfg.Auth.KeysDir, cfg.Auth.Algorithm)
 		if err == nil {
 			log.Print("keygen success")
 		}
+	case "keypromote":
+		err = keypromote(cfg.Auth.KeysDir, cfg.Auth.Algorithm, flag.Arg(1))
+		if err == nil {
+			log.Print("key promoted, send SIGHUP to sales-api to reload")
+		}
+	case "keyretire":
+		err = keyretire(cfg.Auth.KeysDir, flag.Arg(1))
+		if err == nil {
+			log.Print("key retired, send SIGHUP to sales-api to reload")
+		}
 	default:
 		log.Print("No args passed")
 		return nil
@@ -102,7 +143,9 @@ func seed(cfg database.Config) error {
 	return nil
 }
 
-func useradd(cfg database.Config, roleFlag string) error {
+// useradd creates a user with the given role in orgID, or in the default
+// organization when orgID is blank
+func useradd(cfg database.Config, policy auth.PasswordPolicy, roleFlag, orgID string) error {
 	db, err := database.Open(cfg)
 	if err != nil {
 		return err
@@ -154,38 +197,203 @@ func useradd(cfg database.Config, roleFlag string) error {
 	nu := user.NewUser{
 		Name:            name,
 		Email:           email,
+		OrgID:           orgID,
 		Roles:           authRoles,
 		Password:        string(bytePassword),
 		PasswordConfirm: string(repeatBytePassword),
 	}
 
-	if _, err := user.Create(context.Background(), db, nu, time.Now()); err != nil {
+	if _, err := user.Create(context.Background(), db, policy, nu, time.Now()); err != nil {
 		return err
 	}
 
 	return nil
 }
 
-func keygen() error {
-	key, err := rsa.GenerateKey(rand.Reader, 2048)
+// userstatus activates or disables a user. Disabling revokes all of their tokens.
+func userstatus(cfg database.Config, id, status string) error {
+	db, err := database.Open(cfg)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	return user.SetStatus(context.Background(), db, "", id, status, time.Now())
+}
+
+// certadd registers a client certificate subject for a user, such as
+// "CN=scanner-01,OU=Warehouse". The default organization is used when orgID
+// is blank.
+func certadd(cfg database.Config, subject, userID, orgID string) error {
+	if subject == "" || userID == "" {
+		return errors.New("usage: certadd <subject> <user_id> [org_id]")
+	}
+
+	db, err := database.Open(cfg)
 	if err != nil {
 		return err
 	}
+	defer db.Close()
+
+	return user.RegisterCertificate(context.Background(), db, subject, userID, orgID, time.Now())
+}
 
-	file, err := os.Create("private.pem")
+// certremove stops accepting client certificates for a subject
+func certremove(cfg database.Config, subject string) error {
+	if subject == "" {
+		return errors.New("usage: certremove <subject>")
+	}
+
+	db, err := database.Open(cfg)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	return user.UnregisterCertificate(context.Background(), db, subject)
+}
+
+// orgadd creates a new organization and prints its id
+func orgadd(cfg database.Config, name string) error {
+	if name == "" {
+		return errors.New("usage: orgadd <name>")
+	}
+
+	db, err := database.Open(cfg)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	org, err := organization.Create(context.Background(), db, organization.NewOrganization{Name: name}, time.Now())
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("organization %q created: %s\n", org.Name, org.ID)
+	return nil
+}
+
+// orgmove moves a user with their roles from one organization to another.
+// Tokens the user already holds are revoked since they name the old organization.
+func orgmove(cfg database.Config, userID, fromOrgID, toOrgID string) error {
+	if userID == "" || fromOrgID == "" || toOrgID == "" {
+		return errors.New("usage: orgmove <user_id> <from_org_id> <to_org_id>")
+	}
+
+	db, err := database.Open(cfg)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	ctx := context.Background()
+	if err := organization.MoveMember(ctx, db, fromOrgID, toOrgID, userID); err != nil {
+		return err
+	}
+
+	return user.RevokeTokens(ctx, db, toOrgID, userID, time.Now())
+}
+
+// keygen generates a new private key for algorithm in the key directory and
+// prints its kid. The first key of a directory becomes active, later keys are
+// only trusted for verification until they are promoted with keypromote.
+func keygen(dir, algorithm string) error {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return errors.Wrap(err, "creating key directory")
+	}
+
+	key, err := auth.GenerateKey(algorithm)
+	if err != nil {
+		return err
+	}
+
+	content, err := auth.EncodePrivateKeyPEM(key)
+	if err != nil {
+		return err
+	}
+
+	kid := uuid.New().String()
+
+	file, err := os.OpenFile(filepath.Join(dir, kid+auth.KeyFileExt), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return err
+	}
 	defer file.Close()
+
+	if _, err := file.Write(content); err != nil {
+		return err
+	}
+
+	fmt.Printf("%s key generated: %s\n", algorithm, kid)
+
+	active, err := auth.ReadActiveKID(dir)
 	if err != nil {
 		return err
 	}
 
-	block := pem.Block{
-		Type:  "RSA PRIVATE KEY",
-		Bytes: x509.MarshalPKCS1PrivateKey(key),
+	if active == "" {
+		return keypromote(dir, algorithm, kid)
+	}
+
+	return nil
+}
+
+// keypromote marks a key of the key directory as the one new tokens are signed
+// with. The key must match the algorithm the API is configured for.
+func keypromote(dir, algorithm, kid string) error {
+	if kid == "" {
+		return errors.New("usage: keypromote <kid>")
+	}
+
+	content, err := ioutil.ReadFile(filepath.Join(dir, kid+auth.KeyFileExt))
+	if err != nil {
+		return errors.Wrapf(err, "reading key %q", kid)
+	}
+
+	key, err := auth.ParsePrivateKeyPEM(content)
+	if err != nil {
+		return errors.Wrapf(err, "parsing key %q", kid)
+	}
+
+	if err := auth.CheckKey(algorithm, key.Public()); err != nil {
+		return errors.Wrapf(err, "checking key %q", kid)
 	}
 
-	if err := pem.Encode(file, &block); err != nil {
+	// Write to a temporary file first so a reload never sees a partial kid
+	tmp := filepath.Join(dir, auth.ActiveKeyFile+".tmp")
+	if err := ioutil.WriteFile(tmp, []byte(kid+"\n"), 0600); err != nil {
+		return errors.Wrap(err, "writing active key id")
+	}
+
+	if err := os.Rename(tmp, filepath.Join(dir, auth.ActiveKeyFile)); err != nil {
+		return errors.Wrap(err, "writing active key id")
+	}
+
+	fmt.Printf("key promoted: %s\n", kid)
+	return nil
+}
+
+// keyretire stops trusting a key. Tokens signed by it are rejected once the
+// API reloads its keys. The active key cannot be retired.
+func keyretire(dir, kid string) error {
+	if kid == "" {
+		return errors.New("usage: keyretire <kid>")
+	}
+
+	active, err := auth.ReadActiveKID(dir)
+	if err != nil {
 		return err
 	}
 
+	if active == kid {
+		return errors.New("cannot retire the active key, promote another key first")
+	}
+
+	path := filepath.Join(dir, kid+auth.KeyFileExt)
+	if err := os.Rename(path, path+auth.RetiredKeyFileExt); err != nil {
+		return errors.Wrapf(err, "retiring key %q", kid)
+	}
+
 	return nil
 }
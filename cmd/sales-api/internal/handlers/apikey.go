@@ -0,0 +1,113 @@
+package handlers
+
+import (
+	"context"
+	"garagesale/internal/platform/apikey"
+	"garagesale/internal/platform/auth"
+	"garagesale/internal/platform/web"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+)
+
+// APIKeys holds handlers for minting, listing and revoking API keys. The
+// same handlers serve a user's own keys under /v1/users/me and, for admins,
+// the keys of any user under /v1/users/{id}.
+type APIKeys struct {
+	DB *sqlx.DB
+}
+
+// matchAPIKeyErrors knows how to respond for known api key failure scenarios
+func matchAPIKeyErrors(err error) error {
+	switch errors.Cause(err) {
+	case apikey.ErrNotFound:
+		return web.NewRequestError(err, http.StatusNotFound)
+	case apikey.ErrInvalidID, apikey.ErrExpiresInPast, apikey.ErrUnknownPermission, apikey.ErrNotOrgMember:
+		return web.NewRequestError(err, http.StatusBadRequest)
+	case apikey.ErrForbidden, apikey.ErrPermissionDenied:
+		return web.NewRequestError(err, http.StatusForbidden)
+	default:
+		return nil
+	}
+}
+
+// keyOwner returns the user whose keys the request is about: the user in
+// the URL for admin routes or the caller for /v1/users/me routes
+func keyOwner(r *http.Request, claims auth.Claims) string {
+	if id := chi.URLParam(r, "id"); id != "" {
+		return id
+	}
+
+	return claims.Subject
+}
+
+// Create mints a new API key. The plain key is only part of this response.
+func (k *APIKeys) Create(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return errors.New("auth claims not in context")
+	}
+
+	var nk apikey.NewKey
+	if err := web.Decode(ctx, r, &nk); err != nil {
+		return err
+	}
+
+	owner := keyOwner(r, claims)
+
+	key, err := apikey.Create(ctx, k.DB, claims, owner, nk, time.Now())
+	if err != nil {
+		if webErr := matchAPIKeyErrors(err); webErr != nil {
+			return webErr
+		}
+
+		return errors.Wrapf(err, "creating api key for user %v", owner)
+	}
+
+	return web.Respond(ctx, w, key, http.StatusCreated)
+}
+
+// List gives all API keys of a user without their secrets
+func (k *APIKeys) List(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return errors.New("auth claims not in context")
+	}
+
+	owner := keyOwner(r, claims)
+
+	keys, err := apikey.List(ctx, k.DB, claims, owner)
+	if err != nil {
+		if webErr := matchAPIKeyErrors(err); webErr != nil {
+			return webErr
+		}
+
+		return errors.Wrapf(err, "listing api keys of user %v", owner)
+	}
+
+	return web.Respond(ctx, w, keys, http.StatusOK)
+}
+
+// Revoke disables a single API key identified by key_id in the request URL
+func (k *APIKeys) Revoke(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return errors.New("auth claims not in context")
+	}
+
+	owner := keyOwner(r, claims)
+	keyID := chi.URLParam(r, "key_id")
+
+	if err := apikey.Revoke(ctx, k.DB, claims, owner, keyID, time.Now()); err != nil {
+		if webErr := matchAPIKeyErrors(err); webErr != nil {
+			return webErr
+		}
+
+		return errors.Wrapf(err, "revoking api key %v", keyID)
+	}
+
+	return web.Respond(ctx, w, nil, http.StatusNoContent)
+}
@@ -0,0 +1,66 @@
+package handlers
+
+import (
+	"garagesale/internal/platform/apikey"
+	"garagesale/internal/platform/auth"
+	"garagesale/internal/platform/revocation"
+	"garagesale/internal/platform/role"
+	"garagesale/internal/platform/session"
+	"garagesale/internal/platform/user"
+	"garagesale/internal/product"
+)
+
+// errorCodes are the codes clients can match known errors on instead of
+// their messages. They are part of the API and must not change once
+// published; errors without a code get one made from their status.
+var errorCodes = map[error]string{
+	product.ErrNotFound:  "product_not_found",
+	product.ErrInvalidId: "product_invalid_id",
+	product.ErrForbidden: "product_forbidden",
+
+	user.ErrAuthenticationFailure:    "authentication_failed",
+	user.ErrNotFound:                 "user_not_found",
+	user.ErrInvalidID:                "user_invalid_id",
+	user.ErrEmailTaken:               "email_taken",
+	user.ErrInvalidVerification:      "email_verification_invalid",
+	user.ErrDisabled:                 "user_disabled",
+	user.ErrTokenRevoked:             "token_revoked",
+	user.ErrInvalidStatus:            "user_invalid_status",
+	user.ErrNoMembership:             "not_org_member",
+	user.ErrInvalidRefreshToken:      "refresh_token_invalid",
+	user.ErrRefreshTokenReused:       "refresh_token_reused",
+	user.ErrTwoFactorEnabled:         "two_factor_enabled",
+	user.ErrTwoFactorNotEnabled:      "two_factor_not_enabled",
+	user.ErrTwoFactorNotStarted:      "two_factor_not_started",
+	user.ErrInvalidCode:              "two_factor_code_invalid",
+	user.ErrInvalidChallenge:         "two_factor_challenge_invalid",
+	user.ErrTooManyAttempts:          "two_factor_rate_limited",
+	user.ErrCannotImpersonate:        "impersonation_not_allowed",
+	user.ErrCertificateNotRegistered: "client_certificate_not_registered",
+
+	apikey.ErrNotFound:          "api_key_not_found",
+	apikey.ErrInvalidID:         "api_key_invalid_id",
+	apikey.ErrInvalidKey:        "api_key_invalid",
+	apikey.ErrForbidden:         "api_key_forbidden",
+	apikey.ErrPermissionDenied:  "api_key_permission_denied",
+	apikey.ErrExpiresInPast:     "api_key_expires_in_past",
+	apikey.ErrNotOrgMember:      "not_org_member",
+	apikey.ErrUnknownPermission: "unknown_permission",
+
+	role.ErrNotFound:          "role_not_found",
+	role.ErrBuiltIn:           "role_built_in",
+	role.ErrExists:            "role_exists",
+	role.ErrUnknownPermission: "unknown_permission",
+	role.ErrPermissionDenied:  "role_permission_denied",
+	role.ErrNotOrgMember:      "not_org_member",
+	role.ErrForbidden:         "role_forbidden",
+
+	auth.ErrInvalidToken:     "token_invalid",
+	auth.ErrTokenExpired:     "token_expired",
+	auth.ErrTokenNotYetValid: "token_not_yet_valid",
+	auth.ErrInvalidIssuer:    "token_invalid_issuer",
+	auth.ErrInvalidAudience:  "token_invalid_audience",
+	revocation.ErrRevoked:    "token_revoked",
+
+	session.ErrInvalidSession: "session_invalid",
+}
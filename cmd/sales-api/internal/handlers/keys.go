@@ -0,0 +1,19 @@
+package handlers
+
+import (
+	"context"
+	"garagesale/internal/platform/auth"
+	"garagesale/internal/platform/web"
+	"net/http"
+)
+
+// Keys has handlers to publish the public keys tokens are signed with
+type Keys struct {
+	authenticator *auth.Authenticator
+}
+
+// JWKS responds with the public signing keys in the JSON Web Key Set format
+// so other services can verify our tokens
+func (k *Keys) JWKS(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	return web.Respond(ctx, w, k.authenticator.JWKS(), http.StatusOK)
+}
@@ -0,0 +1,104 @@
+package handlers
+
+// errorMessages are the messages of errorCodes by locale. Clients accepting
+// one of the locales get them instead of the English messages of the errors.
+var errorMessages = map[string]map[string]string{
+	"ru": {
+		"product_not_found":  "товар не найден",
+		"product_invalid_id": "указан недопустимый ID товара",
+		"product_forbidden":  "действие с товаром запрещено",
+
+		"authentication_failed":             "ошибка аутентификации",
+		"user_not_found":                    "пользователь не найден",
+		"user_invalid_id":                   "указан недопустимый ID пользователя",
+		"email_taken":                       "адрес электронной почты уже используется",
+		"email_verification_invalid":        "токен подтверждения почты недействителен",
+		"email_change_unavailable":          "смена адреса электронной почты недоступна, пока не настроен почтовый сервер",
+		"user_disabled":                     "пользователь заблокирован",
+		"token_revoked":                     "токен отозван",
+		"user_invalid_status":               "статус должен быть active или disabled",
+		"not_org_member":                    "пользователь не состоит в организации",
+		"refresh_token_invalid":             "токен обновления недействителен",
+		"refresh_token_reused":              "токен обновления уже использован",
+		"two_factor_enabled":                "двухфакторная аутентификация уже включена",
+		"two_factor_not_enabled":            "двухфакторная аутентификация не включена",
+		"two_factor_not_started":            "подключение двухфакторной аутентификации не начато",
+		"two_factor_code_invalid":           "неверный код двухфакторной аутентификации",
+		"two_factor_challenge_invalid":      "запрос двухфакторной аутентификации недействителен",
+		"two_factor_rate_limited":           "слишком много попыток двухфакторной аутентификации, повторите позже",
+		"impersonation_not_allowed":         "вход от имени другого пользователя запрещён для этой сессии",
+		"client_certificate_not_registered": "клиентский сертификат не зарегистрирован",
+
+		"api_key_not_found":         "API-ключ не найден",
+		"api_key_invalid_id":        "указан недопустимый ID API-ключа",
+		"api_key_invalid":           "API-ключ недействителен, истёк или отозван",
+		"api_key_forbidden":         "действие с API-ключом запрещено",
+		"api_key_permission_denied": "API-ключ не может иметь прав, которых нет у вызывающего или владельца ключа",
+		"api_key_expires_in_past":   "срок действия должен быть в будущем",
+		"unknown_permission":        "неизвестное право",
+
+		"role_not_found":         "роль не найдена",
+		"role_built_in":          "встроенные роли нельзя изменять",
+		"role_exists":            "роль уже существует",
+		"role_forbidden":         "действие с ролью запрещено",
+		"role_permission_denied": "роль не может иметь прав, которых нет у вызывающего",
+
+		"token_invalid":          "токен недействителен",
+		"token_expired":          "срок действия токена истёк",
+		"token_not_yet_valid":    "токен ещё не действителен",
+		"token_invalid_issuer":   "токен выпущен неожиданным издателем",
+		"token_invalid_audience": "токен выпущен для другой аудитории",
+
+		"session_invalid":    "сессия недействительна или истекла",
+		"csrf_token_invalid": "CSRF-токен отсутствует или неверен",
+	},
+	"es": {
+		"product_not_found":  "producto no encontrado",
+		"product_invalid_id": "el ID de producto no es válido",
+		"product_forbidden":  "la acción sobre el producto no está permitida",
+
+		"authentication_failed":             "error de autenticación",
+		"user_not_found":                    "usuario no encontrado",
+		"user_invalid_id":                   "el ID de usuario no es válido",
+		"email_taken":                       "el correo electrónico ya está en uso",
+		"email_verification_invalid":        "el token de verificación del correo no es válido",
+		"email_change_unavailable":          "el cambio de correo no está disponible hasta que se configure un servidor de correo",
+		"user_disabled":                     "el usuario está deshabilitado",
+		"token_revoked":                     "el token ha sido revocado",
+		"user_invalid_status":               "el estado debe ser active o disabled",
+		"not_org_member":                    "el usuario no es miembro de la organización",
+		"refresh_token_invalid":             "el token de actualización no es válido",
+		"refresh_token_reused":              "el token de actualización ya fue usado",
+		"two_factor_enabled":                "la autenticación de dos factores ya está activada",
+		"two_factor_not_enabled":            "la autenticación de dos factores no está activada",
+		"two_factor_not_started":            "la activación de dos factores no se ha iniciado",
+		"two_factor_code_invalid":           "el código de dos factores no es válido",
+		"two_factor_challenge_invalid":      "el desafío de dos factores no es válido",
+		"two_factor_rate_limited":           "demasiados intentos de dos factores, inténtelo más tarde",
+		"impersonation_not_allowed":         "la suplantación no está permitida en esta sesión",
+		"client_certificate_not_registered": "el certificado de cliente no está registrado",
+
+		"api_key_not_found":         "clave de API no encontrada",
+		"api_key_invalid_id":        "el ID de la clave de API no es válido",
+		"api_key_invalid":           "la clave de API no es válida, ha caducado o fue revocada",
+		"api_key_forbidden":         "la acción sobre la clave de API no está permitida",
+		"api_key_permission_denied": "la clave de API no puede tener permisos que el solicitante o el usuario de la clave no tienen",
+		"api_key_expires_in_past":   "la caducidad debe estar en el futuro",
+		"unknown_permission":        "permiso desconocido",
+
+		"role_not_found":         "rol no encontrado",
+		"role_built_in":          "los roles integrados no se pueden cambiar",
+		"role_exists":            "el rol ya existe",
+		"role_forbidden":         "la acción sobre el rol no está permitida",
+		"role_permission_denied": "el rol no puede tener permisos que el solicitante no tiene",
+
+		"token_invalid":          "el token no es válido",
+		"token_expired":          "el token ha caducado",
+		"token_not_yet_valid":    "el token aún no es válido",
+		"token_invalid_issuer":   "el token fue emitido por un emisor inesperado",
+		"token_invalid_audience": "el token fue emitido para otra audiencia",
+
+		"session_invalid":    "la sesión no es válida o ha caducado",
+		"csrf_token_invalid": "falta el token CSRF o no es válido",
+	},
+}
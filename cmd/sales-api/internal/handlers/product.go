@@ -35,7 +35,12 @@ func matchPredefinedErrors(err error) error {
 
 // List gives all known products
 func (p *Product) List(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
-	list, err := product.List(ctx, p.DB)
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return errors.New("auth claims not in context")
+	}
+
+	list, err := product.List(ctx, p.DB, claims)
 	if err != nil {
 		return err
 	}
@@ -45,9 +50,14 @@ func (p *Product) List(ctx context.Context, w http.ResponseWriter, r *http.Reque
 
 // Retrieve gives a single product
 func (p *Product) Retrieve(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return errors.New("auth claims not in context")
+	}
+
 	id := chi.URLParam(r, "id")
 
-	prod, err := product.Retrieve(ctx, p.DB, id)
+	prod, err := product.Retrieve(ctx, p.DB, claims, id)
 	if err != nil {
 		if webErr := matchPredefinedErrors(err); webErr != nil {
 			return webErr
@@ -68,12 +78,16 @@ func (p *Product) Create(ctx context.Context, w http.ResponseWriter, r *http.Req
 
 	var np product.NewProduct
 
-	if err := web.Decode(r, &np); err != nil {
+	if err := web.Decode(ctx, r, &np); err != nil {
 		return err
 	}
 
 	prod, err := product.Create(ctx, p.DB, claims, np, time.Now())
 	if err != nil {
+		if webErr := matchPredefinedErrors(err); webErr != nil {
+			return webErr
+		}
+
 		return err
 	}
 
@@ -90,7 +104,7 @@ func (p *Product) UpdateProduct(ctx context.Context, w http.ResponseWriter, r *h
 	id := chi.URLParam(r, "id")
 
 	var updates product.UpdateProduct
-	if err := web.Decode(r, &updates); err != nil {
+	if err := web.Decode(ctx, r, &updates); err != nil {
 		return err
 	}
 
@@ -108,10 +122,19 @@ func (p *Product) UpdateProduct(ctx context.Context, w http.ResponseWriter, r *h
 
 // DeleteProduct removes a single Product indentified by an ID in the request URL
 func (p *Product) DeleteProduct(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return errors.New("auth claims not in context")
+	}
+
 	id := chi.URLParam(r, "id")
 
-	if err := product.Delete(ctx, p.DB, id); err != nil {
-		return err
+	if err := product.Delete(ctx, p.DB, claims, id); err != nil {
+		if webErr := matchPredefinedErrors(err); webErr != nil {
+			return webErr
+		}
+
+		return errors.Wrapf(err, "deleting product %v", id)
 	}
 
 	return web.Respond(ctx, w, nil, http.StatusNoContent)
@@ -120,15 +143,20 @@ func (p *Product) DeleteProduct(ctx context.Context, w http.ResponseWriter, r *h
 // AddSale creates a new Sale for a particular product. It looks for a JSON
 // object in the request body. The full model is returned to the caller.
 func (p *Product) AddSale(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return errors.New("auth claims not in context")
+	}
+
 	var ns product.NewSale
 
-	if err := web.Decode(r, &ns); err != nil {
+	if err := web.Decode(ctx, r, &ns); err != nil {
 		return err
 	}
 
 	id := chi.URLParam(r, "product_id")
 
-	sale, err := product.AddSale(ctx, p.DB, ns, id, time.Now())
+	sale, err := product.AddSale(ctx, p.DB, claims, ns, id, time.Now())
 	if err != nil {
 		if webErr := matchPredefinedErrors(err); webErr != nil {
 			return webErr
@@ -142,9 +170,14 @@ func (p *Product) AddSale(ctx context.Context, w http.ResponseWriter, r *http.Re
 
 // ListSales gets all Sales for a particular Product
 func (p *Product) ListSales(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return errors.New("auth claims not in context")
+	}
+
 	id := chi.URLParam(r, "product_id")
 
-	sales, err := product.ListSales(ctx, p.DB, id)
+	sales, err := product.ListSales(ctx, p.DB, claims, id)
 	if err != nil {
 		if webErr := matchPredefinedErrors(err); webErr != nil {
 			return webErr
@@ -0,0 +1,145 @@
+package handlers
+
+import (
+	"context"
+	"garagesale/internal/platform/auth"
+	"garagesale/internal/platform/role"
+	"garagesale/internal/platform/web"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+)
+
+// Roles holds handlers for managing the roles of an organization
+type Roles struct {
+	DB *sqlx.DB
+}
+
+// matchRoleErrors knows how to respond for known role failure scenarios
+func matchRoleErrors(err error) error {
+	switch errors.Cause(err) {
+	case role.ErrNotFound, role.ErrNotOrgMember:
+		return web.NewRequestError(err, http.StatusNotFound)
+	case role.ErrUnknownPermission:
+		return web.NewRequestError(err, http.StatusBadRequest)
+	case role.ErrExists:
+		return web.NewRequestError(err, http.StatusConflict)
+	case role.ErrBuiltIn, role.ErrForbidden, role.ErrPermissionDenied:
+		return web.NewRequestError(err, http.StatusForbidden)
+	default:
+		return nil
+	}
+}
+
+// List gives the built-in roles and the roles of the caller's organization
+func (rl *Roles) List(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return errors.New("auth claims not in context")
+	}
+
+	list, err := role.List(ctx, rl.DB, claims)
+	if err != nil {
+		if webErr := matchRoleErrors(err); webErr != nil {
+			return webErr
+		}
+
+		return err
+	}
+
+	return web.Respond(ctx, w, list, http.StatusOK)
+}
+
+// Retrieve gives a single role
+func (rl *Roles) Retrieve(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return errors.New("auth claims not in context")
+	}
+
+	name := chi.URLParam(r, "name")
+
+	rol, err := role.Retrieve(ctx, rl.DB, claims, name)
+	if err != nil {
+		if webErr := matchRoleErrors(err); webErr != nil {
+			return webErr
+		}
+
+		return errors.Wrapf(err, "looking for role %v", name)
+	}
+
+	return web.Respond(ctx, w, rol, http.StatusOK)
+}
+
+// Create decodes a JSON from a POST request and creates a new role
+func (rl *Roles) Create(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return errors.New("auth claims not in context")
+	}
+
+	var nr role.NewRole
+	if err := web.Decode(ctx, r, &nr); err != nil {
+		return err
+	}
+
+	rol, err := role.Create(ctx, rl.DB, claims, nr, time.Now())
+	if err != nil {
+		if webErr := matchRoleErrors(err); webErr != nil {
+			return webErr
+		}
+
+		return errors.Wrapf(err, "creating role %v", nr.Name)
+	}
+
+	return web.Respond(ctx, w, rol, http.StatusCreated)
+}
+
+// Update replaces the permissions of a role
+func (rl *Roles) Update(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return errors.New("auth claims not in context")
+	}
+
+	name := chi.URLParam(r, "name")
+
+	var ur role.UpdateRole
+	if err := web.Decode(ctx, r, &ur); err != nil {
+		return err
+	}
+
+	rol, err := role.Update(ctx, rl.DB, claims, name, ur, time.Now())
+	if err != nil {
+		if webErr := matchRoleErrors(err); webErr != nil {
+			return webErr
+		}
+
+		return errors.Wrapf(err, "updating role %v", name)
+	}
+
+	return web.Respond(ctx, w, rol, http.StatusOK)
+}
+
+// Delete removes a role
+func (rl *Roles) Delete(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return errors.New("auth claims not in context")
+	}
+
+	name := chi.URLParam(r, "name")
+
+	if err := role.Delete(ctx, rl.DB, claims, name); err != nil {
+		if webErr := matchRoleErrors(err); webErr != nil {
+			return webErr
+		}
+
+		return errors.Wrapf(err, "deleting role %v", name)
+	}
+
+	return web.Respond(ctx, w, nil, http.StatusNoContent)
+}
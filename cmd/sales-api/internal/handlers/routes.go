@@ -1,48 +1,199 @@
 package handlers
 
 import (
+	"context"
+	"crypto/x509"
 	"garagesale/internal/middleware"
+	"garagesale/internal/platform/apikey"
 	"garagesale/internal/platform/auth"
+	"garagesale/internal/platform/mail"
+	"garagesale/internal/platform/revocation"
+	"garagesale/internal/platform/session"
+	"garagesale/internal/platform/user"
 	"garagesale/internal/platform/web"
 	"log"
 	"net/http"
+	"time"
 
 	"github.com/jmoiron/sqlx"
 )
 
-func API(log *log.Logger, db *sqlx.DB, authenticator *auth.Authenticator) http.Handler {
-	app := web.NewApp(log, middleware.Logger(log), middleware.Errors(log), middleware.Metric())
+// Config holds settings of the API that are not dependencies
+type Config struct {
+	// RequireAdminTwoFactor issues tokens without the admin role to admins
+	// that have not enabled two-factor authentication
+	RequireAdminTwoFactor bool
 
-	c := Check{DB: db}
-	app.Handle(http.MethodGet, "/v1/health", c.Health)
+	// TwoFactorIssuer names the service in authenticator apps
+	TwoFactorIssuer string
+
+	// PasswordPolicy decides how passwords are hashed. Outdated hashes are
+	// replaced on the next successful login
+	PasswordPolicy auth.PasswordPolicy
+
+	// OIDC is an external identity provider whose tokens are accepted next to
+	// our own. Users of it are provisioned on their first request. It is
+	// disabled when nil
+	OIDC *auth.OIDCProvider
+
+	// Session configures cookie based browser sessions. They are disabled
+	// when its CookieName is blank
+	Session session.Config
+
+	// Mailer sends the emails of the API, such as email verifications.
+	// Email changes are rejected when it is nil as they could never be
+	// verified
+	Mailer mail.Mailer
+}
+
+// statusCacheTTL is how long a user status is trusted before it is read again.
+// Changes made by this process are seen right away.
+const statusCacheTTL = 30 * time.Second
+
+// revocationCacheTTL is how long a token is trusted to not be revoked before
+// the revocation list is read again. Revocations made by this process are
+// seen right away.
+const revocationCacheTTL = 30 * time.Second
+
+func API(log *log.Logger, db *sqlx.DB, authenticator *auth.Authenticator, cfg Config) http.Handler {
+	mw := []web.Middleware{middleware.Logger(log), middleware.Errors(log), middleware.Metric()}
+
+	var sessions middleware.SessionFunc
+	if cfg.Session.CookieName != "" {
+		mw = append(mw, middleware.CSRF(cfg.Session.CookieName, cfg.Session.CSRFCookieName, cfg.Session.CSRFHeader))
+
+		sessions = func(ctx context.Context, r *http.Request) (auth.Claims, bool, error) {
+			c, err := r.Cookie(cfg.Session.CookieName)
+			if err != nil {
+				return auth.Claims{}, false, nil
+			}
+
+			s, err := session.Authenticate(ctx, db, cfg.Session, c.Value, time.Now())
+			if err != nil {
+				return auth.Claims{}, true, err
+			}
+
+			claims, err := user.SessionClaims(ctx, db, s, cfg.RequireAdminTwoFactor, time.Now())
+			return claims, true, err
+		}
+	}
+
+	app := web.NewApp(log, mw...)
+	registerChecks(app, db)
+	app.RegisterErrorCodes(errorCodes)
+	for locale, messages := range errorMessages {
+		app.RegisterMessages(locale, messages)
+	}
+
+	status := user.NewStatusCache(db, statusCacheTTL)
+	apiKeys := func(ctx context.Context, key string) (auth.Claims, error) {
+		return apikey.Authenticate(ctx, db, time.Now(), key)
+	}
+	revoked := revocation.NewCache(revocation.NewPostgres(db), revocationCacheTTL)
+	// Client certificates are only verified when the server was started with
+	// a CA bundle for them
+	certs := func(ctx context.Context, cert *x509.Certificate) (auth.Claims, error) {
+		return user.AuthenticateCertificate(ctx, db, cert, cfg.RequireAdminTwoFactor, time.Now())
+	}
 
 	u := Users{
 		DB:            db,
 		Log:           log,
 		authenticator: authenticator,
+		status:        status,
+		revoked:       revoked,
+		cfg:           cfg,
+	}
+
+	opts := middleware.AuthOptions{
+		APIKeys:  apiKeys,
+		Sessions: sessions,
+		Certs:    certs,
+		Checks:   []middleware.ClaimsCheck{status.Check, revoked.Check},
 	}
-	app.Handle(http.MethodGet, "/v1/user/token", u.Token)
+	if cfg.OIDC != nil {
+		opts.External = u.authenticateExternal
+	}
+	authenticate := middleware.Authenticate(authenticator, opts)
+
+	c := Check{DB: db}
+	app.Handle(http.MethodGet, "/v1/health", c.Health)
+
+	keys := Keys{authenticator: authenticator}
+	app.Handle(http.MethodGet, "/.well-known/jwks.json", keys.JWKS)
+
+	tokens := app.Group("/v1/user")
+	tokens.Handle(http.MethodGet, "/token", u.Token)
+	tokens.Handle(http.MethodPost, "/token/2fa", u.TwoFactorToken)
+	tokens.Handle(http.MethodPost, "/token/refresh", u.RefreshToken)
+	tokens.Handle(http.MethodPost, "/token/revoke", u.RevokeToken)
+	tokens.Handle(http.MethodPost, "/logout", u.Logout)
+
+	if cfg.Session.CookieName != "" {
+		tokens.Handle(http.MethodPost, "/session", u.Login)
+		tokens.Handle(http.MethodPost, "/session/2fa", u.LoginTwoFactor)
+		tokens.Handle(http.MethodDelete, "/session", u.EndSession)
+	}
+
+	// Everything else requires the caller to be authenticated
+	v1 := app.Group("/v1", authenticate)
+
+	me := v1.Group("/users/me")
+	me.Handle(http.MethodGet, "", u.Me)
+
+	// Credentials, including the email used to recover them, can only be
+	// changed by the users themselves
+	notImpersonated := middleware.DenyImpersonation()
+	credentials := me.Group("", notImpersonated)
+	credentials.Handle(http.MethodPatch, "", u.UpdateMe)
+	credentials.Handle(http.MethodPost, "/email/verify", u.VerifyEmail)
+	credentials.Handle(http.MethodPut, "/password", u.ChangePassword)
+	credentials.Handle(http.MethodPost, "/2fa", u.EnrollTwoFactor)
+	credentials.Handle(http.MethodPost, "/2fa/confirm", u.ConfirmTwoFactor)
+	credentials.Handle(http.MethodPost, "/2fa/disable", u.DisableTwoFactor)
+
+	manageUsers := v1.Group("/users/{id}", middleware.RequirePermission(auth.PermUserManage))
+	manageUsers.Handle(http.MethodPut, "/status", u.SetStatus)
+	manageUsers.Handle(http.MethodPut, "/roles", u.SetRoles)
+	v1.Handle(
+		http.MethodPost, "/users/{id}/impersonate", u.Impersonate,
+		notImpersonated, middleware.RequirePermission(auth.PermUserImpersonate),
+	)
+
+	k := APIKeys{DB: db}
+	me.Handle(http.MethodGet, "/keys", k.List)
+	credentials.Handle(http.MethodPost, "/keys", k.Create)
+	me.Handle(http.MethodDelete, "/keys/{key_id}", k.Revoke)
+	manageUsers.Handle(http.MethodGet, "/keys", k.List)
+	manageUsers.Handle(http.MethodPost, "/keys", k.Create, notImpersonated)
+	manageUsers.Handle(http.MethodDelete, "/keys/{key_id}", k.Revoke)
+
+	rl := Roles{DB: db}
+	roles := v1.Group("/roles", middleware.RequirePermission(auth.PermRoleManage))
+	roles.Handle(http.MethodGet, "", rl.List)
+	roles.Handle(http.MethodPost, "", rl.Create)
+	roles.Handle(http.MethodGet, "/{name}", rl.Retrieve)
+	roles.Handle(http.MethodPut, "/{name}", rl.Update)
+	roles.Handle(http.MethodDelete, "/{name}", rl.Delete)
 
 	p := Product{
 		DB:  db,
 		Log: log,
 	}
+	products := v1.Group("/products")
 	// LIST
-	app.Handle(http.MethodGet, "/v1/products", p.List, middleware.Authenticate(authenticator))
+	products.Handle(http.MethodGet, "", p.List, middleware.RequirePermission(auth.PermProductRead))
 	// CREATE
-	app.Handle(http.MethodPost, "/v1/products", p.Create, middleware.Authenticate(authenticator))
+	products.Handle(http.MethodPost, "", p.Create, middleware.RequirePermission(auth.PermProductCreate))
 	// RETRIEVE
-	app.Handle(http.MethodGet, "/v1/products/{id}", p.Retrieve, middleware.Authenticate(authenticator))
+	products.Handle(http.MethodGet, "/{id}", p.Retrieve, middleware.RequirePermission(auth.PermProductRead))
 	// UPDATE
-	app.Handle(http.MethodPatch, "/v1/products/{id}", p.UpdateProduct, middleware.Authenticate(authenticator))
+	products.Handle(http.MethodPatch, "/{id}", p.UpdateProduct, middleware.RequirePermission(auth.PermProductUpdate))
 	// DELETE
-	app.Handle(
-		http.MethodDelete, "/v1/products/{id}", p.DeleteProduct,
-		middleware.Authenticate(authenticator), middleware.HasRoles(auth.RoleAdmin),
-	)
+	products.Handle(http.MethodDelete, "/{id}", p.DeleteProduct, middleware.RequirePermission(auth.PermProductDelete))
 
-	app.Handle(http.MethodPost, "/v1/products/{product_id}/sales", p.AddSale, middleware.Authenticate(authenticator))
-	app.Handle(http.MethodGet, "/v1/products/{product_id}/sales", p.ListSales, middleware.Authenticate(authenticator))
+	products.Handle(http.MethodPost, "/{product_id}/sales", p.AddSale, middleware.RequirePermission(auth.PermSaleCreate))
+	products.Handle(http.MethodGet, "/{product_id}/sales", p.ListSales, middleware.RequirePermission(auth.PermSaleRead))
 
 	return app
 }
@@ -0,0 +1,90 @@
+package handlers
+
+import (
+	"context"
+	"garagesale/internal/platform/auth"
+	"garagesale/internal/platform/session"
+	"garagesale/internal/platform/web"
+	"net/http"
+
+	"github.com/pkg/errors"
+)
+
+// Login starts a browser session for a user. The client must include an email
+// and password using HTTP Basic Auth, as for Token. Instead of a token the
+// response sets the session and CSRF cookies. Users with two-factor
+// authentication get a challenge token to complete through LoginTwoFactor.
+func (u *Users) Login(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	v, ok := ctx.Value(web.KeyValues).(*web.ContexValues)
+	if !ok {
+		return web.ErrContextValueMissing
+	}
+
+	claims, err := u.login(ctx, r, v.Start)
+	if err != nil {
+		return err
+	}
+
+	if claims.Purpose == auth.PurposeTwoFactor {
+		var tkn struct {
+			ChallengeToken string `json:"challenge_token"`
+		}
+
+		tkn.ChallengeToken, err = u.authenticator.GenerateToken(claims)
+		if err != nil {
+			return errors.Wrapf(err, "generating challenge token")
+		}
+
+		return web.Respond(ctx, w, tkn, http.StatusOK)
+	}
+
+	return u.startSession(ctx, w, claims)
+}
+
+// LoginTwoFactor exchanges a challenge token from Login and a second factor
+// for a browser session
+func (u *Users) LoginTwoFactor(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	v, ok := ctx.Value(web.KeyValues).(*web.ContexValues)
+	if !ok {
+		return web.ErrContextValueMissing
+	}
+
+	claims, err := u.completeTwoFactor(ctx, r, v.Start)
+	if err != nil {
+		return err
+	}
+
+	return u.startSession(ctx, w, claims)
+}
+
+// EndSession deletes the browser session of the request and its cookies
+func (u *Users) EndSession(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	if c, err := r.Cookie(u.cfg.Session.CookieName); err == nil {
+		if err := session.Delete(ctx, u.DB, c.Value); err != nil {
+			return errors.Wrap(err, "ending session")
+		}
+	}
+
+	session.ClearCookies(w, u.cfg.Session)
+
+	return web.Respond(ctx, w, nil, http.StatusNoContent)
+}
+
+// startSession stores a session for the claims and sets its cookies
+func (u *Users) startSession(ctx context.Context, w http.ResponseWriter, claims auth.Claims) error {
+	v, ok := ctx.Value(web.KeyValues).(*web.ContexValues)
+	if !ok {
+		return web.ErrContextValueMissing
+	}
+
+	token, s, err := session.Create(ctx, u.DB, u.cfg.Session, claims, v.Start)
+	if err != nil {
+		return errors.Wrap(err, "creating session")
+	}
+
+	if err := session.SetCookies(w, u.cfg.Session, token, s); err != nil {
+		return errors.Wrap(err, "setting session cookies")
+	}
+
+	return web.Respond(ctx, w, nil, http.StatusNoContent)
+}
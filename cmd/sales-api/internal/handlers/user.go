@@ -3,48 +3,208 @@ package handlers
 import (
 	"context"
 	"garagesale/internal/platform/auth"
+	"garagesale/internal/platform/mail"
+	"garagesale/internal/platform/organization"
+	"garagesale/internal/platform/revocation"
+	"garagesale/internal/platform/role"
 	"garagesale/internal/platform/user"
 	"garagesale/internal/platform/web"
 	"log"
 	"net/http"
+	"strings"
+	"time"
 
+	"github.com/go-chi/chi"
 	"github.com/pkg/errors"
 
 	"github.com/jmoiron/sqlx"
 )
 
+// errEmailChangeUnavailable rejects email changes while the API has no
+// mailer to send their verification token with
+var errEmailChangeUnavailable error = &web.Error{
+	Err:    errors.New("email changes are unavailable until a mail server is configured"),
+	Status: http.StatusServiceUnavailable,
+	Code:   "email_change_unavailable",
+}
+
 // Users holds handlers for dealing with user
 type Users struct {
 	DB            *sqlx.DB
 	Log           *log.Logger
 	authenticator *auth.Authenticator
+	status        *user.StatusCache
+	revoked       *revocation.Cache
+	cfg           Config
 }
 
 // Token generates an authentication token for a user. The client must include an email
-// and password for the request using HTTP Basic Auth
+// and password for the request using HTTP Basic Auth. The optional org query parameter
+// selects the organization the token is issued for.
 func (u *Users) Token(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
 	v, ok := ctx.Value(web.KeyValues).(*web.ContexValues)
 	if !ok {
 		return web.ErrContextValueMissing
 	}
 
+	claims, err := u.login(ctx, r, v.Start)
+	if err != nil {
+		return err
+	}
+
+	var tkn struct {
+		Token          string `json:"token,omitempty"`
+		RefreshToken   string `json:"refresh_token,omitempty"`
+		ChallengeToken string `json:"challenge_token,omitempty"`
+	}
+
+	// Users with two-factor authentication get a challenge to exchange
+	// through TwoFactorToken
+	if claims.Purpose == auth.PurposeTwoFactor {
+		tkn.ChallengeToken, err = u.authenticator.GenerateToken(claims)
+		if err != nil {
+			return errors.Wrapf(err, "generating challenge token")
+		}
+
+		return web.Respond(ctx, w, tkn, http.StatusOK)
+	}
+
+	tkn.Token, err = u.authenticator.GenerateToken(claims)
+	if err != nil {
+		return errors.Wrapf(err, "generating token")
+	}
+
+	tkn.RefreshToken, err = user.IssueRefreshToken(ctx, u.DB, claims, v.Start)
+	if err != nil {
+		return errors.Wrap(err, "issuing refresh token")
+	}
+
+	return web.Respond(ctx, w, tkn, http.StatusOK)
+}
+
+// login verifies the email and password from the HTTP Basic Auth of the request.
+// It returns the claims of the user inside the organization selected by the org
+// query parameter, or a challenge when the user has two-factor authentication.
+func (u *Users) login(ctx context.Context, r *http.Request, now time.Time) (auth.Claims, error) {
 	email, pass, ok := r.BasicAuth()
 	if !ok {
-		return web.NewRequestError(errors.New("must provide email and password in Basic auth"), http.StatusUnauthorized)
+		return auth.Claims{}, web.NewRequestError(errors.New("must provide email and password in Basic auth"), http.StatusUnauthorized)
 	}
 
-	claims, err := user.Authenticate(ctx, u.DB, v.Start, email, pass)
+	claims, err := user.Authenticate(ctx, u.DB, u.cfg.PasswordPolicy, now, email, pass, r.URL.Query().Get("org"))
 	if err != nil {
 		switch err {
-		case user.ErrAuthenticationFailure:
-			return web.NewRequestError(err, http.StatusUnauthorized)
+		case user.ErrAuthenticationFailure, user.ErrDisabled:
+			return auth.Claims{}, web.NewRequestError(err, http.StatusUnauthorized)
+		case user.ErrNoMembership:
+			return auth.Claims{}, web.NewRequestError(err, http.StatusForbidden)
 		default:
-			return errors.Wrap(err, "auth")
+			return auth.Claims{}, errors.Wrap(err, "auth")
+		}
+	}
+
+	if claims.Purpose == auth.PurposeTwoFactor {
+		return claims, nil
+	}
+
+	return u.restrictAdmin(ctx, claims, false)
+}
+
+// completeTwoFactor decodes a challenge token issued by login and a second
+// factor from the request body and returns the claims of the user
+func (u *Users) completeTwoFactor(ctx context.Context, r *http.Request, now time.Time) (auth.Claims, error) {
+	var req struct {
+		ChallengeToken string `json:"challenge_token" validate:"required"`
+		Code           string `json:"code" validate:"required"`
+	}
+	if err := web.Decode(ctx, r, &req); err != nil {
+		return auth.Claims{}, err
+	}
+
+	challenge, err := u.authenticator.ParseClaims(req.ChallengeToken)
+	if err != nil {
+		if webErr := matchTokenErrors(err); webErr != nil {
+			return auth.Claims{}, webErr
 		}
+
+		return auth.Claims{}, errors.Wrap(err, "parsing challenge token")
+	}
+
+	claims, err := user.CompleteTwoFactor(ctx, u.DB, challenge, req.Code, now)
+	if err != nil {
+		if webErr := matchUserErrors(err); webErr != nil {
+			return auth.Claims{}, webErr
+		}
+
+		return auth.Claims{}, errors.Wrap(err, "completing two-factor authentication")
+	}
+
+	return claims, nil
+}
+
+// authenticateExternal returns the claims of a token issued by the OIDC
+// provider. Admins are restricted as when they log in with a password.
+func (u *Users) authenticateExternal(ctx context.Context, token string) (auth.Claims, bool, error) {
+	if !u.cfg.OIDC.Issued(token) {
+		return auth.Claims{}, false, nil
+	}
+
+	id, err := u.cfg.OIDC.Verify(ctx, token)
+	if err != nil {
+		return auth.Claims{}, true, err
+	}
+
+	claims, err := user.AuthenticateExternal(ctx, u.DB, id, u.cfg.OIDC.OrgID(), time.Now())
+	if err != nil {
+		return auth.Claims{}, true, err
+	}
+
+	if u.cfg.RequireAdminTwoFactor && claims.HasRoles(auth.RoleAdmin) {
+		usr, err := user.Retrieve(ctx, u.DB, claims.Subject)
+		if err != nil {
+			return auth.Claims{}, true, errors.Wrapf(err, "looking for user %v", claims.Subject)
+		}
+
+		claims, err = u.restrictAdmin(ctx, claims, usr.TOTPEnabled)
+		if err != nil {
+			return auth.Claims{}, true, err
+		}
+	}
+
+	return claims, true, nil
+}
+
+// restrictAdmin removes the admin role from claims of users without two-factor
+// authentication when the API requires it for admins
+func (u *Users) restrictAdmin(ctx context.Context, claims auth.Claims, twoFactor bool) (auth.Claims, error) {
+	if twoFactor || !u.cfg.RequireAdminTwoFactor || !claims.HasRoles(auth.RoleAdmin) {
+		return claims, nil
+	}
+
+	claims, err := user.WithoutRoles(ctx, u.DB, claims, auth.RoleAdmin)
+	if err != nil {
+		return auth.Claims{}, errors.Wrap(err, "restricting admin without two-factor")
+	}
+
+	return claims, nil
+}
+
+// TwoFactorToken exchanges a challenge token from Token and a second factor
+// for an authentication token
+func (u *Users) TwoFactorToken(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	v, ok := ctx.Value(web.KeyValues).(*web.ContexValues)
+	if !ok {
+		return web.ErrContextValueMissing
+	}
+
+	claims, err := u.completeTwoFactor(ctx, r, v.Start)
+	if err != nil {
+		return err
 	}
 
 	var tkn struct {
-		Token string `json:"token"`
+		Token        string `json:"token"`
+		RefreshToken string `json:"refresh_token"`
 	}
 
 	tkn.Token, err = u.authenticator.GenerateToken(claims)
@@ -52,5 +212,469 @@ func (u *Users) Token(ctx context.Context, w http.ResponseWriter, r *http.Reques
 		return errors.Wrapf(err, "generating token")
 	}
 
+	tkn.RefreshToken, err = user.IssueRefreshToken(ctx, u.DB, claims, v.Start)
+	if err != nil {
+		return errors.Wrap(err, "issuing refresh token")
+	}
+
+	return web.Respond(ctx, w, tkn, http.StatusOK)
+}
+
+// RefreshToken exchanges a refresh token for a new authentication token and
+// the refresh token that replaces it. A refresh token that is used twice
+// revokes every token issued from the same login.
+func (u *Users) RefreshToken(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	v, ok := ctx.Value(web.KeyValues).(*web.ContexValues)
+	if !ok {
+		return web.ErrContextValueMissing
+	}
+
+	var req user.RefreshRequest
+	if err := web.Decode(ctx, r, &req); err != nil {
+		return err
+	}
+
+	claims, refresh, err := user.Refresh(ctx, u.DB, req.RefreshToken, v.Start)
+	if err != nil {
+		if err == user.ErrRefreshTokenReused {
+			u.Log.Printf("refresh token reused, revoked its family")
+		}
+
+		if webErr := matchUserErrors(err); webErr != nil {
+			return webErr
+		}
+
+		return errors.Wrap(err, "refreshing token")
+	}
+
+	if u.cfg.RequireAdminTwoFactor && claims.HasRoles(auth.RoleAdmin) {
+		usr, err := user.Retrieve(ctx, u.DB, claims.Subject)
+		if err != nil {
+			return errors.Wrapf(err, "looking for user %v", claims.Subject)
+		}
+
+		claims, err = u.restrictAdmin(ctx, claims, usr.TOTPEnabled)
+		if err != nil {
+			return err
+		}
+	}
+
+	var tkn struct {
+		Token        string `json:"token"`
+		RefreshToken string `json:"refresh_token"`
+	}
+
+	tkn.RefreshToken = refresh
+	tkn.Token, err = u.authenticator.GenerateToken(claims)
+	if err != nil {
+		return errors.Wrapf(err, "generating token")
+	}
+
+	return web.Respond(ctx, w, tkn, http.StatusOK)
+}
+
+// Logout revokes a refresh token together with every token issued from the
+// same login. The access token the caller presents in the Authorization
+// header is revoked as well so it cannot be used until it expires.
+func (u *Users) Logout(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	var req user.RefreshRequest
+	if err := web.Decode(ctx, r, &req); err != nil {
+		return err
+	}
+
+	if err := user.Logout(ctx, u.DB, req.RefreshToken, time.Now()); err != nil {
+		return errors.Wrap(err, "logging out")
+	}
+
+	parts := strings.Split(r.Header.Get("Authorization"), " ")
+	if len(parts) != 2 || strings.ToLower(parts[0]) != "bearer" {
+		return web.Respond(ctx, w, nil, http.StatusNoContent)
+	}
+
+	// An access token that is already invalid or expired needs no revoking
+	claims, err := u.authenticator.ParseClaims(parts[1])
+	if err != nil {
+		if matchTokenErrors(err) != nil {
+			return web.Respond(ctx, w, nil, http.StatusNoContent)
+		}
+
+		return errors.Wrap(err, "parsing access token")
+	}
+
+	if claims.Id != "" {
+		if err := u.revoked.Revoke(ctx, claims); err != nil {
+			return errors.Wrapf(err, "revoking token %v", claims.Id)
+		}
+	}
+
+	return web.Respond(ctx, w, nil, http.StatusNoContent)
+}
+
+// RevokeToken revokes an authentication token before it expires. Holding the
+// token is enough to revoke it, so a leaked token can be revoked by anyone
+// who finds it.
+func (u *Users) RevokeToken(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	var req struct {
+		Token string `json:"token" validate:"required"`
+	}
+	if err := web.Decode(ctx, r, &req); err != nil {
+		return err
+	}
+
+	claims, err := u.authenticator.ParseClaims(req.Token)
+	if err != nil {
+		if webErr := matchTokenErrors(err); webErr != nil {
+			return webErr
+		}
+
+		return errors.Wrap(err, "parsing token")
+	}
+
+	if claims.Id == "" {
+		err := errors.New("token has no ID and cannot be revoked")
+		return web.NewRequestError(err, http.StatusBadRequest)
+	}
+
+	if err := u.revoked.Revoke(ctx, claims); err != nil {
+		return errors.Wrapf(err, "revoking token %v", claims.Id)
+	}
+
+	return web.Respond(ctx, w, nil, http.StatusNoContent)
+}
+
+// matchUserErrors knows how to respond for known user failure scenarios
+func matchUserErrors(err error) error {
+	switch err {
+	case user.ErrNotFound:
+		return web.NewRequestError(err, http.StatusNotFound)
+	case user.ErrInvalidID, user.ErrInvalidVerification, user.ErrInvalidStatus:
+		return web.NewRequestError(err, http.StatusBadRequest)
+	case user.ErrEmailTaken, user.ErrTwoFactorEnabled:
+		return web.NewRequestError(err, http.StatusConflict)
+	case user.ErrTwoFactorNotEnabled, user.ErrTwoFactorNotStarted:
+		return web.NewRequestError(err, http.StatusBadRequest)
+	case user.ErrInvalidCode, user.ErrInvalidChallenge, user.ErrDisabled, user.ErrTokenRevoked,
+		user.ErrInvalidRefreshToken, user.ErrRefreshTokenReused:
+		return web.NewRequestError(err, http.StatusUnauthorized)
+	case user.ErrNoMembership:
+		return web.NewRequestError(err, http.StatusForbidden)
+	case user.ErrTooManyAttempts:
+		return web.NewRequestError(err, http.StatusTooManyRequests)
+	default:
+		return nil
+	}
+}
+
+// matchTokenErrors knows how to respond for tokens we do not accept
+func matchTokenErrors(err error) error {
+	switch errors.Cause(err) {
+	case auth.ErrInvalidToken, auth.ErrTokenExpired, auth.ErrTokenNotYetValid,
+		auth.ErrInvalidIssuer, auth.ErrInvalidAudience:
+		return web.NewRequestError(err, http.StatusUnauthorized)
+	default:
+		return nil
+	}
+}
+
+// Me returns the profile of the user identified by the token claims together
+// with the organization and roles the token was issued for
+func (u *Users) Me(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return errors.New("auth claims not in context")
+	}
+
+	usr, err := user.Retrieve(ctx, u.DB, claims.Subject)
+	if err != nil {
+		if webErr := matchUserErrors(err); webErr != nil {
+			return webErr
+		}
+
+		return errors.Wrapf(err, "looking for user %v", claims.Subject)
+	}
+
+	memberships, err := organization.Memberships(ctx, u.DB, claims.Subject)
+	if err != nil {
+		return errors.Wrapf(err, "looking for memberships of user %v", claims.Subject)
+	}
+
+	me := struct {
+		*user.User
+		OrgID       string                    `json:"org_id"`
+		Roles       []string                  `json:"roles"`
+		Memberships []organization.Membership `json:"memberships"`
+	}{
+		User:        usr,
+		OrgID:       claims.OrgID,
+		Roles:       claims.Roles,
+		Memberships: memberships,
+	}
+
+	return web.Respond(ctx, w, me, http.StatusOK)
+}
+
+// UpdateMe decodes the body of a request to update the profile of the current user.
+// A changed email stays pending until it is confirmed through VerifyEmail with
+// the token mailed to the new address.
+func (u *Users) UpdateMe(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return errors.New("auth claims not in context")
+	}
+
+	var uu user.UpdateUser
+	if err := web.Decode(ctx, r, &uu); err != nil {
+		return err
+	}
+
+	if uu.Email != nil && u.cfg.Mailer == nil {
+		return errEmailChangeUnavailable
+	}
+
+	usr, token, err := user.Update(ctx, u.DB, claims.Subject, uu, time.Now())
+	if err != nil {
+		if webErr := matchUserErrors(err); webErr != nil {
+			return webErr
+		}
+
+		return errors.Wrapf(err, "updating user %v", claims.Subject)
+	}
+
+	if token != "" {
+		msg := mail.Message{
+			To:      *usr.PendingEmail,
+			Subject: "Confirm your new email address",
+			Body:    "Use this token to confirm your new email address: " + token,
+		}
+		if err := u.cfg.Mailer.Send(ctx, msg); err != nil {
+			return errors.Wrapf(err, "sending email verification to user %v", usr.ID)
+		}
+	}
+
+	return web.Respond(ctx, w, usr, http.StatusOK)
+}
+
+// VerifyEmail confirms a pending email change of the current user
+func (u *Users) VerifyEmail(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return errors.New("auth claims not in context")
+	}
+
+	var ve user.VerifyEmail
+	if err := web.Decode(ctx, r, &ve); err != nil {
+		return err
+	}
+
+	usr, err := user.VerifyEmailChange(ctx, u.DB, claims.Subject, ve.Token, time.Now())
+	if err != nil {
+		if webErr := matchUserErrors(err); webErr != nil {
+			return webErr
+		}
+
+		return errors.Wrapf(err, "verifying email of user %v", claims.Subject)
+	}
+
+	return web.Respond(ctx, w, usr, http.StatusOK)
+}
+
+// ChangePassword replaces the password of the current user. The current
+// password must be provided. Every token and session of the user is revoked.
+func (u *Users) ChangePassword(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return errors.New("auth claims not in context")
+	}
+
+	var up user.UpdatePassword
+	if err := web.Decode(ctx, r, &up); err != nil {
+		return err
+	}
+
+	if err := user.ChangePassword(ctx, u.DB, u.cfg.PasswordPolicy, claims.Subject, up, time.Now()); err != nil {
+		if err == user.ErrAuthenticationFailure {
+			return web.NewRequestError(errors.New("current password is wrong"), http.StatusForbidden)
+		}
+
+		if webErr := matchUserErrors(err); webErr != nil {
+			return webErr
+		}
+
+		return errors.Wrapf(err, "changing password of user %v", claims.Subject)
+	}
+
+	// The change revoked every token of the user, this one included
+	u.status.Invalidate(claims.Subject)
+
+	return web.Respond(ctx, w, nil, http.StatusNoContent)
+}
+
+// Impersonate issues a short-lived token for the user identified by an ID in
+// the request URL. The token names the caller as its actor so every request
+// made with it can be traced back to them.
+func (u *Users) Impersonate(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return errors.New("auth claims not in context")
+	}
+
+	id := chi.URLParam(r, "id")
+
+	impersonated, err := user.Impersonate(ctx, u.DB, claims, id, time.Now())
+	if err != nil {
+		switch err {
+		case user.ErrCannotImpersonate:
+			return web.NewRequestError(err, http.StatusForbidden)
+		case user.ErrDisabled:
+			return web.NewRequestError(err, http.StatusConflict)
+		}
+
+		if webErr := matchUserErrors(err); webErr != nil {
+			return webErr
+		}
+
+		return errors.Wrapf(err, "impersonating user %v", id)
+	}
+
+	var tkn struct {
+		Token     string    `json:"token"`
+		ExpiresAt time.Time `json:"expires_at"`
+	}
+
+	tkn.ExpiresAt = time.Unix(impersonated.ExpiresAt, 0).UTC()
+	tkn.Token, err = u.authenticator.GenerateToken(impersonated)
+	if err != nil {
+		return errors.Wrapf(err, "generating token")
+	}
+
+	u.Log.Printf("user %s started impersonating user %s until %s", claims.Subject, id, tkn.ExpiresAt.Format(time.RFC3339))
+
 	return web.Respond(ctx, w, tkn, http.StatusOK)
 }
+
+// SetStatus activates or disables the user identified by an ID in the request URL.
+// Disabling a user revokes every token issued to them. Only members of the
+// organization of the caller can be changed.
+func (u *Users) SetStatus(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return errors.New("auth claims not in context")
+	}
+
+	id := chi.URLParam(r, "id")
+
+	var us user.UpdateStatus
+	if err := web.Decode(ctx, r, &us); err != nil {
+		return err
+	}
+
+	if err := u.status.SetStatus(ctx, claims.OrgID, id, us.Status, time.Now()); err != nil {
+		if webErr := matchUserErrors(err); webErr != nil {
+			return webErr
+		}
+
+		return errors.Wrapf(err, "setting status of user %v", id)
+	}
+
+	return web.Respond(ctx, w, nil, http.StatusNoContent)
+}
+
+// SetRoles replaces the roles the user identified by an ID in the request URL
+// has in the organization of the caller. Tokens issued to the user are
+// revoked so they cannot keep using permissions they just lost.
+func (u *Users) SetRoles(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return errors.New("auth claims not in context")
+	}
+
+	id := chi.URLParam(r, "id")
+
+	var ar role.AssignRoles
+	if err := web.Decode(ctx, r, &ar); err != nil {
+		return err
+	}
+
+	now := time.Now()
+
+	if err := role.Assign(ctx, u.DB, claims, id, ar, now); err != nil {
+		if webErr := matchRoleErrors(err); webErr != nil {
+			return webErr
+		}
+
+		return errors.Wrapf(err, "assigning roles to user %v", id)
+	}
+
+	if err := u.status.RevokeTokens(ctx, claims.OrgID, id, now); err != nil {
+		return errors.Wrapf(err, "revoking tokens of user %v", id)
+	}
+
+	return web.Respond(ctx, w, nil, http.StatusNoContent)
+}
+
+// EnrollTwoFactor starts two-factor enrollment for the current user. The
+// response holds the secret, its provisioning URI and recovery codes.
+func (u *Users) EnrollTwoFactor(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return errors.New("auth claims not in context")
+	}
+
+	enrollment, err := user.EnrollTOTP(ctx, u.DB, claims.Subject, u.cfg.TwoFactorIssuer, time.Now())
+	if err != nil {
+		if webErr := matchUserErrors(err); webErr != nil {
+			return webErr
+		}
+
+		return errors.Wrapf(err, "enrolling two-factor for user %v", claims.Subject)
+	}
+
+	return web.Respond(ctx, w, enrollment, http.StatusCreated)
+}
+
+// ConfirmTwoFactor enables two-factor authentication for the current user
+// with a code from their authenticator app
+func (u *Users) ConfirmTwoFactor(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return errors.New("auth claims not in context")
+	}
+
+	var code user.TOTPCode
+	if err := web.Decode(ctx, r, &code); err != nil {
+		return err
+	}
+
+	if err := user.ConfirmTOTP(ctx, u.DB, claims.Subject, code.Code, time.Now()); err != nil {
+		if webErr := matchUserErrors(err); webErr != nil {
+			return webErr
+		}
+
+		return errors.Wrapf(err, "confirming two-factor for user %v", claims.Subject)
+	}
+
+	return web.Respond(ctx, w, nil, http.StatusNoContent)
+}
+
+// DisableTwoFactor turns two-factor authentication off for the current user
+func (u *Users) DisableTwoFactor(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	claims, ok := ctx.Value(auth.Key).(auth.Claims)
+	if !ok {
+		return errors.New("auth claims not in context")
+	}
+
+	var code user.TOTPCode
+	if err := web.Decode(ctx, r, &code); err != nil {
+		return err
+	}
+
+	if err := user.DisableTOTP(ctx, u.DB, claims.Subject, code.Code, time.Now()); err != nil {
+		if webErr := matchUserErrors(err); webErr != nil {
+			return webErr
+		}
+
+		return errors.Wrapf(err, "disabling two-factor for user %v", claims.Subject)
+	}
+
+	return web.Respond(ctx, w, nil, http.StatusNoContent)
+}
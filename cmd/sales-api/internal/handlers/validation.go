@@ -0,0 +1,38 @@
+package handlers
+
+import (
+	"context"
+	"garagesale/internal/platform/auth"
+	"garagesale/internal/platform/user"
+	"garagesale/internal/platform/web"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// registerChecks adds the rules request bodies are checked against beyond
+// their validate tags
+func registerChecks(app *web.App, db *sqlx.DB) {
+	// A new email must not belong to anyone else. Update checks again as
+	// the email can be taken in the meantime.
+	emailTaken := func(ctx context.Context, val interface{}) (web.FieldError, error) {
+		uu := val.(*user.UpdateUser)
+
+		claims, ok := ctx.Value(auth.Key).(auth.Claims)
+		if uu.Email == nil || !ok {
+			return nil, nil
+		}
+
+		taken, err := user.EmailTaken(ctx, db, *uu.Email, claims.Subject)
+		if err != nil || !taken {
+			return nil, err
+		}
+
+		return web.FieldError{"email": "email_taken"}, nil
+	}
+
+	app.RegisterCheck(user.UpdateUser{}, emailTaken, "email_taken", map[string]string{
+		"en": "{0} is already in use",
+		"ru": "{0} уже используется",
+		"es": "{0} ya está en uso",
+	})
+}
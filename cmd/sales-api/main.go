@@ -2,12 +2,11 @@ package main
 
 import (
 	"context"
-	"crypto/rsa"
-	"io/ioutil"
 	"log"
 	"net/http"
 	"os"
 	"os/signal"
+	"path/filepath"
 	"syscall"
 	"time"
 
@@ -15,9 +14,11 @@ import (
 	"garagesale/cmd/sales-api/internal/handlers"
 	"garagesale/internal/platform/auth"
 	"garagesale/internal/platform/database"
+	"garagesale/internal/platform/mail"
+	"garagesale/internal/platform/session"
+	"garagesale/internal/platform/trace"
 	_ "net/http/pprof" // Register the /debug/pprof handlers
 
-	"github.com/golang-jwt/jwt/v4"
 	"github.com/pkg/errors"
 
 	"github.com/kelseyhightower/envconfig"
@@ -40,17 +41,32 @@ func run() error {
 
 	var cfg struct {
 		DB     database.Config
+		Mail   mail.Config
 		Server struct {
 			Addr                  string        `default:"localhost:3020"`
 			Debug                 string        `default:"localhost:6060"`
 			ReadTimeout           time.Duration `default:"5s" split_words:"true"`
 			WriteTimeout          time.Duration `default:"5s" split_words:"true"`
 			GracefullShutdownTime time.Duration `default:"5s" split_words:"true"`
+			TLSCertFile           string        `envconfig:"TLS_CERT_FILE"`
+			TLSKeyFile            string        `envconfig:"TLS_KEY_FILE"`
 		}
 		Auth struct {
+			KeysDir         string `default:"keys" split_words:"true"`
+			Algorithm       string `default:"RS256"`
+			RequireAdmin2FA bool   `default:"false" envconfig:"REQUIRE_ADMIN_2FA"`
+			TwoFactorIssuer string `default:"garagesale" split_words:"true"`
+			Password        auth.PasswordPolicy
+			Token           auth.TokenPolicy
+			OIDC            auth.OIDCConfig
+			Session         session.Config
+			ClientCert      auth.ClientCertConfig `split_words:"true"`
+
+			// PrivateKeyFromFile and KeyID are the single key settings used
+			// before KeysDir. When the file is set it is used instead of
+			// KeysDir, but its key cannot be rotated.
+			PrivateKeyFromFile string
 			KeyID              string `default:"1"`
-			PrivateKeyFromFile string `default:"private.pem"`
-			Algorithm          string `default:"RS256"`
 		}
 	}
 	err := envconfig.Process("garagesale", &cfg)
@@ -64,15 +80,43 @@ func run() error {
 	// =======================================================
 	// Initialize authentication support
 
-	authenticator, err := createAuth(
-		cfg.Auth.PrivateKeyFromFile,
-		cfg.Auth.KeyID,
-		cfg.Auth.Algorithm,
-	)
+	if err := cfg.Auth.Password.Validate(); err != nil {
+		return errors.Wrap(err, "validating password policy")
+	}
+
+	keys, err := loadKeys(log, cfg.Auth.KeysDir, cfg.Auth.PrivateKeyFromFile, cfg.Auth.KeyID, cfg.Auth.Algorithm)
+	if err != nil {
+		return errors.Wrap(err, "loading auth keys")
+	}
+
+	authenticator, err := auth.NewKeyStoreAuthenticator(keys, cfg.Auth.Token)
 	if err != nil {
 		return errors.Wrap(err, "constructing authenticator")
 	}
 
+	source := cfg.Auth.KeysDir
+	if cfg.Auth.PrivateKeyFromFile != "" {
+		source = cfg.Auth.PrivateKeyFromFile
+	}
+
+	activeKID, _ := keys.Active()
+	log.Printf("main : Auth keys loaded from %s, active key %q", source, activeKID)
+
+	// Reload the keys on SIGHUP so keys can be rotated without a restart
+	reload := make(chan os.Signal, 1)
+	signal.Notify(reload, syscall.SIGHUP)
+	go func() {
+		for range reload {
+			if err := keys.Load(); err != nil {
+				log.Printf("main : Reloading auth keys : %v", err)
+				continue
+			}
+
+			activeKID, _ := keys.Active()
+			log.Printf("main : Auth keys reloaded, active key %q", activeKID)
+		}
+	}()
+
 	// =======================================================
 	// Open DB
 
@@ -93,11 +137,65 @@ func run() error {
 	// =======================================================
 	// Start API service
 
+	apiCfg := handlers.Config{
+		RequireAdminTwoFactor: cfg.Auth.RequireAdmin2FA,
+		TwoFactorIssuer:       cfg.Auth.TwoFactorIssuer,
+		PasswordPolicy:        cfg.Auth.Password,
+		Session:               cfg.Auth.Session,
+	}
+
+	// Without a mail server email changes are rejected as they could never
+	// be verified
+	if cfg.Mail.Host != "" {
+		mailer, err := mail.NewSMTP(cfg.Mail)
+		if err != nil {
+			return errors.Wrap(err, "configuring mail")
+		}
+		apiCfg.Mailer = mailer
+
+		log.Printf("main : Sending mail through %s:%d", cfg.Mail.Host, cfg.Mail.Port)
+	} else {
+		log.Println("main : No mail server configured, email changes are disabled")
+	}
+
+	if err := cfg.Auth.Session.Validate(); err != nil {
+		return errors.Wrap(err, "validating session config")
+	}
+
+	if cfg.Auth.OIDC.Issuer != "" {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		// Keys are fetched while handling requests, pass their IDs on
+		client := http.Client{
+			Timeout:   5 * time.Second,
+			Transport: &trace.Transport{},
+		}
+
+		apiCfg.OIDC, err = auth.DiscoverOIDC(ctx, cfg.Auth.OIDC, &client)
+		cancel()
+		if err != nil {
+			return errors.Wrap(err, "discovering OIDC provider")
+		}
+
+		log.Printf("main : Accepting tokens of OIDC provider %s", cfg.Auth.OIDC.Issuer)
+	}
+
+	// Client certificates can only be presented over TLS
+	useTLS := cfg.Server.TLSCertFile != ""
+	if cfg.Auth.ClientCert.Mode != auth.ClientCertOff && !useTLS {
+		return errors.New("client certificates need a TLS certificate for the server")
+	}
+
+	tlsCfg, err := cfg.Auth.ClientCert.TLSConfig()
+	if err != nil {
+		return errors.Wrap(err, "configuring client certificates")
+	}
+
 	api := http.Server{
 		Addr:         cfg.Server.Addr,
-		Handler:      handlers.API(log, db, authenticator),
+		Handler:      handlers.API(log, db, authenticator, apiCfg),
 		ReadTimeout:  cfg.Server.ReadTimeout,
 		WriteTimeout: cfg.Server.ReadTimeout,
+		TLSConfig:    tlsCfg,
 	}
 	const serverConfigFormat = "\n\nServer config:\nAddress: %v\nReadTimeout: %v\nWriteTimeout: %v\nGracefullShutdown: %v\n\n"
 	log.Printf(serverConfigFormat, cfg.Server.Addr, cfg.Server.ReadTimeout, cfg.Server.WriteTimeout, cfg.Server.GracefullShutdownTime)
@@ -108,6 +206,12 @@ func run() error {
 	serverErrors := make(chan error, 1)
 
 	go func() {
+		if useTLS {
+			log.Printf("main : API listening on %s with TLS, client certificates %s", api.Addr, cfg.Auth.ClientCert.Mode)
+			serverErrors <- api.ListenAndServeTLS(cfg.Server.TLSCertFile, cfg.Server.TLSKeyFile)
+			return
+		}
+
 		log.Printf("main : API listening on %s", api.Addr)
 		serverErrors <- api.ListenAndServe()
 	}()
@@ -138,18 +242,24 @@ func run() error {
 	return nil
 }
 
-func createAuth(privateKeyFile, keyID, algorithm string) (*auth.Authenticator, error) {
-	keyContent, err := ioutil.ReadFile(privateKeyFile)
-	if err != nil {
-		return nil, errors.Wrap(err, "reading auth private key")
-	}
+// legacyKeyFile is where the signing key was read from by default before
+// key directories
+const legacyKeyFile = "private.pem"
 
-	key, err := jwt.ParseRSAPrivateKeyFromPEM(keyContent)
-	if err != nil {
-		return nil, errors.Wrap(err, "parsing private key")
+// loadKeys loads the signing keys of dir, or the key of file when the single
+// key settings of older deployments are used. Deployments that still rely on
+// the old default key file fail with instructions instead of a missing dir.
+func loadKeys(log *log.Logger, dir, file, kid, algorithm string) (*auth.KeyStore, error) {
+	if file != "" {
+		log.Printf("main : GARAGESALE_AUTH_PRIVATEKEYFROMFILE is deprecated, move %s to %s to rotate keys", file, filepath.Join(dir, kid+auth.KeyFileExt))
+		return auth.NewFileKeyStore(file, kid, algorithm)
 	}
 
-	public := auth.NewSimpleKeyLookupFunc(keyID, key.Public().(*rsa.PublicKey))
+	if _, err := os.Stat(dir); os.IsNotExist(err) {
+		if _, err := os.Stat(legacyKeyFile); err == nil {
+			return nil, errors.Errorf("key directory %s not found but %s is: move it to %s or set GARAGESALE_AUTH_PRIVATEKEYFROMFILE", dir, legacyKeyFile, filepath.Join(dir, kid+auth.KeyFileExt))
+		}
+	}
 
-	return auth.NewAuthenticator(key, keyID, algorithm, public)
+	return auth.NewKeyStore(dir, algorithm)
 }
@@ -3,6 +3,7 @@ package tests
 import (
 	"encoding/json"
 	"garagesale/cmd/sales-api/internal/handlers"
+	"garagesale/internal/platform/auth"
 	"garagesale/internal/platform/database/databasetest"
 	"log"
 	"net/http"
@@ -20,7 +21,7 @@ func TestStatusCheck(t *testing.T) {
 	req := httptest.NewRequest(http.MethodGet, "/v1/health", nil)
 	resp := httptest.NewRecorder()
 
-	app := handlers.API(log, db, nil)
+	app := handlers.API(log, db, nil, handlers.Config{PasswordPolicy: auth.DefaultPasswordPolicy})
 	app.ServeHTTP(resp, req)
 
 	if resp.Code != http.StatusOK {
@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"garagesale/cmd/sales-api/internal/handlers"
+	"garagesale/internal/platform/auth"
 	"garagesale/internal/platform/database/databasetest"
 	"log"
 	"net/http"
@@ -24,7 +25,7 @@ func TestProducts(t *testing.T) {
 	log := log.New(os.Stdout, "TEST", log.Flags())
 
 	tests := ProductTest{
-		app: handlers.API(log, db, nil),
+		app: handlers.API(log, db, nil, handlers.Config{PasswordPolicy: auth.DefaultPasswordPolicy}),
 	}
 
 	t.Log("RUN PRODUCT TESTS")
@@ -0,0 +1,104 @@
+package tests
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"garagesale/cmd/sales-api/internal/handlers"
+	"garagesale/internal/platform/auth"
+	"garagesale/internal/platform/database/databasetest"
+	"garagesale/internal/platform/user"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestUpdateMeEmailTaken(t *testing.T) {
+	db, teardown := databasetest.Setup(t)
+	t.Cleanup(teardown)
+	ctx := context.Background()
+
+	log := log.New(os.Stdout, "TEST", log.Flags())
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("could not generate key: %v", err)
+	}
+
+	authenticator, err := auth.NewAuthenticator(key, "test", "RS256", auth.NewSimpleKeyLookupFunc("test", key.Public()))
+	if err != nil {
+		t.Fatalf("could not create authenticator: %v", err)
+	}
+
+	app := handlers.API(log, db, authenticator, handlers.Config{PasswordPolicy: auth.DefaultPasswordPolicy})
+
+	for _, email := range []string{"me@example.com", "taken@example.com"} {
+		nu := user.NewUser{
+			Name:            "test",
+			Email:           email,
+			Roles:           []string{auth.RoleUser},
+			Password:        "secret",
+			PasswordConfirm: "secret",
+		}
+
+		if _, err := user.Create(ctx, db, auth.DefaultPasswordPolicy, nu, time.Now()); err != nil {
+			t.Fatalf("could not create user %s: %v", email, err)
+		}
+	}
+
+	claims, err := user.Authenticate(ctx, db, auth.DefaultPasswordPolicy, time.Now(), "me@example.com", "secret", "")
+	if err != nil {
+		t.Fatalf("could not authenticate: %v", err)
+	}
+
+	tkn, err := authenticator.GenerateToken(claims)
+	if err != nil {
+		t.Fatalf("could not generate token: %v", err)
+	}
+
+	tests := []struct {
+		language string
+		want     map[string]string
+	}{
+		{"", map[string]string{"email": "email is already in use"}},
+		{"ru", map[string]string{"email": "email уже используется"}},
+	}
+
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodPatch, "/v1/users/me", strings.NewReader(`{"email": "taken@example.com"}`))
+		req.Header.Set("Authorization", "Bearer "+tkn)
+		if tt.language != "" {
+			req.Header.Set("Accept-Language", tt.language)
+		}
+
+		resp := httptest.NewRecorder()
+		app.ServeHTTP(resp, req)
+
+		if resp.Code != http.StatusBadRequest {
+			t.Fatalf("%q: expected status %d, got %d: %s", tt.language, http.StatusBadRequest, resp.Code, resp.Body)
+		}
+
+		var body struct {
+			Code   string            `json:"code"`
+			Fields map[string]string `json:"fields"`
+		}
+		if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+			t.Fatalf("decoding: %v", err)
+		}
+
+		if body.Code != "validation_failed" {
+			t.Errorf("%q: expected code validation_failed, got %q", tt.language, body.Code)
+		}
+
+		if diff := cmp.Diff(tt.want, body.Fields); diff != "" {
+			t.Errorf("%q: unexpected fields:\n%s", tt.language, diff)
+		}
+	}
+}
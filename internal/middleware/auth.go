@@ -2,11 +2,17 @@ package middleware
 
 import (
 	"context"
-	"errors"
+	"crypto/x509"
+	"garagesale/internal/platform/apikey"
 	"garagesale/internal/platform/auth"
+	"garagesale/internal/platform/revocation"
+	"garagesale/internal/platform/session"
+	"garagesale/internal/platform/user"
 	"garagesale/internal/platform/web"
 	"net/http"
 	"strings"
+
+	"github.com/pkg/errors"
 )
 
 // ErrForbidden is returned when an authenticated user
@@ -16,24 +22,143 @@ var ErrForbidden = web.NewRequestError(
 	http.StatusForbidden,
 )
 
-func Authenticate(authenticator *auth.Authenticator) web.Middleware {
+// ErrImpersonated is returned when an impersonated session attempts an
+// action only the user themselves may take
+var ErrImpersonated error = &web.Error{
+	Err:    errors.New("action is not allowed while impersonating a user"),
+	Status: http.StatusForbidden,
+	Code:   "impersonation_not_allowed",
+}
+
+// ClaimsCheck validates the claims of a verified token against server side
+// state, such as whether the user is still active. Known errors of rejected
+// credentials fail the request as unauthorized, any other error as failed.
+type ClaimsCheck func(ctx context.Context, claims auth.Claims) error
+
+// APIKeyFunc resolves an API key to the claims it grants
+type APIKeyFunc func(ctx context.Context, key string) (auth.Claims, error)
+
+// ExternalTokenFunc resolves a token of an external identity provider to the
+// claims of its user. ok is false when the token was not issued by the
+// provider and should be verified as one of our own.
+type ExternalTokenFunc func(ctx context.Context, token string) (claims auth.Claims, ok bool, err error)
+
+// SessionFunc resolves the session cookie of a request to the claims of its
+// session. ok is false when the request carries no session cookie.
+type SessionFunc func(ctx context.Context, r *http.Request) (claims auth.Claims, ok bool, err error)
+
+// ClientCertFunc resolves a verified TLS client certificate to the claims of
+// the user it is registered to
+type ClientCertFunc func(ctx context.Context, cert *x509.Certificate) (auth.Claims, error)
+
+// AuthOptions selects the credentials Authenticate accepts next to our own
+// bearer tokens. Credentials whose func is nil are not accepted.
+type AuthOptions struct {
+	// APIKeys resolves keys passed in the 'ApiKey <key>' format
+	APIKeys APIKeyFunc
+
+	// External resolves bearer tokens of an external identity provider
+	External ExternalTokenFunc
+
+	// Sessions resolves the session cookie of requests without an
+	// Authorization header
+	Sessions SessionFunc
+
+	// Certs resolves the verified TLS client certificate of requests without
+	// an Authorization header. It is tried before Sessions.
+	Certs ClientCertFunc
+
+	// Checks are run against the claims of bearer tokens. The claims of
+	// sessions and certificates are built from the current state of the user
+	// and are not checked again.
+	Checks []ClaimsCheck
+}
+
+// Authenticate validates the credentials from the Authorization header and
+// puts the resulting claims into the context. A JWT is expected in the
+// 'Bearer <token>' format and the checks of opts are run against its claims.
+// Other credentials are accepted as configured by opts.
+func Authenticate(authenticator *auth.Authenticator, opts AuthOptions) web.Middleware {
 	// This is actual mw function to be executed
 	f := func(after web.Handler) web.Handler {
 		// Wrap this handler around next provided
 		h := func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
-			parts := strings.Split(r.Header.Get("Authorization"), " ")
-			if len(parts) != 2 || strings.ToLower(parts[0]) != "bearer" {
-				err := errors.New("Expected Authorization header format: 'Bearer <token>'")
+			header := r.Header.Get("Authorization")
+
+			var claims auth.Claims
+			if cert, ok := auth.VerifiedClientCert(r.TLS); ok && header == "" && opts.Certs != nil {
+				claims, err := opts.Certs(ctx, cert)
+				if err != nil {
+					return authError(err)
+				}
+
+				return authenticated(ctx, w, r, claims, after)
+			}
+
+			if header == "" && opts.Sessions != nil {
+				var ok bool
+				var err error
+				claims, ok, err = opts.Sessions(ctx, r)
+				if err != nil {
+					return authError(err)
+				}
+
+				if ok {
+					return authenticated(ctx, w, r, claims, after)
+				}
+			}
+
+			parts := strings.Split(header, " ")
+			if len(parts) != 2 {
+				err := errors.New("Expected Authorization header format: 'Bearer <token>' or 'ApiKey <key>'")
 				return web.NewRequestError(err, http.StatusUnauthorized)
 			}
 
-			claims, err := authenticator.ParseClaims(parts[1])
-			if err != nil {
+			switch strings.ToLower(parts[0]) {
+			case "bearer":
+				var ok bool
+				var err error
+				if opts.External != nil {
+					claims, ok, err = opts.External(ctx, parts[1])
+					if err != nil {
+						return authError(err)
+					}
+				}
+
+				if !ok {
+					claims, err = authenticator.ParseClaims(parts[1])
+					if err != nil {
+						return authError(err)
+					}
+				}
+
+				if claims.Purpose != "" {
+					err := errors.New("token cannot be used to access this resource")
+					return web.NewRequestError(err, http.StatusUnauthorized)
+				}
+
+				if err := runChecks(ctx, claims, opts.Checks); err != nil {
+					return err
+				}
+
+			case "apikey":
+				if opts.APIKeys == nil {
+					err := errors.New("API keys are not accepted")
+					return web.NewRequestError(err, http.StatusUnauthorized)
+				}
+
+				var err error
+				claims, err = opts.APIKeys(ctx, parts[1])
+				if err != nil {
+					return authError(err)
+				}
+
+			default:
+				err := errors.New("Expected Authorization header format: 'Bearer <token>' or 'ApiKey <key>'")
 				return web.NewRequestError(err, http.StatusUnauthorized)
 			}
 
-			ctx = context.WithValue(ctx, auth.Key, claims)
-			return after(ctx, w, r)
+			return authenticated(ctx, w, r, claims, after)
 		}
 
 		return h
@@ -42,6 +167,52 @@ func Authenticate(authenticator *auth.Authenticator) web.Middleware {
 	return f
 }
 
+// runChecks returns the error of the first check the claims fail
+func runChecks(ctx context.Context, claims auth.Claims, checks []ClaimsCheck) error {
+	for _, check := range checks {
+		if err := check(ctx, claims); err != nil {
+			return authError(err)
+		}
+	}
+
+	return nil
+}
+
+// authError rejects the request as unauthorized when err says the credentials
+// are invalid, expired, revoked or belong to a disabled user, and as a
+// conflict when an external login collides with a local user. Other errors
+// mean the credentials could not be checked and are returned as they are.
+func authError(err error) error {
+	switch errors.Cause(err) {
+	case auth.ErrInvalidToken, auth.ErrTokenExpired, auth.ErrTokenNotYetValid,
+		auth.ErrInvalidIssuer, auth.ErrInvalidAudience,
+		user.ErrDisabled, user.ErrTokenRevoked, user.ErrNotFound,
+		user.ErrNoMembership, user.ErrCertificateNotRegistered,
+		apikey.ErrInvalidKey, revocation.ErrRevoked, session.ErrInvalidSession:
+		return web.NewRequestError(err, http.StatusUnauthorized)
+	case user.ErrEmailTaken:
+		// The first external login of an email that already has a local user.
+		// The accounts are not linked so the provider cannot take it over.
+		return web.NewRequestError(err, http.StatusConflict)
+	default:
+		return err
+	}
+}
+
+// authenticated puts the claims into the context and calls the next handler
+func authenticated(ctx context.Context, w http.ResponseWriter, r *http.Request, claims auth.Claims, after web.Handler) error {
+	// Record who is calling for the request log
+	if v, ok := ctx.Value(web.KeyValues).(*web.ContexValues); ok {
+		v.Subject = claims.Subject
+		if claims.Actor != nil {
+			v.Actor = claims.Actor.Subject
+		}
+	}
+
+	ctx = context.WithValue(ctx, auth.Key, claims)
+	return after(ctx, w, r)
+}
+
 // HasRoles validates that an authenticated has at least one
 // role from a specified list. This method constructs
 // the actual function taht is used
@@ -67,3 +238,53 @@ func HasRoles(roles ...string) web.Middleware {
 
 	return f
 }
+
+// RequirePermission validates that an authenticated user has been
+// granted the permission by one of their roles
+func RequirePermission(permission string) web.Middleware {
+	// This is actual mw function to be executed
+	f := func(after web.Handler) web.Handler {
+		// Wrap this handler around next provided
+		h := func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+			claims, ok := ctx.Value(auth.Key).(auth.Claims)
+			if !ok {
+				return errors.New("claims missing from context")
+			}
+
+			if !claims.HasPermission(permission) {
+				return ErrForbidden
+			}
+
+			return after(ctx, w, r)
+		}
+
+		return h
+	}
+
+	return f
+}
+
+// DenyImpersonation rejects impersonated sessions for actions only the user
+// themselves may take, such as changing credentials
+func DenyImpersonation() web.Middleware {
+	// This is actual mw function to be executed
+	f := func(after web.Handler) web.Handler {
+		// Wrap this handler around next provided
+		h := func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+			claims, ok := ctx.Value(auth.Key).(auth.Claims)
+			if !ok {
+				return errors.New("claims missing from context")
+			}
+
+			if claims.Impersonated() {
+				return ErrImpersonated
+			}
+
+			return after(ctx, w, r)
+		}
+
+		return h
+	}
+
+	return f
+}
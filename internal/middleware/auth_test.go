@@ -0,0 +1,142 @@
+package middleware_test
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"garagesale/internal/middleware"
+	"garagesale/internal/platform/apikey"
+	"garagesale/internal/platform/auth"
+	"garagesale/internal/platform/revocation"
+	"garagesale/internal/platform/session"
+	"garagesale/internal/platform/user"
+	"garagesale/internal/platform/web"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+func TestAuthenticateErrors(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("could not generate key: %v", err)
+	}
+
+	a, err := auth.NewAuthenticator(key, "test", "RS256", auth.NewSimpleKeyLookupFunc("test", key.Public()))
+	if err != nil {
+		t.Fatalf("could not create authenticator: %v", err)
+	}
+
+	tkn, err := a.GenerateToken(auth.NewClaims("user", nil, time.Now(), time.Hour))
+	if err != nil {
+		t.Fatalf("could not generate token: %v", err)
+	}
+
+	down := errors.New("database is down")
+
+	tests := []struct {
+		name   string
+		header string
+		err    error
+		status int
+	}{
+		{"valid", "Bearer " + tkn, nil, 0},
+		{"garbage", "Bearer not-a-token", nil, http.StatusUnauthorized},
+		{"disabled user", "Bearer " + tkn, user.ErrDisabled, http.StatusUnauthorized},
+		{"revoked token", "Bearer " + tkn, revocation.ErrRevoked, http.StatusUnauthorized},
+		{"check failed", "Bearer " + tkn, down, http.StatusInternalServerError},
+		{"invalid key", "ApiKey key", apikey.ErrInvalidKey, http.StatusUnauthorized},
+		{"key lookup failed", "ApiKey key", down, http.StatusInternalServerError},
+		{"invalid session", "", session.ErrInvalidSession, http.StatusUnauthorized},
+		{"session lookup failed", "", down, http.StatusInternalServerError},
+		{"external email taken", "Bearer external", user.ErrEmailTaken, http.StatusConflict},
+		{"external lookup failed", "Bearer external", down, http.StatusInternalServerError},
+	}
+
+	for _, tt := range tests {
+		check := func(ctx context.Context, claims auth.Claims) error {
+			return tt.err
+		}
+		apiKeys := func(ctx context.Context, key string) (auth.Claims, error) {
+			return auth.Claims{}, tt.err
+		}
+		sessions := func(ctx context.Context, r *http.Request) (auth.Claims, bool, error) {
+			return auth.Claims{}, true, tt.err
+		}
+		external := func(ctx context.Context, token string) (auth.Claims, bool, error) {
+			if token != "external" {
+				return auth.Claims{}, false, nil
+			}
+			return auth.Claims{}, true, tt.err
+		}
+		mw := middleware.Authenticate(a, middleware.AuthOptions{
+			APIKeys:  apiKeys,
+			External: external,
+			Sessions: sessions,
+			Checks:   []middleware.ClaimsCheck{check},
+		})
+		h := mw(func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+			return nil
+		})
+
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.Header.Set("Authorization", tt.header)
+
+		err := h(context.Background(), httptest.NewRecorder(), r)
+
+		status := 0
+		if err != nil {
+			status = http.StatusInternalServerError
+			if webErr, ok := errors.Cause(err).(*web.Error); ok {
+				status = webErr.Status
+			}
+		}
+
+		if status != tt.status {
+			t.Errorf("%s: expected status %d, got %d (%v)", tt.name, tt.status, status, err)
+		}
+	}
+
+	// Requests with a verified client certificate are authenticated by it
+	cert := x509.Certificate{Subject: pkix.Name{CommonName: "scanner-01"}}
+	state := tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{&cert}}}
+
+	certTests := []struct {
+		name   string
+		err    error
+		status int
+	}{
+		{"not registered", user.ErrCertificateNotRegistered, http.StatusUnauthorized},
+		{"lookup failed", down, http.StatusInternalServerError},
+	}
+
+	for _, tt := range certTests {
+		certs := func(ctx context.Context, cert *x509.Certificate) (auth.Claims, error) {
+			return auth.Claims{}, tt.err
+		}
+		mw := middleware.Authenticate(a, middleware.AuthOptions{Certs: certs})
+		h := mw(func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+			return nil
+		})
+
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.TLS = &state
+
+		err := h(context.Background(), httptest.NewRecorder(), r)
+
+		status := http.StatusInternalServerError
+		if webErr, ok := errors.Cause(err).(*web.Error); ok {
+			status = webErr.Status
+		}
+
+		if status != tt.status {
+			t.Errorf("certificate %s: expected status %d, got %d (%v)", tt.name, tt.status, status, err)
+		}
+	}
+}
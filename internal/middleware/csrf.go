@@ -0,0 +1,54 @@
+package middleware
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"garagesale/internal/platform/web"
+	"net/http"
+)
+
+// ErrCSRF is returned when a request authenticated by a session cookie does
+// not prove it was sent by our own pages
+var ErrCSRF error = &web.Error{
+	Err:    errors.New("missing or invalid CSRF token"),
+	Status: http.StatusForbidden,
+	Code:   "csrf_token_invalid",
+}
+
+// CSRF protects requests authenticated by the sessionCookie against cross-site
+// request forgery with the double-submit pattern: unsafe methods must repeat
+// the value of csrfCookie in the header. Other sites cannot read our cookies,
+// so they cannot set the header. Requests without the session cookie, or
+// with an Authorization header, carry no ambient credentials and pass.
+func CSRF(sessionCookie, csrfCookie, header string) web.Middleware {
+	// This is actual mw function to be executed
+	f := func(after web.Handler) web.Handler {
+		// Wrap this handler around next provided
+		h := func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+			switch r.Method {
+			case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace:
+				return after(ctx, w, r)
+			}
+
+			if _, err := r.Cookie(sessionCookie); err != nil || r.Header.Get("Authorization") != "" {
+				return after(ctx, w, r)
+			}
+
+			c, err := r.Cookie(csrfCookie)
+			if err != nil || c.Value == "" {
+				return ErrCSRF
+			}
+
+			if subtle.ConstantTimeCompare([]byte(c.Value), []byte(r.Header.Get(header))) != 1 {
+				return ErrCSRF
+			}
+
+			return after(ctx, w, r)
+		}
+
+		return h
+	}
+
+	return f
+}
@@ -0,0 +1,46 @@
+package middleware_test
+
+import (
+	"context"
+	"garagesale/internal/middleware"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestCSRF(t *testing.T) {
+	mw := middleware.CSRF("session", "csrf", "X-CSRF-Token")
+	h := mw(func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+		return nil
+	})
+
+	tests := []struct {
+		name    string
+		method  string
+		cookies map[string]string
+		header  map[string]string
+		want    error
+	}{
+		{"safe method", http.MethodGet, map[string]string{"session": "s"}, nil, nil},
+		{"no session", http.MethodPost, nil, nil, nil},
+		{"bearer token", http.MethodPost, map[string]string{"session": "s"}, map[string]string{"Authorization": "Bearer t"}, nil},
+		{"missing header", http.MethodPost, map[string]string{"session": "s", "csrf": "c"}, nil, middleware.ErrCSRF},
+		{"missing cookie", http.MethodDelete, map[string]string{"session": "s"}, map[string]string{"X-CSRF-Token": "c"}, middleware.ErrCSRF},
+		{"mismatch", http.MethodPut, map[string]string{"session": "s", "csrf": "c"}, map[string]string{"X-CSRF-Token": "other"}, middleware.ErrCSRF},
+		{"match", http.MethodPatch, map[string]string{"session": "s", "csrf": "c"}, map[string]string{"X-CSRF-Token": "c"}, nil},
+	}
+
+	for _, tt := range tests {
+		r := httptest.NewRequest(tt.method, "/", nil)
+		for name, value := range tt.cookies {
+			r.AddCookie(&http.Cookie{Name: name, Value: value})
+		}
+		for name, value := range tt.header {
+			r.Header.Set(name, value)
+		}
+
+		if err := h(context.Background(), httptest.NewRecorder(), r); err != tt.want {
+			t.Errorf("%s: expected %v, got %v", tt.name, tt.want, err)
+		}
+	}
+}
@@ -13,9 +13,14 @@ func Errors(log *log.Logger) web.Middleware {
 		// This is main handler
 		h := func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
 			if err := before(ctx, w, r); err != nil {
-				log.Printf("ERROR: %v", err)
+				v, ok := ctx.Value(web.KeyValues).(*web.ContexValues)
+				if !ok {
+					return web.ErrContextValueMissing
+				}
+
+				log.Printf("%s : ERROR: %v", v.TraceID, err)
 
-				if err := web.RespondError(ctx, w, err); err != nil {
+				if err := web.RespondError(ctx, w, r, err); err != nil {
 					return err
 				}
 			}
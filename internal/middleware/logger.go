@@ -20,10 +20,18 @@ func Logger(log *log.Logger) web.Middleware {
 				return web.ErrContextValueMissing
 			}
 
-			log.Printf(
-				"%d %s %s (%v)",
-				v.StatusCode, r.Method, r.URL.Path, time.Since(v.Start),
-			)
+			switch {
+			case v.Actor != "":
+				log.Printf(
+					"%s : %d %s %s (%v) user %s impersonated by %s",
+					v.TraceID, v.StatusCode, r.Method, r.URL.Path, time.Since(v.Start), v.Subject, v.Actor,
+				)
+			default:
+				log.Printf(
+					"%s : %d %s %s (%v)",
+					v.TraceID, v.StatusCode, r.Method, r.URL.Path, time.Since(v.Start),
+				)
+			}
 
 			// Return the error to be handled further up the chain
 			return err
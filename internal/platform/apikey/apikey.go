@@ -0,0 +1,266 @@
+package apikey
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"garagesale/internal/platform/auth"
+	"garagesale/internal/platform/organization"
+	"garagesale/internal/platform/role"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+	"github.com/pkg/errors"
+)
+
+// Predefined errors for known failure scenarios
+var (
+	ErrNotFound          = errors.New("api key not found")
+	ErrInvalidID         = errors.New("ID provided was not a valid ID")
+	ErrInvalidKey        = errors.New("api key is invalid, expired or revoked")
+	ErrForbidden         = errors.New("attempted action is not allowed")
+	ErrPermissionDenied  = errors.New("api key cannot have permissions the caller or its user do not have")
+	ErrExpiresInPast     = errors.New("expiry must be in the future")
+	ErrNotOrgMember      = errors.New("user is not a member of the organization")
+	ErrUnknownPermission = errors.New("unknown permission")
+)
+
+// keyPrefix marks the strings we hand out as keys of this service
+const keyPrefix = "gsk_"
+
+// lastUsedResolution is how stale LastUsed may get before it is written again.
+// It keeps busy keys from updating their row on every request.
+const lastUsedResolution = time.Minute
+
+// Create mints a Key for userID inside the caller's organization. The key
+// acts as userID, so it cannot grant permissions the caller or the roles of
+// userID in the organization do not grant. Neither keys nor impersonated
+// sessions can mint other keys.
+func Create(ctx context.Context, db *sqlx.DB, claims auth.Claims, userID string, nk NewKey, now time.Time) (*MintedKey, error) {
+	if claims.OrgID == "" || claims.APIKeyID != "" || claims.Impersonated() {
+		return nil, ErrForbidden
+	}
+
+	if _, err := uuid.Parse(userID); err != nil {
+		return nil, ErrInvalidID
+	}
+
+	m, err := organization.RetrieveMembership(ctx, db, claims.OrgID, userID)
+	if err != nil {
+		if err == organization.ErrMembershipNotFound {
+			return nil, ErrNotOrgMember
+		}
+
+		return nil, err
+	}
+
+	memberPerms, err := role.Permissions(ctx, db, m.OrgID, m.Roles)
+	if err != nil {
+		return nil, err
+	}
+	allowed := intersect(claims.Permissions, memberPerms)
+
+	perms := nk.Permissions
+	if len(perms) == 0 {
+		perms = allowed
+	}
+	for _, p := range perms {
+		if !auth.IsPermission(p) {
+			return nil, errors.Wrap(ErrUnknownPermission, p)
+		}
+		if !contains(allowed, p) {
+			return nil, errors.Wrap(ErrPermissionDenied, p)
+		}
+	}
+
+	if nk.ExpiresAt != nil && !nk.ExpiresAt.After(now) {
+		return nil, ErrExpiresInPast
+	}
+
+	secret, err := newSecret()
+	if err != nil {
+		return nil, err
+	}
+
+	k := Key{
+		ID:          uuid.New().String(),
+		UserID:      userID,
+		OrgID:       claims.OrgID,
+		Name:        nk.Name,
+		Prefix:      secret[:len(keyPrefix)+6],
+		KeyHash:     hashSecret(secret),
+		Permissions: perms,
+		DateCreated: now.UTC(),
+	}
+	if nk.ExpiresAt != nil {
+		exp := nk.ExpiresAt.UTC()
+		k.ExpiresAt = &exp
+	}
+
+	const q = `
+		INSERT INTO api_keys
+		(key_id, user_id, org_id, name, prefix, key_hash, permissions, expires_at, date_created)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+	`
+
+	_, err = db.ExecContext(ctx, q,
+		k.ID, k.UserID, k.OrgID, k.Name, k.Prefix, k.KeyHash, k.Permissions, k.ExpiresAt, k.DateCreated,
+	)
+	if err != nil {
+		return nil, errors.Wrap(err, "inserting api key")
+	}
+
+	return &MintedKey{Key: k, Secret: secret}, nil
+}
+
+// List returns the keys of userID inside the caller's organization
+func List(ctx context.Context, db *sqlx.DB, claims auth.Claims, userID string) ([]Key, error) {
+	if claims.OrgID == "" {
+		return nil, ErrForbidden
+	}
+
+	if _, err := uuid.Parse(userID); err != nil {
+		return nil, ErrInvalidID
+	}
+
+	list := []Key{}
+
+	const q = `SELECT * FROM api_keys WHERE user_id = $1 AND org_id = $2 ORDER BY date_created`
+	if err := db.SelectContext(ctx, &list, q, userID, claims.OrgID); err != nil {
+		return nil, errors.Wrap(err, "selecting api keys")
+	}
+
+	return list, nil
+}
+
+// Revoke disables a key of userID inside the caller's organization
+func Revoke(ctx context.Context, db *sqlx.DB, claims auth.Claims, userID, keyID string, now time.Time) error {
+	if claims.OrgID == "" {
+		return ErrForbidden
+	}
+
+	if _, err := uuid.Parse(keyID); err != nil {
+		return ErrInvalidID
+	}
+	if _, err := uuid.Parse(userID); err != nil {
+		return ErrInvalidID
+	}
+
+	const q = `
+		UPDATE api_keys SET
+		date_revoked = COALESCE(date_revoked, $4)
+		WHERE key_id = $1 AND user_id = $2 AND org_id = $3
+	`
+
+	res, err := db.ExecContext(ctx, q, keyID, userID, claims.OrgID, now.UTC())
+	if err != nil {
+		return errors.Wrap(err, "revoking api key")
+	}
+
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return ErrNotFound
+	}
+
+	return nil
+}
+
+// Authenticate finds the key matching secret and returns the claims it
+// grants. Keys that are expired, revoked, belong to a user that is not active
+// or is no longer a member of the organization are rejected. The permissions
+// of the key are limited to those the roles of its user currently grant, so
+// taking roles away from a user also takes them away from their keys.
+func Authenticate(ctx context.Context, db *sqlx.DB, now time.Time, secret string) (auth.Claims, error) {
+	const q = `
+		SELECT k.*, ARRAY(
+			SELECT DISTINCT UNNEST(r.permissions) FROM roles AS r
+			WHERE r.name = ANY(m.roles) AND (r.org_id IS NULL OR r.org_id = k.org_id)
+		) AS member_permissions
+		FROM api_keys AS k
+		JOIN users AS u ON u.user_id = k.user_id
+		JOIN memberships AS m ON m.user_id = k.user_id AND m.org_id = k.org_id
+		WHERE k.key_hash = $1
+		AND k.date_revoked IS NULL
+		AND (k.expires_at IS NULL OR k.expires_at > $2)
+		AND u.status = 'active'
+	`
+
+	var k struct {
+		Key
+		MemberPermissions pq.StringArray `db:"member_permissions"`
+	}
+	if err := db.GetContext(ctx, &k, q, hashSecret(secret), now.UTC()); err != nil {
+		if err == sql.ErrNoRows {
+			return auth.Claims{}, ErrInvalidKey
+		}
+
+		return auth.Claims{}, errors.Wrap(err, "selecting api key")
+	}
+
+	const qu = `
+		UPDATE api_keys SET
+		last_used = $2
+		WHERE key_id = $1 AND (last_used IS NULL OR last_used < $3)
+	`
+
+	if _, err := db.ExecContext(ctx, qu, k.ID, now.UTC(), now.Add(-lastUsedResolution).UTC()); err != nil {
+		return auth.Claims{}, errors.Wrap(err, "tracking api key usage")
+	}
+
+	claims := auth.Claims{
+		OrgID:       k.OrgID,
+		Permissions: intersect(k.Permissions, k.MemberPermissions),
+		APIKeyID:    k.ID,
+	}
+	claims.Subject = k.UserID
+	claims.IssuedAt = k.DateCreated.Unix()
+	if k.ExpiresAt != nil {
+		claims.ExpiresAt = k.ExpiresAt.Unix()
+	}
+
+	return claims, nil
+}
+
+// intersect returns the permissions of a that are also in b
+func intersect(a, b []string) []string {
+	perms := []string{}
+	for _, p := range a {
+		if contains(b, p) {
+			perms = append(perms, p)
+		}
+	}
+
+	return perms
+}
+
+// contains reports whether perms has p
+func contains(perms []string, p string) bool {
+	for _, has := range perms {
+		if has == p {
+			return true
+		}
+	}
+
+	return false
+}
+
+// newSecret returns a random key to hand out
+func newSecret() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", errors.Wrap(err, "generating api key")
+	}
+
+	return keyPrefix + base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// hashSecret returns hex encoded sha256 of a key. Keys are random enough
+// that a fast hash is sufficient.
+func hashSecret(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
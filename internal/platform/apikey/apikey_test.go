@@ -0,0 +1,128 @@
+package apikey_test
+
+import (
+	"context"
+	"garagesale/internal/platform/apikey"
+	"garagesale/internal/platform/auth"
+	"garagesale/internal/platform/database/databasetest"
+	"garagesale/internal/platform/organization"
+	"garagesale/internal/platform/user"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+func TestAPIKeys(t *testing.T) {
+	db, teardown := databasetest.Setup(t)
+	t.Cleanup(teardown)
+	ctx := context.Background()
+	now := time.Now()
+
+	nu := user.NewUser{
+		Name:            "reporting",
+		Email:           "reporting@example.com",
+		Roles:           []string{auth.RoleUser},
+		Password:        "secret",
+		PasswordConfirm: "secret",
+	}
+	u, err := user.Create(ctx, db, auth.DefaultPasswordPolicy, nu, now)
+	if err != nil {
+		t.Fatalf("could not create user: %v", err)
+	}
+
+	claims := auth.Claims{
+		OrgID:       organization.DefaultID,
+		Permissions: []string{auth.PermProductRead},
+	}
+	claims.Subject = u.ID
+
+	if _, err := apikey.Create(ctx, db, claims, u.ID, apikey.NewKey{
+		Name:        "too wide",
+		Permissions: []string{auth.PermProductDelete},
+	}, now); err == nil {
+		t.Fatal("expected minting a key with more permissions than the caller to fail")
+	}
+
+	minted, err := apikey.Create(ctx, db, claims, u.ID, apikey.NewKey{Name: "reports"}, now)
+	if err != nil {
+		t.Fatalf("could not mint key: %v", err)
+	}
+
+	got, err := apikey.Authenticate(ctx, db, now, minted.Secret)
+	if err != nil {
+		t.Fatalf("could not authenticate with key: %v", err)
+	}
+	if got.Subject != u.ID || !got.HasPermission(auth.PermProductRead) || got.APIKeyID != minted.ID {
+		t.Fatalf("unexpected claims for key: %+v", got)
+	}
+
+	if _, err := apikey.Create(ctx, db, got, u.ID, apikey.NewKey{Name: "nested"}, now); err != apikey.ErrForbidden {
+		t.Fatalf("expected %v when a key mints a key, got %v", apikey.ErrForbidden, err)
+	}
+
+	if err := apikey.Revoke(ctx, db, claims, u.ID, minted.ID, now); err != nil {
+		t.Fatalf("could not revoke key: %v", err)
+	}
+
+	if _, err := apikey.Authenticate(ctx, db, now, minted.Secret); err != apikey.ErrInvalidKey {
+		t.Fatalf("expected %v for revoked key, got %v", apikey.ErrInvalidKey, err)
+	}
+}
+
+func TestAPIKeyMemberPermissions(t *testing.T) {
+	db, teardown := databasetest.Setup(t)
+	t.Cleanup(teardown)
+	ctx := context.Background()
+	now := time.Now()
+
+	nu := user.NewUser{
+		Name:            "member",
+		Email:           "member@example.com",
+		Roles:           []string{auth.RoleUser},
+		Password:        "secret",
+		PasswordConfirm: "secret",
+	}
+	u, err := user.Create(ctx, db, auth.DefaultPasswordPolicy, nu, now)
+	if err != nil {
+		t.Fatalf("could not create user: %v", err)
+	}
+
+	admin := auth.Claims{
+		OrgID:       organization.DefaultID,
+		Permissions: auth.Permissions,
+	}
+	admin.Subject = "5cf37266-3473-4006-984f-9325122678b7"
+
+	if _, err := apikey.Create(ctx, db, admin, u.ID, apikey.NewKey{
+		Name:        "escalated",
+		Permissions: []string{auth.PermUserManage},
+	}, now); errors.Cause(err) != apikey.ErrPermissionDenied {
+		t.Fatalf("expected %v for a permission the user lacks, got %v", apikey.ErrPermissionDenied, err)
+	}
+
+	minted, err := apikey.Create(ctx, db, admin, u.ID, apikey.NewKey{Name: "member"}, now)
+	if err != nil {
+		t.Fatalf("could not mint key: %v", err)
+	}
+	got, err := apikey.Authenticate(ctx, db, now, minted.Secret)
+	if err != nil {
+		t.Fatalf("could not authenticate with key: %v", err)
+	}
+	if got.HasPermission(auth.PermUserManage) || !got.HasPermission(auth.PermProductRead) {
+		t.Fatalf("expected key limited to the permissions of the user, got %v", got.Permissions)
+	}
+
+	// Taking the roles away from the user also takes them from the key
+	if err := organization.AddMember(ctx, db, organization.DefaultID, u.ID, []string{}, now); err != nil {
+		t.Fatalf("could not change roles: %v", err)
+	}
+
+	got, err = apikey.Authenticate(ctx, db, now, minted.Secret)
+	if err != nil {
+		t.Fatalf("could not authenticate with key: %v", err)
+	}
+	if len(got.Permissions) != 0 {
+		t.Fatalf("expected no permissions after the roles were removed, got %v", got.Permissions)
+	}
+}
@@ -0,0 +1,40 @@
+package apikey
+
+import (
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// Key is a long-lived credential for service accounts and integrations. It
+// acts on behalf of its user inside one organization with a fixed set of
+// permissions. Only a hash of the key is stored.
+type Key struct {
+	ID          string         `db:"key_id" json:"id"`
+	UserID      string         `db:"user_id" json:"user_id"`
+	OrgID       string         `db:"org_id" json:"org_id"`
+	Name        string         `db:"name" json:"name"`
+	Prefix      string         `db:"prefix" json:"prefix"`
+	KeyHash     string         `db:"key_hash" json:"-"`
+	Permissions pq.StringArray `db:"permissions" json:"permissions"`
+	ExpiresAt   *time.Time     `db:"expires_at" json:"expires_at,omitempty"`
+	LastUsed    *time.Time     `db:"last_used" json:"last_used,omitempty"`
+	DateRevoked *time.Time     `db:"date_revoked" json:"date_revoked,omitempty"`
+	DateCreated time.Time      `db:"date_created" json:"date_created"`
+}
+
+// NewKey contains information needed to mint a Key. When Permissions is
+// empty the key gets every permission both the caller and the user of the
+// key have.
+type NewKey struct {
+	Name        string     `json:"name" validate:"required"`
+	Permissions []string   `json:"permissions"`
+	ExpiresAt   *time.Time `json:"expires_at"`
+}
+
+// MintedKey is returned once when a Key is created. The plain key cannot be
+// recovered later.
+type MintedKey struct {
+	Key
+	Secret string `json:"key"`
+}
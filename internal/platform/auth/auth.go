@@ -1,7 +1,8 @@
 package auth
 
 import (
-	"crypto/rsa"
+	"crypto"
+	"time"
 
 	"github.com/golang-jwt/jwt/v4"
 	"github.com/pkg/errors"
@@ -9,25 +10,26 @@ import (
 
 // KeyLookupFunc is used to map a JWT key id to the corresponding public key.
 // It is a requriement for creating an Authenticator
-type KeyLookupFunc func(kid string) (*rsa.PublicKey, error)
+type KeyLookupFunc func(kid string) (crypto.PublicKey, error)
 
 // Authenticator is used to authenticate the clients. It can generate a token
 // for a set of user claims and recreate the claims by passing the token
 type Authenticator struct {
-	privateKey           *rsa.PrivateKey
-	activeKID            string
+	signingKey           func() (string, crypto.Signer)
+	publicKeys           func() map[string]crypto.PublicKey
 	algorithm            string
 	publickKeyLookUpFunc KeyLookupFunc
 	parser               *jwt.Parser
+	policy               TokenPolicy
 }
 
 // NewSimpleKeyLookupFunc is a simple implementation of KeyFunc that only ever
-// supports one key. This is easy for development but in production should be
-// replaced with a caching layer that calls a JWKS endpoints
-func NewSimpleKeyLookupFunc(activeKID string, publicKey *rsa.PublicKey) KeyLookupFunc {
-	f := func(kid string) (*rsa.PublicKey, error) {
+// supports one key. This is easy for development; services verifying our
+// tokens from the outside should use NewJWKSKeyLookupFunc instead
+func NewSimpleKeyLookupFunc(activeKID string, publicKey crypto.PublicKey) KeyLookupFunc {
+	f := func(kid string) (crypto.PublicKey, error) {
 		if activeKID != kid {
-			return nil, errors.Errorf("unrecognized key id %q", kid)
+			return nil, errors.Wrapf(ErrInvalidToken, "unrecognized key id %q", kid)
 		}
 
 		return publicKey, nil
@@ -36,17 +38,18 @@ func NewSimpleKeyLookupFunc(activeKID string, publicKey *rsa.PublicKey) KeyLooku
 	return f
 }
 
-// NewAuthenticator creates an *Authenticator for use. It will error if:
+// NewAuthenticator creates an *Authenticator for use. Tokens follow the
+// DefaultTokenPolicy. It will error if:
 //
 // - the privateKey is nil
 //
 // - activeKID is blank
 //
-// - the algorithm is unsupported
+// - the algorithm is unsupported or does not match the type of privateKey
 //
 // - publickLookUpFunc is not defined
 func NewAuthenticator(
-	privateKey *rsa.PrivateKey,
+	privateKey crypto.Signer,
 	acitiveKID, algorithm string,
 	publickKeyLookUpFunc KeyLookupFunc,
 ) (*Authenticator, error) {
@@ -63,32 +66,78 @@ func NewAuthenticator(
 		return nil, errors.Errorf("unknown algorithm %v", algorithm)
 	}
 
+	if err := CheckKey(algorithm, privateKey.Public()); err != nil {
+		return nil, err
+	}
+
 	if publickKeyLookUpFunc == nil {
 		return nil, errors.New("publickKeyLookUpFunc key cannot be nil")
 	}
 
 	parser := jwt.Parser{
-		ValidMethods: []string{algorithm},
+		ValidMethods:         []string{algorithm},
+		SkipClaimsValidation: true,
 	}
 
 	a := Authenticator{
-		privateKey:           privateKey,
-		activeKID:            acitiveKID,
+		signingKey: func() (string, crypto.Signer) {
+			return acitiveKID, privateKey
+		},
+		publicKeys: func() map[string]crypto.PublicKey {
+			return map[string]crypto.PublicKey{acitiveKID: privateKey.Public()}
+		},
 		algorithm:            algorithm,
 		publickKeyLookUpFunc: publickKeyLookUpFunc,
 		parser:               &parser,
+		policy:               DefaultTokenPolicy,
 	}
 
 	return &a, nil
 }
 
-// GenerateToken generates a signed JWT token string representing the user Claims
+// NewKeyStoreAuthenticator creates an *Authenticator that signs with the
+// active key of the store and verifies tokens signed by any of its keys.
+// Reloading the store changes the keys without a restart. The keys are
+// checked against the algorithm of the store whenever they are loaded.
+// Tokens are issued and checked according to policy.
+func NewKeyStoreAuthenticator(keys *KeyStore, policy TokenPolicy) (*Authenticator, error) {
+	if keys == nil {
+		return nil, errors.New("key store cannot be nil")
+	}
+
+	if err := policy.Validate(); err != nil {
+		return nil, err
+	}
+
+	parser := jwt.Parser{
+		ValidMethods:         []string{keys.Algorithm()},
+		SkipClaimsValidation: true,
+	}
+
+	a := Authenticator{
+		signingKey:           keys.Active,
+		publicKeys:           keys.PublicKeys,
+		algorithm:            keys.Algorithm(),
+		publickKeyLookUpFunc: keys.PublicKey,
+		parser:               &parser,
+		policy:               policy,
+	}
+
+	return &a, nil
+}
+
+// GenerateToken generates a signed JWT token string representing the user Claims.
+// The issuer and audience of the policy are set, and claims without an expiry
+// expire after the TTL of the policy.
 func (a *Authenticator) GenerateToken(claims Claims) (string, error) {
+	kid, key := a.signingKey()
+	a.policy.apply(&claims)
+
 	method := jwt.GetSigningMethod(a.algorithm)
 	tkn := jwt.NewWithClaims(method, claims)
-	tkn.Header["kid"] = a.activeKID
+	tkn.Header["kid"] = kid
 
-	str, err := tkn.SignedString(a.privateKey)
+	str, err := tkn.SignedString(key)
 	if err != nil {
 		return "", errors.Wrapf(err, "signing token")
 	}
@@ -96,18 +145,27 @@ func (a *Authenticator) GenerateToken(claims Claims) (string, error) {
 	return str, nil
 }
 
+// JWKS returns the public keys tokens of this Authenticator can be verified
+// with: the active key and every key that is not retired yet
+func (a *Authenticator) JWKS() JWKS {
+	return NewJWKS(a.algorithm, a.publicKeys())
+}
+
 // ParseClaims recreates the Claims that we used to generate a token
-// It verifies that token was signed using our key
+// It verifies that token was signed using our key and algorithm and that
+// its registered claims match the policy, allowing for its clock skew.
+// Mismatching claims are reported with ErrTokenExpired, ErrTokenNotYetValid,
+// ErrInvalidIssuer or ErrInvalidAudience.
 func (a *Authenticator) ParseClaims(tokenStr string) (Claims, error) {
 	keyFunc := func(t *jwt.Token) (interface{}, error) {
 		kid, ok := t.Header["kid"]
 		if !ok {
-			return nil, errors.New("missing Kid in Token header")
+			return nil, errors.Wrap(ErrInvalidToken, "missing Kid in Token header")
 		}
 
 		userKID, ok := kid.(string)
 		if !ok {
-			return nil, errors.New("Kid must be string")
+			return nil, errors.Wrap(ErrInvalidToken, "Kid must be string")
 		}
 
 		return a.publickKeyLookUpFunc(userKID)
@@ -115,13 +173,17 @@ func (a *Authenticator) ParseClaims(tokenStr string) (Claims, error) {
 
 	var claims Claims
 
-	token, err := jwt.ParseWithClaims(tokenStr, &claims, keyFunc)
+	token, err := a.parser.ParseWithClaims(tokenStr, &claims, keyFunc)
 	if err != nil {
-		return Claims{}, errors.Wrap(err, "parsing token")
+		return Claims{}, tokenError(err)
 	}
 
 	if !token.Valid {
-		return Claims{}, errors.Wrap(err, "invalid token")
+		return Claims{}, ErrInvalidToken
+	}
+
+	if err := a.policy.check(claims, time.Now()); err != nil {
+		return Claims{}, err
 	}
 
 	return claims, nil
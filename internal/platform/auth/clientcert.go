@@ -0,0 +1,94 @@
+package auth
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"io/ioutil"
+
+	"github.com/pkg/errors"
+)
+
+// Modes of ClientCertConfig
+const (
+	ClientCertOff      = "off"
+	ClientCertOptional = "optional"
+	ClientCertRequired = "required"
+)
+
+// ClientCertConfig describes how clients of a TLS server are authenticated
+// with certificates. Certificates are verified against the CA bundle. In
+// optional mode clients without a certificate can still connect and use the
+// other kinds of credentials, in required mode they are rejected during the
+// handshake.
+type ClientCertConfig struct {
+	// CAFile is a PEM bundle of the CAs client certificates are issued by
+	CAFile string `split_words:"true"`
+
+	// Mode is one of off, optional or required
+	Mode string `default:"off"`
+}
+
+// Validate returns an error when the config cannot be used
+func (c ClientCertConfig) Validate() error {
+	switch c.Mode {
+	case ClientCertOff:
+		return nil
+	case ClientCertOptional, ClientCertRequired:
+		if c.CAFile == "" {
+			return errors.New("client certificates need a CA file")
+		}
+		return nil
+	default:
+		return errors.Errorf("unknown client certificate mode %q", c.Mode)
+	}
+}
+
+// TLSConfig returns the server side TLS settings for the config. The server
+// certificate still has to be added by the caller.
+func (c ClientCertConfig) TLSConfig() (*tls.Config, error) {
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+
+	cfg := tls.Config{
+		MinVersion: tls.VersionTLS12,
+	}
+
+	if c.Mode == ClientCertOff {
+		return &cfg, nil
+	}
+
+	pem, err := ioutil.ReadFile(c.CAFile)
+	if err != nil {
+		return nil, errors.Wrap(err, "reading CA file")
+	}
+
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, errors.Errorf("no certificates found in %s", c.CAFile)
+	}
+
+	cfg.ClientCAs = pool
+	cfg.ClientAuth = tls.VerifyClientCertIfGiven
+	if c.Mode == ClientCertRequired {
+		cfg.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+
+	return &cfg, nil
+}
+
+// VerifiedClientCert returns the client certificate of a connection if it
+// was verified against the CA bundle
+func VerifiedClientCert(state *tls.ConnectionState) (*x509.Certificate, bool) {
+	if state == nil || len(state.VerifiedChains) == 0 || len(state.VerifiedChains[0]) == 0 {
+		return nil, false
+	}
+
+	return state.VerifiedChains[0][0], true
+}
+
+// CertificateSubject returns the distinguished name certificates are mapped
+// to users by, such as "CN=scanner-01,OU=Warehouse,O=Garagesale"
+func CertificateSubject(cert *x509.Certificate) string {
+	return cert.Subject.String()
+}
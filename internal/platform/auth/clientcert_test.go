@@ -0,0 +1,183 @@
+package auth_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"garagesale/internal/platform/auth"
+	"io/ioutil"
+	"log"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// testCA is a certificate authority generated for a test
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+}
+
+func newCA(t *testing.T, name string) *testCA {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("could not generate CA key: %v", err)
+	}
+
+	tmpl := x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, &tmpl, &tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("could not create CA certificate: %v", err)
+	}
+
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("could not parse CA certificate: %v", err)
+	}
+
+	return &testCA{cert: cert, key: key}
+}
+
+// issue creates a client certificate for subject signed by the CA
+func (ca *testCA) issue(t *testing.T, subject pkix.Name) tls.Certificate {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("could not generate client key: %v", err)
+	}
+
+	tmpl := x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      subject,
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, &tmpl, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatalf("could not create client certificate: %v", err)
+	}
+
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+// writePEM stores the CA certificate as a bundle and returns its path
+func (ca *testCA) writePEM(t *testing.T) string {
+	t.Helper()
+
+	file := filepath.Join(t.TempDir(), "ca.pem")
+	block := pem.Block{Type: "CERTIFICATE", Bytes: ca.cert.Raw}
+	if err := ioutil.WriteFile(file, pem.EncodeToMemory(&block), 0600); err != nil {
+		t.Fatalf("could not write CA bundle: %v", err)
+	}
+
+	return file
+}
+
+func TestClientCertificates(t *testing.T) {
+	ca := newCA(t, "Garagesale Test CA")
+	rogue := newCA(t, "Rogue CA")
+
+	scanner := pkix.Name{CommonName: "scanner-01", OrganizationalUnit: []string{"Warehouse"}}
+	trusted := ca.issue(t, scanner)
+	untrusted := rogue.issue(t, scanner)
+
+	const want = "CN=scanner-01,OU=Warehouse"
+
+	// The handler echoes the subject of the verified certificate
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if cert, ok := auth.VerifiedClientCert(r.TLS); ok {
+			w.Write([]byte(auth.CertificateSubject(cert)))
+		}
+	})
+
+	tests := []struct {
+		mode    string
+		cert    *tls.Certificate
+		wantErr bool
+		subject string
+	}{
+		{auth.ClientCertOptional, nil, false, ""},
+		{auth.ClientCertOptional, &trusted, false, want},
+		{auth.ClientCertOptional, &untrusted, true, ""},
+		{auth.ClientCertRequired, nil, true, ""},
+		{auth.ClientCertRequired, &trusted, false, want},
+		{auth.ClientCertRequired, &untrusted, true, ""},
+	}
+
+	for i, tt := range tests {
+		cfg, err := auth.ClientCertConfig{CAFile: ca.writePEM(t), Mode: tt.mode}.TLSConfig()
+		if err != nil {
+			t.Fatalf("%d: could not build TLS config: %v", i, err)
+		}
+
+		srv := httptest.NewUnstartedServer(h)
+		srv.TLS = cfg
+		srv.Config.ErrorLog = log.New(ioutil.Discard, "", 0)
+		srv.StartTLS()
+
+		client := srv.Client()
+		if cert := tt.cert; cert != nil {
+			// Send the certificate even when the server does not name its CA
+			client.Transport.(*http.Transport).TLSClientConfig.GetClientCertificate = func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+				return cert, nil
+			}
+		}
+
+		resp, err := client.Get(srv.URL)
+		if tt.wantErr {
+			if err == nil {
+				resp.Body.Close()
+				t.Errorf("%d: expected %s mode to reject the connection", i, tt.mode)
+			}
+			srv.Close()
+			continue
+		}
+		if err != nil {
+			t.Fatalf("%d: request failed: %v", i, err)
+		}
+
+		body, _ := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		srv.Close()
+
+		if string(body) != tt.subject {
+			t.Errorf("%d: expected subject %q, got %q", i, tt.subject, body)
+		}
+	}
+}
+
+func TestClientCertConfigValidate(t *testing.T) {
+	if err := (auth.ClientCertConfig{Mode: auth.ClientCertOff}).Validate(); err != nil {
+		t.Errorf("expected off mode to be valid, got %v", err)
+	}
+
+	if err := (auth.ClientCertConfig{Mode: auth.ClientCertRequired}).Validate(); err == nil {
+		t.Error("expected required mode without a CA file to be invalid")
+	}
+
+	if err := (auth.ClientCertConfig{Mode: "sometimes", CAFile: "ca.pem"}).Validate(); err == nil {
+		t.Error("expected unknown mode to be invalid")
+	}
+}
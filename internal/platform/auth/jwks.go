@@ -0,0 +1,292 @@
+package auth
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"golang.org/x/sync/singleflight"
+)
+
+// JWK is a single public key in the JSON Web Key format (RFC 7517)
+type JWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use,omitempty"`
+	Alg string `json:"alg,omitempty"`
+
+	// N and E are set for RSA keys
+	N string `json:"n,omitempty"`
+	E string `json:"e,omitempty"`
+
+	// Crv and X are set for EC and OKP keys, Y for EC keys only
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+	Y   string `json:"y,omitempty"`
+}
+
+// JWKS is a set of public keys as served from /.well-known/jwks.json
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+// NewJWKS builds a key set for the public keys, ordered by key id. Keys of
+// unsupported types are left out.
+func NewJWKS(algorithm string, keys map[string]crypto.PublicKey) JWKS {
+	set := JWKS{Keys: []JWK{}}
+	for kid, key := range keys {
+		jwk := JWK{
+			Kid: kid,
+			Use: "sig",
+			Alg: algorithm,
+		}
+
+		switch k := key.(type) {
+		case *rsa.PublicKey:
+			jwk.Kty = "RSA"
+			jwk.N = base64.RawURLEncoding.EncodeToString(k.N.Bytes())
+			jwk.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(k.E)).Bytes())
+		case *ecdsa.PublicKey:
+			size := (k.Curve.Params().BitSize + 7) / 8
+			jwk.Kty = "EC"
+			jwk.Crv = k.Curve.Params().Name
+			jwk.X = base64.RawURLEncoding.EncodeToString(k.X.FillBytes(make([]byte, size)))
+			jwk.Y = base64.RawURLEncoding.EncodeToString(k.Y.FillBytes(make([]byte, size)))
+		case ed25519.PublicKey:
+			jwk.Kty = "OKP"
+			jwk.Crv = "Ed25519"
+			jwk.X = base64.RawURLEncoding.EncodeToString(k)
+		default:
+			continue
+		}
+
+		set.Keys = append(set.Keys, jwk)
+	}
+
+	sort.Slice(set.Keys, func(i, j int) bool { return set.Keys[i].Kid < set.Keys[j].Kid })
+	return set
+}
+
+// PublicKey decodes the public key of the JWK. RSA, EC and Ed25519 keys
+// are supported.
+func (k JWK) PublicKey() (crypto.PublicKey, error) {
+	switch k.Kty {
+	case "RSA":
+		return k.rsaPublicKey()
+	case "EC":
+		return k.ecdsaPublicKey()
+	case "OKP":
+		if k.Crv != "Ed25519" {
+			return nil, errors.Errorf("unsupported curve %q", k.Crv)
+		}
+
+		x, err := base64.RawURLEncoding.DecodeString(k.X)
+		if err != nil {
+			return nil, errors.Wrap(err, "decoding x")
+		}
+
+		if len(x) != ed25519.PublicKeySize {
+			return nil, errors.New("invalid Ed25519 key size")
+		}
+
+		return ed25519.PublicKey(x), nil
+	default:
+		return nil, errors.Errorf("unsupported key type %q", k.Kty)
+	}
+}
+
+// ecdsaPublicKey decodes the EC public key of the JWK
+func (k JWK) ecdsaPublicKey() (*ecdsa.PublicKey, error) {
+	curves := map[string]elliptic.Curve{
+		"P-256": elliptic.P256(),
+		"P-384": elliptic.P384(),
+		"P-521": elliptic.P521(),
+	}
+
+	curve, ok := curves[k.Crv]
+	if !ok {
+		return nil, errors.Errorf("unsupported curve %q", k.Crv)
+	}
+
+	x, err := base64.RawURLEncoding.DecodeString(k.X)
+	if err != nil {
+		return nil, errors.Wrap(err, "decoding x")
+	}
+
+	y, err := base64.RawURLEncoding.DecodeString(k.Y)
+	if err != nil {
+		return nil, errors.Wrap(err, "decoding y")
+	}
+
+	key := ecdsa.PublicKey{
+		Curve: curve,
+		X:     new(big.Int).SetBytes(x),
+		Y:     new(big.Int).SetBytes(y),
+	}
+
+	if !curve.IsOnCurve(key.X, key.Y) {
+		return nil, errors.New("point is not on the curve")
+	}
+
+	return &key, nil
+}
+
+// rsaPublicKey decodes the RSA public key of the JWK
+func (k JWK) rsaPublicKey() (*rsa.PublicKey, error) {
+	n, err := base64.RawURLEncoding.DecodeString(k.N)
+	if err != nil {
+		return nil, errors.Wrap(err, "decoding modulus")
+	}
+
+	e, err := base64.RawURLEncoding.DecodeString(k.E)
+	if err != nil {
+		return nil, errors.Wrap(err, "decoding exponent")
+	}
+
+	key := rsa.PublicKey{
+		N: new(big.Int).SetBytes(n),
+		E: int(new(big.Int).SetBytes(e).Int64()),
+	}
+
+	return &key, nil
+}
+
+// jwksCache holds the keys fetched from a remote JWKS document. The document
+// is fetched without holding mu, so known keys keep being served from the
+// cache while it is refreshed.
+type jwksCache struct {
+	url        string
+	client     *http.Client
+	minRefresh time.Duration
+
+	// fetches makes concurrent lookups of unknown keys share one fetch
+	fetches singleflight.Group
+
+	mu        sync.RWMutex
+	keys      map[string]crypto.PublicKey
+	lastFetch time.Time
+}
+
+// NewJWKSKeyLookupFunc returns a KeyLookupFunc backed by the JWKS document at
+// url. Keys are fetched on first use and cached. An unknown key id triggers a
+// refresh of the document, at most once per minRefresh so clients presenting
+// bogus key ids cannot make us hammer the key server.
+func NewJWKSKeyLookupFunc(url string, client *http.Client, minRefresh time.Duration) KeyLookupFunc {
+	c := newJWKSCache(url, client, minRefresh)
+
+	f := func(kid string) (crypto.PublicKey, error) {
+		return c.lookup(context.Background(), kid)
+	}
+
+	return f
+}
+
+// newJWKSCache returns an empty cache of the keys served at url
+func newJWKSCache(url string, client *http.Client, minRefresh time.Duration) *jwksCache {
+	if client == nil {
+		client = &http.Client{Timeout: 5 * time.Second}
+	}
+
+	c := jwksCache{
+		url:        url,
+		client:     client,
+		minRefresh: minRefresh,
+		keys:       map[string]crypto.PublicKey{},
+	}
+
+	return &c
+}
+
+// lookup returns the cached key for kid, refreshing the document when kid is unknown
+func (c *jwksCache) lookup(ctx context.Context, kid string) (crypto.PublicKey, error) {
+	if key, ok := c.key(kid); ok {
+		return key, nil
+	}
+
+	c.mu.Lock()
+	due := c.lastFetch.IsZero() || time.Since(c.lastFetch) >= c.minRefresh
+	c.mu.Unlock()
+
+	if due {
+		_, err, _ := c.fetches.Do(c.url, func() (interface{}, error) {
+			return nil, c.refresh(ctx)
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if key, ok := c.key(kid); ok {
+		return key, nil
+	}
+
+	return nil, errors.Wrapf(ErrInvalidToken, "unrecognized key id %q", kid)
+}
+
+// key returns the cached key for kid
+func (c *jwksCache) key(kid string) (crypto.PublicKey, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	key, ok := c.keys[kid]
+	return key, ok
+}
+
+// refresh replaces the cached keys with the ones currently served at url
+// and keeps the ones it has when the document cannot be fetched.
+func (c *jwksCache) refresh(ctx context.Context) error {
+	c.mu.Lock()
+	c.lastFetch = time.Now()
+	c.mu.Unlock()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.url, nil)
+	if err != nil {
+		return errors.Wrap(err, "creating jwks request")
+	}
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return errors.Wrap(err, "fetching jwks")
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return errors.Errorf("fetching jwks: unexpected status %d", resp.StatusCode)
+	}
+
+	var set JWKS
+	if err := json.NewDecoder(resp.Body).Decode(&set); err != nil {
+		return errors.Wrap(err, "decoding jwks")
+	}
+
+	keys := make(map[string]crypto.PublicKey, len(set.Keys))
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+
+		key, err := k.PublicKey()
+		if err != nil {
+			continue
+		}
+
+		keys[k.Kid] = key
+	}
+
+	c.mu.Lock()
+	c.keys = keys
+	c.mu.Unlock()
+
+	return nil
+}
@@ -0,0 +1,183 @@
+package auth_test
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"garagesale/internal/platform/auth"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestJWKSKeyLookupFunc(t *testing.T) {
+	first, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("could not generate key: %v", err)
+	}
+	second, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("could not generate key: %v", err)
+	}
+
+	published := map[string]crypto.PublicKey{"first": &first.PublicKey}
+
+	var fetches int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&fetches, 1)
+		json.NewEncoder(w).Encode(auth.NewJWKS("RS256", published))
+	}))
+	t.Cleanup(srv.Close)
+
+	lookup := auth.NewJWKSKeyLookupFunc(srv.URL, srv.Client(), 0)
+
+	key, err := lookup("first")
+	if err != nil {
+		t.Fatalf("could not look up key: %v", err)
+	}
+	if !first.PublicKey.Equal(key) {
+		t.Fatal("looked up key does not match the published key")
+	}
+
+	if _, err := lookup("first"); err != nil {
+		t.Fatalf("could not look up cached key: %v", err)
+	}
+	if n := atomic.LoadInt32(&fetches); n != 1 {
+		t.Fatalf("expected one fetch for a cached key, got %d", n)
+	}
+
+	// Publishing a new key makes the lookup refresh on the unknown kid
+	published["second"] = &second.PublicKey
+
+	key, err = lookup("second")
+	if err != nil {
+		t.Fatalf("could not look up rotated key: %v", err)
+	}
+	if !second.PublicKey.Equal(key) {
+		t.Fatal("looked up key does not match the rotated key")
+	}
+
+	if _, err := lookup("unknown"); err == nil {
+		t.Fatal("expected error for unknown key id")
+	}
+}
+
+func TestJWKSKeyLookupFuncRateLimit(t *testing.T) {
+	var fetches int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&fetches, 1)
+		json.NewEncoder(w).Encode(auth.JWKS{})
+	}))
+	t.Cleanup(srv.Close)
+
+	lookup := auth.NewJWKSKeyLookupFunc(srv.URL, srv.Client(), time.Hour)
+
+	for i := 0; i < 3; i++ {
+		if _, err := lookup("unknown"); err == nil {
+			t.Fatal("expected error for unknown key id")
+		}
+	}
+
+	if n := atomic.LoadInt32(&fetches); n != 1 {
+		t.Fatalf("expected unknown key ids to refresh at most once per interval, got %d fetches", n)
+	}
+}
+
+func TestJWKSKeyLookupFuncSlowRefresh(t *testing.T) {
+	first, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("could not generate key: %v", err)
+	}
+	second, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("could not generate key: %v", err)
+	}
+
+	// Every fetch after the first hangs until released
+	var fetches int32
+	blocked := make(chan struct{})
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		published := map[string]crypto.PublicKey{"first": &first.PublicKey}
+		if atomic.AddInt32(&fetches, 1) > 1 {
+			close(blocked)
+			<-release
+			published["second"] = &second.PublicKey
+		}
+		json.NewEncoder(w).Encode(auth.NewJWKS("RS256", published))
+	}))
+	t.Cleanup(srv.Close)
+
+	lookup := auth.NewJWKSKeyLookupFunc(srv.URL, srv.Client(), 0)
+	if _, err := lookup("first"); err != nil {
+		t.Fatalf("could not look up key: %v", err)
+	}
+
+	const lookups = 5
+	errs := make(chan error, lookups)
+	for i := 0; i < lookups; i++ {
+		go func() {
+			_, err := lookup("second")
+			errs <- err
+		}()
+	}
+	<-blocked
+
+	// Known keys are served while the document is being fetched
+	done := make(chan error, 1)
+	go func() {
+		_, err := lookup("first")
+		done <- err
+	}()
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("could not look up cached key during refresh: %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("looking up a cached key waited for the refresh")
+	}
+
+	// Give the lookups time to join the fetch in flight
+	time.Sleep(100 * time.Millisecond)
+	close(release)
+
+	for i := 0; i < lookups; i++ {
+		if err := <-errs; err != nil {
+			t.Fatalf("could not look up rotated key: %v", err)
+		}
+	}
+
+	if n := atomic.LoadInt32(&fetches); n != 2 {
+		t.Fatalf("expected concurrent lookups to share one fetch, got %d fetches", n)
+	}
+}
+
+func TestAuthenticatorJWKS(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("could not generate key: %v", err)
+	}
+
+	a, err := auth.NewAuthenticator(key, "kid-1", "RS256", auth.NewSimpleKeyLookupFunc("kid-1", &key.PublicKey))
+	if err != nil {
+		t.Fatalf("could not create authenticator: %v", err)
+	}
+
+	set := a.JWKS()
+	if len(set.Keys) != 1 || set.Keys[0].Kid != "kid-1" || set.Keys[0].Alg != "RS256" {
+		t.Fatalf("unexpected key set: %+v", set)
+	}
+
+	pub, err := set.Keys[0].PublicKey()
+	if err != nil {
+		t.Fatalf("could not decode published key: %v", err)
+	}
+	if !key.PublicKey.Equal(pub) {
+		t.Fatal("published key does not match the signing key")
+	}
+}
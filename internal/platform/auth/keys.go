@@ -0,0 +1,121 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// ParsePrivateKeyPEM decodes a PEM encoded RSA, ECDSA or Ed25519 private key.
+// PKCS#1, SEC 1 and PKCS#8 encodings are accepted.
+func ParsePrivateKeyPEM(content []byte) (crypto.Signer, error) {
+	block, _ := pem.Decode(content)
+	if block == nil {
+		return nil, errors.New("key must be PEM encoded")
+	}
+
+	var key interface{}
+	var err error
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		key, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		key, err = x509.ParseECPrivateKey(block.Bytes)
+	case "PRIVATE KEY":
+		key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	default:
+		return nil, errors.Errorf("unsupported PEM block %q", block.Type)
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "parsing private key")
+	}
+
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, errors.Errorf("unsupported private key type %T", key)
+	}
+
+	return signer, nil
+}
+
+// EncodePrivateKeyPEM encodes a private key in the format ParsePrivateKeyPEM
+// reads: PKCS#1 for RSA, SEC 1 for ECDSA and PKCS#8 for Ed25519 keys.
+func EncodePrivateKeyPEM(key crypto.Signer) ([]byte, error) {
+	var block pem.Block
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		block = pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(k)}
+	case *ecdsa.PrivateKey:
+		der, err := x509.MarshalECPrivateKey(k)
+		if err != nil {
+			return nil, errors.Wrap(err, "marshalling ecdsa key")
+		}
+		block = pem.Block{Type: "EC PRIVATE KEY", Bytes: der}
+	case ed25519.PrivateKey:
+		der, err := x509.MarshalPKCS8PrivateKey(k)
+		if err != nil {
+			return nil, errors.Wrap(err, "marshalling ed25519 key")
+		}
+		block = pem.Block{Type: "PRIVATE KEY", Bytes: der}
+	default:
+		return nil, errors.Errorf("unsupported private key type %T", key)
+	}
+
+	return pem.EncodeToMemory(&block), nil
+}
+
+// GenerateKey creates a new private key suitable for algorithm
+func GenerateKey(algorithm string) (crypto.Signer, error) {
+	switch {
+	case strings.HasPrefix(algorithm, "RS"), strings.HasPrefix(algorithm, "PS"):
+		return rsa.GenerateKey(rand.Reader, 2048)
+	case algorithm == "ES256":
+		return ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	case algorithm == "ES384":
+		return ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	case algorithm == "ES512":
+		return ecdsa.GenerateKey(elliptic.P521(), rand.Reader)
+	case algorithm == "EdDSA":
+		_, key, err := ed25519.GenerateKey(rand.Reader)
+		return key, err
+	default:
+		return nil, errors.Errorf("unknown algorithm %v", algorithm)
+	}
+}
+
+// CheckKey returns an error if the public key cannot verify tokens signed
+// with algorithm, such as an RSA key for ES256 or a P-384 key for ES256
+func CheckKey(algorithm string, key crypto.PublicKey) error {
+	switch k := key.(type) {
+	case *rsa.PublicKey:
+		if strings.HasPrefix(algorithm, "RS") || strings.HasPrefix(algorithm, "PS") {
+			return nil
+		}
+		return errors.Errorf("RSA key cannot be used with algorithm %v", algorithm)
+	case *ecdsa.PublicKey:
+		curves := map[string]elliptic.Curve{
+			"ES256": elliptic.P256(),
+			"ES384": elliptic.P384(),
+			"ES512": elliptic.P521(),
+		}
+		if c, ok := curves[algorithm]; ok && c == k.Curve {
+			return nil
+		}
+		return errors.Errorf("ECDSA %s key cannot be used with algorithm %v", k.Curve.Params().Name, algorithm)
+	case ed25519.PublicKey:
+		if algorithm == "EdDSA" {
+			return nil
+		}
+		return errors.Errorf("Ed25519 key cannot be used with algorithm %v", algorithm)
+	}
+
+	return errors.Errorf("unsupported key type %T", key)
+}
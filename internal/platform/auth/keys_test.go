@@ -0,0 +1,103 @@
+package auth_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"garagesale/internal/platform/auth"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestAlgorithms(t *testing.T) {
+	for _, alg := range []string{"RS256", "ES256", "ES384", "EdDSA"} {
+		t.Run(alg, func(t *testing.T) {
+			key, err := auth.GenerateKey(alg)
+			if err != nil {
+				t.Fatalf("could not generate key: %v", err)
+			}
+
+			content, err := auth.EncodePrivateKeyPEM(key)
+			if err != nil {
+				t.Fatalf("could not encode key: %v", err)
+			}
+
+			dir := t.TempDir()
+			if err := ioutil.WriteFile(filepath.Join(dir, "kid"+auth.KeyFileExt), content, 0600); err != nil {
+				t.Fatalf("could not write key: %v", err)
+			}
+
+			keys, err := auth.NewKeyStore(dir, alg)
+			if err != nil {
+				t.Fatalf("could not load keys: %v", err)
+			}
+
+			a, err := auth.NewKeyStoreAuthenticator(keys, auth.DefaultTokenPolicy)
+			if err != nil {
+				t.Fatalf("could not create authenticator: %v", err)
+			}
+
+			tkn, err := a.GenerateToken(auth.NewClaims("user", nil, time.Now(), time.Hour))
+			if err != nil {
+				t.Fatalf("could not generate token: %v", err)
+			}
+
+			claims, err := a.ParseClaims(tkn)
+			if err != nil {
+				t.Fatalf("could not parse token: %v", err)
+			}
+			if claims.Subject != "user" {
+				t.Fatalf("expected subject %q, got %q", "user", claims.Subject)
+			}
+
+			set := a.JWKS()
+			if len(set.Keys) != 1 || set.Keys[0].Alg != alg {
+				t.Fatalf("unexpected key set: %+v", set)
+			}
+
+			pub, err := set.Keys[0].PublicKey()
+			if err != nil {
+				t.Fatalf("could not decode published key: %v", err)
+			}
+			if err := auth.CheckKey(alg, pub); err != nil {
+				t.Fatalf("published key does not match algorithm: %v", err)
+			}
+		})
+	}
+}
+
+func TestKeyAlgorithmMismatch(t *testing.T) {
+	ec, err := auth.GenerateKey("ES256")
+	if err != nil {
+		t.Fatalf("could not generate key: %v", err)
+	}
+
+	p384, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	if err != nil {
+		t.Fatalf("could not generate key: %v", err)
+	}
+
+	if _, err := auth.NewAuthenticator(ec, "kid", "RS256", auth.NewSimpleKeyLookupFunc("kid", ec.Public())); err == nil {
+		t.Fatal("expected ECDSA key to be rejected for RS256")
+	}
+
+	if _, err := auth.NewAuthenticator(p384, "kid", "ES256", auth.NewSimpleKeyLookupFunc("kid", p384.Public())); err == nil {
+		t.Fatal("expected P-384 key to be rejected for ES256")
+	}
+
+	content, err := auth.EncodePrivateKeyPEM(ec)
+	if err != nil {
+		t.Fatalf("could not encode key: %v", err)
+	}
+
+	dir := t.TempDir()
+	if err := ioutil.WriteFile(filepath.Join(dir, "kid"+auth.KeyFileExt), content, 0600); err != nil {
+		t.Fatalf("could not write key: %v", err)
+	}
+
+	if _, err := auth.NewKeyStore(dir, "EdDSA"); err == nil {
+		t.Fatal("expected key store to reject an ECDSA key for EdDSA")
+	}
+}
@@ -0,0 +1,223 @@
+package auth
+
+import (
+	"crypto"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/pkg/errors"
+)
+
+// These are the file names a KeyStore directory is made of
+const (
+	// ActiveKeyFile holds the kid of the key new tokens are signed with
+	ActiveKeyFile = "active"
+
+	// KeyFileExt is the extension of private key files. The file name
+	// without it is the kid of the key
+	KeyFileExt = ".pem"
+
+	// RetiredKeyFileExt is appended to key files that are no longer trusted
+	RetiredKeyFileExt = ".retired"
+)
+
+// KeyStore holds the private keys found in a directory. One key is active
+// and signs new tokens, every other key is still trusted for verification
+// until its file is retired. Load can be called again to pick up changes.
+type KeyStore struct {
+	dir       string
+	algorithm string
+
+	// file and fileKID are set for a store of a single key file
+	file    string
+	fileKID string
+
+	mu        sync.RWMutex
+	keys      map[string]crypto.Signer
+	activeKID string
+}
+
+// NewKeyStore loads the keys of dir for signing with algorithm. It will error
+// if the algorithm is unknown, the directory has no keys, a key does not
+// match the algorithm or it is unclear which key is active.
+func NewKeyStore(dir, algorithm string) (*KeyStore, error) {
+	if jwt.GetSigningMethod(algorithm) == nil {
+		return nil, errors.Errorf("unknown algorithm %v", algorithm)
+	}
+
+	ks := KeyStore{dir: dir, algorithm: algorithm}
+	if err := ks.Load(); err != nil {
+		return nil, err
+	}
+
+	return &ks, nil
+}
+
+// NewFileKeyStore loads the single key of file for signing with algorithm
+// under kid. It serves deployments that were configured with one key file
+// before key directories; tokens signed with the key keep verifying as long
+// as kid stays the same. The key cannot be rotated, Load reads it again.
+func NewFileKeyStore(file, kid, algorithm string) (*KeyStore, error) {
+	if jwt.GetSigningMethod(algorithm) == nil {
+		return nil, errors.Errorf("unknown algorithm %v", algorithm)
+	}
+
+	if kid == "" {
+		return nil, errors.New("key id cannot be blank")
+	}
+
+	ks := KeyStore{algorithm: algorithm, file: file, fileKID: kid}
+	if err := ks.Load(); err != nil {
+		return nil, err
+	}
+
+	return &ks, nil
+}
+
+// Load reads the keys of the directory again. On error the keys loaded
+// before stay in use.
+func (ks *KeyStore) Load() error {
+	if ks.file != "" {
+		return ks.loadFile()
+	}
+
+	files, err := ioutil.ReadDir(ks.dir)
+	if err != nil {
+		return errors.Wrap(err, "reading key directory")
+	}
+
+	keys := map[string]crypto.Signer{}
+	for _, f := range files {
+		if f.IsDir() || filepath.Ext(f.Name()) != KeyFileExt {
+			continue
+		}
+
+		content, err := ioutil.ReadFile(filepath.Join(ks.dir, f.Name()))
+		if err != nil {
+			return errors.Wrapf(err, "reading key %s", f.Name())
+		}
+
+		key, err := ParsePrivateKeyPEM(content)
+		if err != nil {
+			return errors.Wrapf(err, "parsing key %s", f.Name())
+		}
+
+		if err := CheckKey(ks.algorithm, key.Public()); err != nil {
+			return errors.Wrapf(err, "checking key %s", f.Name())
+		}
+
+		keys[strings.TrimSuffix(f.Name(), KeyFileExt)] = key
+	}
+
+	if len(keys) == 0 {
+		return errors.Errorf("no keys found in %s", ks.dir)
+	}
+
+	active, err := ReadActiveKID(ks.dir)
+	if err != nil {
+		return err
+	}
+
+	if active == "" {
+		if len(keys) != 1 {
+			return errors.Errorf("%d keys found in %s but none is marked active", len(keys), ks.dir)
+		}
+
+		for kid := range keys {
+			active = kid
+		}
+	}
+
+	if _, ok := keys[active]; !ok {
+		return errors.Errorf("active key %q not found in %s", active, ks.dir)
+	}
+
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+
+	ks.keys = keys
+	ks.activeKID = active
+	return nil
+}
+
+// loadFile reads the key of a single key file store again
+func (ks *KeyStore) loadFile() error {
+	content, err := ioutil.ReadFile(ks.file)
+	if err != nil {
+		return errors.Wrap(err, "reading key file")
+	}
+
+	key, err := ParsePrivateKeyPEM(content)
+	if err != nil {
+		return errors.Wrapf(err, "parsing key %s", ks.file)
+	}
+
+	if err := CheckKey(ks.algorithm, key.Public()); err != nil {
+		return errors.Wrapf(err, "checking key %s", ks.file)
+	}
+
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+
+	ks.keys = map[string]crypto.Signer{ks.fileKID: key}
+	ks.activeKID = ks.fileKID
+	return nil
+}
+
+// Algorithm returns the algorithm the keys of the store sign with
+func (ks *KeyStore) Algorithm() string {
+	return ks.algorithm
+}
+
+// Active returns the key new tokens are signed with
+func (ks *KeyStore) Active() (string, crypto.Signer) {
+	ks.mu.RLock()
+	defer ks.mu.RUnlock()
+
+	return ks.activeKID, ks.keys[ks.activeKID]
+}
+
+// PublicKey is a KeyLookupFunc for every key of the store
+func (ks *KeyStore) PublicKey(kid string) (crypto.PublicKey, error) {
+	ks.mu.RLock()
+	defer ks.mu.RUnlock()
+
+	key, ok := ks.keys[kid]
+	if !ok {
+		return nil, errors.Wrapf(ErrInvalidToken, "unrecognized key id %q", kid)
+	}
+
+	return key.Public(), nil
+}
+
+// PublicKeys returns the public keys of the store by kid
+func (ks *KeyStore) PublicKeys() map[string]crypto.PublicKey {
+	ks.mu.RLock()
+	defer ks.mu.RUnlock()
+
+	keys := make(map[string]crypto.PublicKey, len(ks.keys))
+	for kid, key := range ks.keys {
+		keys[kid] = key.Public()
+	}
+
+	return keys
+}
+
+// ReadActiveKID returns the kid marked active in dir, or an empty string
+// when no key is marked
+func ReadActiveKID(dir string) (string, error) {
+	content, err := ioutil.ReadFile(filepath.Join(dir, ActiveKeyFile))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return "", nil
+		}
+
+		return "", errors.Wrap(err, "reading active key id")
+	}
+
+	return strings.TrimSpace(string(content)), nil
+}
@@ -0,0 +1,132 @@
+package auth_test
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"garagesale/internal/platform/auth"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+func writeKey(t *testing.T, dir, kid string) *rsa.PrivateKey {
+	t.Helper()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("could not generate key: %v", err)
+	}
+
+	block := pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)}
+	if err := ioutil.WriteFile(filepath.Join(dir, kid+auth.KeyFileExt), pem.EncodeToMemory(&block), 0600); err != nil {
+		t.Fatalf("could not write key: %v", err)
+	}
+
+	return key
+}
+
+func TestKeyStoreRotation(t *testing.T) {
+	dir := t.TempDir()
+	writeKey(t, dir, "old")
+
+	keys, err := auth.NewKeyStore(dir, "RS256")
+	if err != nil {
+		t.Fatalf("could not load keys: %v", err)
+	}
+
+	a, err := auth.NewKeyStoreAuthenticator(keys, auth.DefaultTokenPolicy)
+	if err != nil {
+		t.Fatalf("could not create authenticator: %v", err)
+	}
+
+	now := time.Now()
+	old, err := a.GenerateToken(auth.NewClaims("user", nil, now, time.Hour))
+	if err != nil {
+		t.Fatalf("could not generate token: %v", err)
+	}
+
+	// A second key without an active marker is ambiguous and keeps the old state
+	writeKey(t, dir, "new")
+	if err := keys.Load(); err == nil {
+		t.Fatal("expected error when no key is marked active")
+	}
+	if kid, _ := keys.Active(); kid != "old" {
+		t.Fatalf("expected old key to stay active, got %q", kid)
+	}
+
+	if err := ioutil.WriteFile(filepath.Join(dir, auth.ActiveKeyFile), []byte("new\n"), 0600); err != nil {
+		t.Fatalf("could not write active key: %v", err)
+	}
+	if err := keys.Load(); err != nil {
+		t.Fatalf("could not reload keys: %v", err)
+	}
+
+	fresh, err := a.GenerateToken(auth.NewClaims("user", nil, now, time.Hour))
+	if err != nil {
+		t.Fatalf("could not generate token: %v", err)
+	}
+
+	for _, tkn := range []string{old, fresh} {
+		if _, err := a.ParseClaims(tkn); err != nil {
+			t.Fatalf("expected token to verify after rotation: %v", err)
+		}
+	}
+
+	if n := len(a.JWKS().Keys); n != 2 {
+		t.Fatalf("expected 2 published keys, got %d", n)
+	}
+
+	path := filepath.Join(dir, "old"+auth.KeyFileExt)
+	if err := os.Rename(path, path+auth.RetiredKeyFileExt); err != nil {
+		t.Fatalf("could not retire key: %v", err)
+	}
+	if err := keys.Load(); err != nil {
+		t.Fatalf("could not reload keys: %v", err)
+	}
+
+	if _, err := a.ParseClaims(old); errors.Cause(err) != auth.ErrInvalidToken {
+		t.Fatalf("expected token of retired key to be rejected with %v, got %v", auth.ErrInvalidToken, err)
+	}
+	if _, err := a.ParseClaims(fresh); err != nil {
+		t.Fatalf("expected token of active key to verify: %v", err)
+	}
+}
+
+func TestFileKeyStore(t *testing.T) {
+	dir := t.TempDir()
+	key := writeKey(t, dir, "private")
+	file := filepath.Join(dir, "private"+auth.KeyFileExt)
+
+	// Tokens signed before key directories carry the configured key id
+	keys, err := auth.NewFileKeyStore(file, "1", "RS256")
+	if err != nil {
+		t.Fatalf("could not load key file: %v", err)
+	}
+
+	kid, signer := keys.Active()
+	if kid != "1" || !key.PublicKey.Equal(signer.Public()) {
+		t.Fatalf("expected the key of the file to be active as %q, got %q", "1", kid)
+	}
+
+	if _, err := keys.PublicKey("1"); err != nil {
+		t.Fatalf("could not look up key: %v", err)
+	}
+
+	if _, err := auth.NewFileKeyStore(filepath.Join(dir, "missing.pem"), "1", "RS256"); err == nil {
+		t.Fatal("expected error for a missing key file")
+	}
+
+	if _, err := auth.NewFileKeyStore(file, "", "RS256"); err == nil {
+		t.Fatal("expected error for a blank key id")
+	}
+
+	if _, err := auth.NewFileKeyStore(file, "1", "ES256"); err == nil {
+		t.Fatal("expected error for a key that does not match the algorithm")
+	}
+}
@@ -0,0 +1,231 @@
+package auth
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/pkg/errors"
+)
+
+// OIDCConfig describes an external OpenID Connect provider whose tokens are
+// trusted next to our own. The provider is disabled when Issuer is blank.
+type OIDCConfig struct {
+	// Issuer is the issuer URL of the provider. The discovery document is
+	// served below it and must name the same issuer
+	Issuer string
+
+	// Audience must be in the aud claim of accepted tokens, usually the
+	// client id we are registered with at the provider
+	Audience string
+
+	// GroupsClaim names the claim holding the groups of the user
+	GroupsClaim string `default:"groups" split_words:"true"`
+
+	// GroupRoles maps groups of the provider to our roles, configured as
+	// group:ROLE pairs separated by commas
+	GroupRoles map[string]string `split_words:"true"`
+
+	// OrgID is the organization users of the provider are provisioned into.
+	// The default organization is used when it is blank
+	OrgID string `envconfig:"ORG_ID"`
+}
+
+// ExternalIdentity is a user as vouched for by an external provider
+type ExternalIdentity struct {
+	Issuer        string
+	Subject       string
+	Email         string
+	EmailVerified bool
+	Name          string
+	Groups        []string
+
+	// Roles are the roles the groups of the user map to
+	Roles []string
+
+	// TokenHash identifies the token of the provider the identity was
+	// verified from, ExpiresAt is taken from it
+	TokenHash string
+	ExpiresAt int64
+}
+
+// oidcDiscovery is the subset of the discovery document we use
+type oidcDiscovery struct {
+	Issuer  string `json:"issuer"`
+	JWKSURI string `json:"jwks_uri"`
+}
+
+// OIDCProvider verifies tokens issued by an external OpenID Connect provider
+type OIDCProvider struct {
+	cfg    OIDCConfig
+	keys   *jwksCache
+	parser *jwt.Parser
+}
+
+// DiscoverOIDC reads the discovery document of the provider and returns an
+// OIDCProvider that verifies tokens with the keys published by it. It will
+// error if the document cannot be read or names another issuer.
+func DiscoverOIDC(ctx context.Context, cfg OIDCConfig, client *http.Client) (*OIDCProvider, error) {
+	if cfg.Issuer == "" {
+		return nil, errors.New("issuer cannot be blank")
+	}
+
+	if cfg.Audience == "" {
+		return nil, errors.New("audience cannot be blank")
+	}
+
+	if client == nil {
+		client = &http.Client{Timeout: 5 * time.Second}
+	}
+
+	url := strings.TrimSuffix(cfg.Issuer, "/") + "/.well-known/openid-configuration"
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "creating discovery request")
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, errors.Wrap(err, "fetching discovery document")
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.Errorf("fetching discovery document: unexpected status %d", resp.StatusCode)
+	}
+
+	var doc oidcDiscovery
+	if err := json.NewDecoder(resp.Body).Decode(&doc); err != nil {
+		return nil, errors.Wrap(err, "decoding discovery document")
+	}
+
+	if doc.Issuer != cfg.Issuer {
+		return nil, errors.Errorf("discovery document names issuer %q, expected %q", doc.Issuer, cfg.Issuer)
+	}
+
+	if doc.JWKSURI == "" {
+		return nil, errors.New("discovery document has no jwks_uri")
+	}
+
+	if cfg.GroupsClaim == "" {
+		cfg.GroupsClaim = "groups"
+	}
+
+	p := OIDCProvider{
+		cfg:  cfg,
+		keys: newJWKSCache(doc.JWKSURI, client, time.Minute),
+		parser: &jwt.Parser{
+			ValidMethods: []string{"RS256", "RS384", "RS512", "ES256", "ES384", "ES512", "EdDSA"},
+		},
+	}
+
+	return &p, nil
+}
+
+// Issued reports whether the token claims to be issued by the provider. The
+// token is not verified; use Verify for that.
+func (p *OIDCProvider) Issued(tokenStr string) bool {
+	var claims jwt.MapClaims
+	if _, _, err := p.parser.ParseUnverified(tokenStr, &claims); err != nil {
+		return false
+	}
+
+	iss, _ := claims["iss"].(string)
+	return iss == p.cfg.Issuer
+}
+
+// OrgID returns the organization users of the provider are provisioned into
+func (p *OIDCProvider) OrgID() string {
+	return p.cfg.OrgID
+}
+
+// Verify checks the signature, expiry, issuer and audience of a token of the
+// provider and returns the identity it vouches for. Keys the provider
+// rotated in are fetched with ctx.
+func (p *OIDCProvider) Verify(ctx context.Context, tokenStr string) (ExternalIdentity, error) {
+	keyFunc := func(t *jwt.Token) (interface{}, error) {
+		kid, ok := t.Header["kid"].(string)
+		if !ok {
+			return nil, errors.New("missing Kid in Token header")
+		}
+
+		return p.keys.lookup(ctx, kid)
+	}
+
+	var claims jwt.MapClaims
+	if _, err := p.parser.ParseWithClaims(tokenStr, &claims, keyFunc); err != nil {
+		return ExternalIdentity{}, tokenError(err)
+	}
+
+	if !claims.VerifyIssuer(p.cfg.Issuer, true) {
+		return ExternalIdentity{}, ErrInvalidIssuer
+	}
+
+	if !claims.VerifyAudience(p.cfg.Audience, true) {
+		return ExternalIdentity{}, ErrInvalidAudience
+	}
+
+	if _, ok := claims["exp"]; !ok {
+		return ExternalIdentity{}, errors.Wrap(ErrInvalidToken, "token has no expiry")
+	}
+
+	id := ExternalIdentity{Issuer: p.cfg.Issuer}
+	id.Subject, _ = claims["sub"].(string)
+	id.Email, _ = claims["email"].(string)
+	id.EmailVerified, _ = claims["email_verified"].(bool)
+	id.Name, _ = claims["name"].(string)
+
+	sum := sha256.Sum256([]byte(tokenStr))
+	id.TokenHash = hex.EncodeToString(sum[:])
+
+	if exp, ok := claims["exp"].(float64); ok {
+		id.ExpiresAt = int64(exp)
+	}
+
+	if id.Subject == "" {
+		return ExternalIdentity{}, errors.Wrap(ErrInvalidToken, "token has no subject")
+	}
+
+	if id.Email == "" {
+		return ExternalIdentity{}, errors.Wrap(ErrInvalidToken, "token has no email")
+	}
+
+	switch groups := claims[p.cfg.GroupsClaim].(type) {
+	case []interface{}:
+		for _, g := range groups {
+			if s, ok := g.(string); ok {
+				id.Groups = append(id.Groups, s)
+			}
+		}
+	case string:
+		id.Groups = []string{groups}
+	}
+
+	id.Roles = p.Roles(id.Groups)
+	return id, nil
+}
+
+// Roles maps groups of the provider to our roles. Groups without a mapping
+// are ignored.
+func (p *OIDCProvider) Roles(groups []string) []string {
+	set := map[string]bool{}
+	for _, g := range groups {
+		if r, ok := p.cfg.GroupRoles[g]; ok {
+			set[r] = true
+		}
+	}
+
+	roles := []string{}
+	for r := range set {
+		roles = append(roles, r)
+	}
+
+	sort.Strings(roles)
+	return roles
+}
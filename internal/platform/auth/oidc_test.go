@@ -0,0 +1,185 @@
+package auth_test
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"garagesale/internal/platform/auth"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/pkg/errors"
+)
+
+// idp is a stand-in OpenID Connect provider
+type idp struct {
+	srv *httptest.Server
+	key *rsa.PrivateKey
+}
+
+func newIDP(t *testing.T) *idp {
+	t.Helper()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("could not generate key: %v", err)
+	}
+
+	p := idp{key: key}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":   p.srv.URL,
+			"jwks_uri": p.srv.URL + "/keys",
+		})
+	})
+	mux.HandleFunc("/keys", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(auth.NewJWKS("RS256", map[string]crypto.PublicKey{"idp": &key.PublicKey}))
+	})
+
+	p.srv = httptest.NewServer(mux)
+	t.Cleanup(p.srv.Close)
+
+	return &p
+}
+
+// token signs claims the way the provider would
+func (p *idp) token(t *testing.T, claims jwt.MapClaims) string {
+	t.Helper()
+
+	tkn := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	tkn.Header["kid"] = "idp"
+
+	str, err := tkn.SignedString(p.key)
+	if err != nil {
+		t.Fatalf("could not sign token: %v", err)
+	}
+
+	return str
+}
+
+func TestOIDCProvider(t *testing.T) {
+	p := newIDP(t)
+
+	cfg := auth.OIDCConfig{
+		Issuer:     p.srv.URL,
+		Audience:   "garagesale",
+		GroupRoles: map[string]string{"sales-admins": auth.RoleAdmin, "staff": auth.RoleUser},
+	}
+
+	provider, err := auth.DiscoverOIDC(context.Background(), cfg, p.srv.Client())
+	if err != nil {
+		t.Fatalf("could not discover provider: %v", err)
+	}
+
+	claims := func() jwt.MapClaims {
+		return jwt.MapClaims{
+			"iss":    p.srv.URL,
+			"aud":    []string{"garagesale", "other"},
+			"sub":    "idp-user",
+			"email":  "sso@example.com",
+			"name":   "SSO User",
+			"groups": []string{"staff", "sales-admins", "unmapped"},
+			"exp":    time.Now().Add(time.Hour).Unix(),
+		}
+	}
+
+	tkn := p.token(t, claims())
+	if !provider.Issued(tkn) {
+		t.Fatal("expected token to be recognized as issued by the provider")
+	}
+
+	id, err := provider.Verify(context.Background(), tkn)
+	if err != nil {
+		t.Fatalf("could not verify token: %v", err)
+	}
+
+	if id.Subject != "idp-user" || id.Email != "sso@example.com" || id.Issuer != p.srv.URL {
+		t.Fatalf("unexpected identity: %+v", id)
+	}
+
+	if len(id.Roles) != 2 || id.Roles[0] != auth.RoleAdmin || id.Roles[1] != auth.RoleUser {
+		t.Fatalf("expected roles %v, got %v", []string{auth.RoleAdmin, auth.RoleUser}, id.Roles)
+	}
+
+	tests := []struct {
+		name   string
+		modify func(jwt.MapClaims)
+		err    error
+	}{
+		{"wrong audience", func(c jwt.MapClaims) { c["aud"] = "someone-else" }, auth.ErrInvalidAudience},
+		{"wrong issuer", func(c jwt.MapClaims) { c["iss"] = "https://evil.example.com" }, auth.ErrInvalidIssuer},
+		{"expired", func(c jwt.MapClaims) { c["exp"] = time.Now().Add(-time.Minute).Unix() }, auth.ErrTokenExpired},
+		{"no expiry", func(c jwt.MapClaims) { delete(c, "exp") }, auth.ErrInvalidToken},
+		{"no subject", func(c jwt.MapClaims) { delete(c, "sub") }, auth.ErrInvalidToken},
+	}
+
+	for _, tt := range tests {
+		c := claims()
+		tt.modify(c)
+
+		if _, err := provider.Verify(context.Background(), p.token(t, c)); errors.Cause(err) != tt.err {
+			t.Errorf("%s: expected %v, got %v", tt.name, tt.err, err)
+		}
+	}
+
+	// Tokens signed by anyone else must not verify
+	other, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("could not generate key: %v", err)
+	}
+
+	forged := jwt.NewWithClaims(jwt.SigningMethodRS256, claims())
+	forged.Header["kid"] = "idp"
+	str, err := forged.SignedString(other)
+	if err != nil {
+		t.Fatalf("could not sign token: %v", err)
+	}
+
+	if _, err := provider.Verify(context.Background(), str); errors.Cause(err) != auth.ErrInvalidToken {
+		t.Fatalf("expected forged token to be rejected with %v, got %v", auth.ErrInvalidToken, err)
+	}
+}
+
+func TestOIDCProviderDown(t *testing.T) {
+	p := newIDP(t)
+
+	cfg := auth.OIDCConfig{Issuer: p.srv.URL, Audience: "garagesale"}
+	provider, err := auth.DiscoverOIDC(context.Background(), cfg, p.srv.Client())
+	if err != nil {
+		t.Fatalf("could not discover provider: %v", err)
+	}
+
+	tkn := p.token(t, jwt.MapClaims{
+		"iss":   p.srv.URL,
+		"aud":   "garagesale",
+		"sub":   "idp-user",
+		"email": "sso@example.com",
+		"exp":   time.Now().Add(time.Hour).Unix(),
+	})
+
+	// A provider that cannot be reached is no fault of the token
+	p.srv.Close()
+	if _, err := provider.Verify(context.Background(), tkn); err == nil || errors.Cause(err) == auth.ErrInvalidToken {
+		t.Fatalf("expected an error other than %v while the provider is down, got %v", auth.ErrInvalidToken, err)
+	}
+}
+
+func TestDiscoverOIDCIssuerMismatch(t *testing.T) {
+	p := newIDP(t)
+
+	cfg := auth.OIDCConfig{
+		Issuer:   p.srv.URL + "/",
+		Audience: "garagesale",
+	}
+
+	if _, err := auth.DiscoverOIDC(context.Background(), cfg, p.srv.Client()); err == nil {
+		t.Fatal("expected discovery to fail when the document names another issuer")
+	}
+}
@@ -0,0 +1,183 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"strings"
+
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// These are the supported values for PasswordPolicy.Algorithm
+const (
+	AlgorithmArgon2id = "argon2id"
+	AlgorithmBcrypt   = "bcrypt"
+)
+
+// ErrUnknownHashFormat is returned for stored hashes no supported algorithm produced
+var ErrUnknownHashFormat = errors.New("unknown password hash format")
+
+// PasswordPolicy describes how new password hashes are produced. Hashes are
+// self-describing: argon2id hashes use the PHC string format
+// $argon2id$v=19$m=<memory>,t=<time>,p=<threads>$<salt>$<hash> and bcrypt
+// hashes their usual $2a$<cost>$ format, so every stored hash is verified
+// with the algorithm and parameters it was created with.
+type PasswordPolicy struct {
+	Algorithm     string `default:"argon2id"`
+	BcryptCost    int    `default:"10" split_words:"true"`
+	Argon2Time    uint32 `default:"3" split_words:"true"`
+	Argon2Memory  uint32 `default:"65536" split_words:"true"` // KiB
+	Argon2Threads uint8  `default:"2" split_words:"true"`
+	Argon2KeyLen  uint32 `default:"32" split_words:"true"`
+	Argon2SaltLen uint32 `default:"16" split_words:"true"`
+}
+
+// DefaultPasswordPolicy matches the defaults of the PasswordPolicy config tags
+var DefaultPasswordPolicy = PasswordPolicy{
+	Algorithm:     AlgorithmArgon2id,
+	BcryptCost:    bcrypt.DefaultCost,
+	Argon2Time:    3,
+	Argon2Memory:  64 * 1024,
+	Argon2Threads: 2,
+	Argon2KeyLen:  32,
+	Argon2SaltLen: 16,
+}
+
+// argon2Params are the parameters encoded into an argon2id hash
+type argon2Params struct {
+	memory  uint32
+	time    uint32
+	threads uint8
+	salt    []byte
+	key     []byte
+}
+
+// Validate returns an error when the policy cannot produce hashes
+func (p PasswordPolicy) Validate() error {
+	switch p.Algorithm {
+	case AlgorithmArgon2id:
+		if p.Argon2Time == 0 || p.Argon2Memory == 0 || p.Argon2Threads == 0 || p.Argon2KeyLen == 0 || p.Argon2SaltLen == 0 {
+			return errors.New("argon2id parameters must be positive")
+		}
+	case AlgorithmBcrypt:
+		if p.BcryptCost < bcrypt.MinCost || p.BcryptCost > bcrypt.MaxCost {
+			return errors.Errorf("bcrypt cost must be between %d and %d", bcrypt.MinCost, bcrypt.MaxCost)
+		}
+	default:
+		return errors.Errorf("unknown password hash algorithm %q", p.Algorithm)
+	}
+
+	return nil
+}
+
+// Hash returns the hash of password according to the policy
+func (p PasswordPolicy) Hash(password string) ([]byte, error) {
+	if err := p.Validate(); err != nil {
+		return nil, err
+	}
+
+	if p.Algorithm == AlgorithmBcrypt {
+		hash, err := bcrypt.GenerateFromPassword([]byte(password), p.BcryptCost)
+		if err != nil {
+			return nil, errors.Wrap(err, "generating bcrypt hash")
+		}
+
+		return hash, nil
+	}
+
+	salt := make([]byte, p.Argon2SaltLen)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, errors.Wrap(err, "generating salt")
+	}
+
+	key := argon2.IDKey([]byte(password), salt, p.Argon2Time, p.Argon2Memory, p.Argon2Threads, p.Argon2KeyLen)
+
+	hash := fmt.Sprintf(
+		"$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2.Version, p.Argon2Memory, p.Argon2Time, p.Argon2Threads,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(key),
+	)
+
+	return []byte(hash), nil
+}
+
+// Verify checks password against a stored hash using the algorithm the hash
+// was made with. On a match it also reports whether the hash is outdated
+// for the policy and should be replaced by a new one.
+func (p PasswordPolicy) Verify(hash []byte, password string) (ok bool, rehash bool, err error) {
+	switch {
+	case strings.HasPrefix(string(hash), "$argon2id$"):
+		params, err := parseArgon2(string(hash))
+		if err != nil {
+			return false, false, err
+		}
+
+		key := argon2.IDKey([]byte(password), params.salt, params.time, params.memory, params.threads, uint32(len(params.key)))
+		if subtle.ConstantTimeCompare(key, params.key) != 1 {
+			return false, false, nil
+		}
+
+		rehash = p.Algorithm != AlgorithmArgon2id ||
+			params.memory != p.Argon2Memory ||
+			params.time != p.Argon2Time ||
+			params.threads != p.Argon2Threads ||
+			uint32(len(params.key)) != p.Argon2KeyLen ||
+			uint32(len(params.salt)) != p.Argon2SaltLen
+
+		return true, rehash, nil
+
+	case strings.HasPrefix(string(hash), "$2"):
+		if err := bcrypt.CompareHashAndPassword(hash, []byte(password)); err != nil {
+			if err == bcrypt.ErrMismatchedHashAndPassword {
+				return false, false, nil
+			}
+
+			return false, false, errors.Wrap(err, "comparing bcrypt hash")
+		}
+
+		cost, err := bcrypt.Cost(hash)
+		if err != nil {
+			return false, false, errors.Wrap(err, "reading bcrypt cost")
+		}
+
+		rehash = p.Algorithm != AlgorithmBcrypt || cost != p.BcryptCost
+		return true, rehash, nil
+
+	default:
+		return false, false, ErrUnknownHashFormat
+	}
+}
+
+// parseArgon2 decodes a PHC formatted argon2id hash
+func parseArgon2(hash string) (argon2Params, error) {
+	// "", "argon2id", "v=19", "m=..,t=..,p=..", salt, key
+	parts := strings.Split(hash, "$")
+	if len(parts) != 6 {
+		return argon2Params{}, ErrUnknownHashFormat
+	}
+
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return argon2Params{}, errors.Wrap(ErrUnknownHashFormat, "unsupported argon2 version")
+	}
+
+	var params argon2Params
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &params.memory, &params.time, &params.threads); err != nil {
+		return argon2Params{}, errors.Wrap(ErrUnknownHashFormat, "parsing argon2 parameters")
+	}
+
+	var err error
+	if params.salt, err = base64.RawStdEncoding.DecodeString(parts[4]); err != nil {
+		return argon2Params{}, errors.Wrap(ErrUnknownHashFormat, "decoding argon2 salt")
+	}
+	if params.key, err = base64.RawStdEncoding.DecodeString(parts[5]); err != nil {
+		return argon2Params{}, errors.Wrap(ErrUnknownHashFormat, "decoding argon2 key")
+	}
+
+	return params, nil
+}
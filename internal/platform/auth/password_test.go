@@ -0,0 +1,73 @@
+package auth_test
+
+import (
+	"garagesale/internal/platform/auth"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// fastPolicy keeps the hashing cheap for tests
+var fastPolicy = auth.PasswordPolicy{
+	Algorithm:     auth.AlgorithmArgon2id,
+	BcryptCost:    bcrypt.MinCost,
+	Argon2Time:    1,
+	Argon2Memory:  1024,
+	Argon2Threads: 1,
+	Argon2KeyLen:  32,
+	Argon2SaltLen: 16,
+}
+
+func TestPasswordArgon2id(t *testing.T) {
+	hash, err := fastPolicy.Hash("secret")
+	if err != nil {
+		t.Fatalf("could not hash password: %v", err)
+	}
+
+	if !strings.HasPrefix(string(hash), "$argon2id$v=19$m=1024,t=1,p=1$") {
+		t.Fatalf("unexpected hash format: %s", hash)
+	}
+
+	ok, rehash, err := fastPolicy.Verify(hash, "secret")
+	if err != nil || !ok || rehash {
+		t.Fatalf("expected match without rehash, got ok=%v rehash=%v err=%v", ok, rehash, err)
+	}
+
+	ok, _, err = fastPolicy.Verify(hash, "wrong")
+	if err != nil || ok {
+		t.Fatalf("expected mismatch, got ok=%v err=%v", ok, err)
+	}
+
+	stronger := fastPolicy
+	stronger.Argon2Time = 2
+	if _, rehash, _ := stronger.Verify(hash, "secret"); !rehash {
+		t.Fatal("expected rehash when the policy parameters change")
+	}
+}
+
+func TestPasswordBcryptUpgrade(t *testing.T) {
+	legacy := fastPolicy
+	legacy.Algorithm = auth.AlgorithmBcrypt
+
+	hash, err := legacy.Hash("secret")
+	if err != nil {
+		t.Fatalf("could not hash password: %v", err)
+	}
+
+	ok, rehash, err := legacy.Verify(hash, "secret")
+	if err != nil || !ok || rehash {
+		t.Fatalf("expected match without rehash, got ok=%v rehash=%v err=%v", ok, rehash, err)
+	}
+
+	ok, rehash, err = fastPolicy.Verify(hash, "secret")
+	if err != nil || !ok || !rehash {
+		t.Fatalf("expected bcrypt hash to verify and need rehash, got ok=%v rehash=%v err=%v", ok, rehash, err)
+	}
+}
+
+func TestPasswordUnknownFormat(t *testing.T) {
+	if _, _, err := fastPolicy.Verify([]byte("plain"), "plain"); err != auth.ErrUnknownHashFormat {
+		t.Fatalf("expected %v, got %v", auth.ErrUnknownHashFormat, err)
+	}
+}
@@ -0,0 +1,54 @@
+package auth
+
+// These are the permissions roles can grant. Routes and business logic check
+// permissions instead of role names so roles can be changed in the database.
+const (
+	PermProductRead      = "product:read"
+	PermProductCreate    = "product:create"
+	PermProductUpdate    = "product:update"
+	PermProductUpdateAny = "product:update:any"
+	PermProductDelete    = "product:delete"
+	PermSaleRead         = "sale:read"
+	PermSaleCreate       = "sale:create"
+	PermSaleRefund       = "sale:refund"
+	PermUserManage       = "user:manage"
+	PermUserImpersonate  = "user:impersonate"
+	PermRoleManage       = "role:manage"
+)
+
+// Permissions lists every known permission
+var Permissions = []string{
+	PermProductRead,
+	PermProductCreate,
+	PermProductUpdate,
+	PermProductUpdateAny,
+	PermProductDelete,
+	PermSaleRead,
+	PermSaleCreate,
+	PermSaleRefund,
+	PermUserManage,
+	PermUserImpersonate,
+	PermRoleManage,
+}
+
+// IsPermission returns true if p is a known permission
+func IsPermission(p string) bool {
+	for _, known := range Permissions {
+		if known == p {
+			return true
+		}
+	}
+
+	return false
+}
+
+// HasPermission returns true if the claims grant the provided permission
+func (c *Claims) HasPermission(permission string) bool {
+	for _, has := range c.Permissions {
+		if has == permission {
+			return true
+		}
+	}
+
+	return false
+}
@@ -4,6 +4,7 @@ import (
 	"time"
 
 	"github.com/golang-jwt/jwt/v4"
+	"github.com/google/uuid"
 )
 
 // ctxKey represents the type of value for the context key
@@ -12,30 +13,74 @@ type ctxKey int
 // Key is used to store/retrieve Claims value fron context
 const Key ctxKey = 1
 
-// These are expected values for Claims.Roles
+// These are the built-in roles every organization can use. The permissions
+// they grant are stored in the database.
 const (
 	RoleAdmin = "ADMIN"
 	RoleUser  = "USER"
 )
 
+// These are expected values for Claims.Purpose
+const (
+	// PurposeTwoFactor marks a challenge token that can only be exchanged for
+	// real claims together with a second factor
+	PurposeTwoFactor = "2fa"
+)
+
 // Claims represents the authorization claims transmited via a jwt
 type Claims struct {
 	Roles []string
+
+	// OrgID is the organization the token was issued for. Roles are the
+	// roles of the user inside that organization
+	OrgID string `json:"org,omitempty"`
+
+	// Permissions are resolved from the roles when the token is issued
+	Permissions []string `json:"perms,omitempty"`
+
+	// Purpose restricts what a token can be used for. Tokens with a purpose
+	// are not accepted for regular requests
+	Purpose string `json:"purpose,omitempty"`
+
+	// APIKeyID is set when the claims come from an API key instead of a token
+	APIKeyID string `json:"-"`
+
+	// TokenVersion is the token generation of the user at the time of issue.
+	// Tokens with an older version than the one stored for the user are revoked
+	TokenVersion int `json:"tv,omitempty"`
+
+	// Actor is set when the token was issued to someone impersonating the
+	// subject (RFC 8693)
+	Actor *Actor `json:"act,omitempty"`
+
 	jwt.StandardClaims
 }
 
+// Actor identifies who acts on behalf of the subject of a token
+type Actor struct {
+	Subject string `json:"sub"`
+}
+
 // NewClaims construct a Claims value for the indetified user. The Claims
-// expire within a specified duration of the provided time. Additional fields of the Claims
-// can be set after calling NewClaims is desired
+// expire within a specified duration of the provided time and carry a unique
+// token ID so they can be revoked. A zero duration leaves the expiry to the
+// TokenPolicy of the Authenticator generating the token. Additional fields
+// of the Claims can be set after calling NewClaims is desired
 func NewClaims(subject string, roles []string, now time.Time, expires time.Duration) Claims {
-	return Claims{
+	c := Claims{
 		Roles: roles,
 		StandardClaims: jwt.StandardClaims{
-			Subject:   subject,
-			IssuedAt:  now.Unix(),
-			ExpiresAt: now.Add(expires).Unix(),
+			Id:       uuid.New().String(),
+			Subject:  subject,
+			IssuedAt: now.Unix(),
 		},
 	}
+
+	if expires > 0 {
+		c.ExpiresAt = now.Add(expires).Unix()
+	}
+
+	return c
 }
 
 // HasRoles returns true if the claims has one of the provided roles
@@ -50,3 +95,9 @@ func (c *Claims) HasRoles(roles ...string) bool {
 
 	return false
 }
+
+// Impersonated returns true if the claims were issued to someone acting on
+// behalf of their subject
+func (c *Claims) Impersonated() bool {
+	return c.Actor != nil
+}
@@ -0,0 +1,110 @@
+package auth
+
+import (
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/pkg/errors"
+)
+
+// Predefined errors for tokens whose registered claims do not match the
+// TokenPolicy. They are returned as is so clients can tell them apart.
+// ErrInvalidToken is the cause of every other token that is rejected.
+var (
+	ErrInvalidToken     = errors.New("token is invalid")
+	ErrTokenExpired     = errors.New("token has expired")
+	ErrTokenNotYetValid = errors.New("token is not valid yet")
+	ErrInvalidIssuer    = errors.New("token was issued by an unexpected issuer")
+	ErrInvalidAudience  = errors.New("token was issued for an unexpected audience")
+)
+
+// TokenPolicy describes the registered claims of tokens we issue and how
+// they are checked. ClockSkew is the leeway given to exp, nbf and iat for
+// clocks of other services that drift from ours.
+type TokenPolicy struct {
+	TTL       time.Duration `default:"1h"`
+	Issuer    string        `default:"garagesale"`
+	Audience  string        `default:"sales-api"`
+	ClockSkew time.Duration `default:"30s" split_words:"true"`
+}
+
+// DefaultTokenPolicy matches the defaults of the TokenPolicy config tags
+var DefaultTokenPolicy = TokenPolicy{
+	TTL:       time.Hour,
+	Issuer:    "garagesale",
+	Audience:  "sales-api",
+	ClockSkew: 30 * time.Second,
+}
+
+// Validate returns an error when the policy cannot issue tokens
+func (p TokenPolicy) Validate() error {
+	if p.TTL <= 0 {
+		return errors.New("token TTL must be positive")
+	}
+
+	if p.ClockSkew < 0 {
+		return errors.New("clock skew cannot be negative")
+	}
+
+	return nil
+}
+
+// apply sets the registered claims the policy decides on. Claims that were
+// given an expiry keep it, everything else expires after TTL.
+func (p TokenPolicy) apply(claims *Claims) {
+	if claims.IssuedAt == 0 {
+		claims.IssuedAt = time.Now().Unix()
+	}
+
+	if claims.ExpiresAt == 0 {
+		claims.ExpiresAt = time.Unix(claims.IssuedAt, 0).Add(p.TTL).Unix()
+	}
+
+	claims.NotBefore = claims.IssuedAt
+	claims.Issuer = p.Issuer
+	claims.Audience = p.Audience
+}
+
+// check verifies the registered claims of a token at now. Issuer and
+// audience are only checked when the policy sets them.
+func (p TokenPolicy) check(claims Claims, now time.Time) error {
+	skew := int64(p.ClockSkew / time.Second)
+
+	if !claims.VerifyExpiresAt(now.Unix()-skew, true) {
+		return ErrTokenExpired
+	}
+
+	if !claims.VerifyNotBefore(now.Unix()+skew, false) || !claims.VerifyIssuedAt(now.Unix()+skew, false) {
+		return ErrTokenNotYetValid
+	}
+
+	if p.Issuer != "" && !claims.VerifyIssuer(p.Issuer, true) {
+		return ErrInvalidIssuer
+	}
+
+	if p.Audience != "" && !claims.VerifyAudience(p.Audience, true) {
+		return ErrInvalidAudience
+	}
+
+	return nil
+}
+
+// tokenError returns the error for a token jwt could not parse. Keys that
+// could not be looked up for other reasons than an unknown key id, such as a
+// key server that is down, are no fault of the token and returned as is.
+func tokenError(err error) error {
+	if ve, ok := err.(*jwt.ValidationError); ok {
+		switch {
+		case ve.Inner != nil && errors.Cause(ve.Inner) == ErrInvalidToken:
+			return ve.Inner
+		case ve.Errors&jwt.ValidationErrorUnverifiable != 0 && ve.Inner != nil:
+			return errors.Wrap(ve.Inner, "looking up token key")
+		case ve.Errors&jwt.ValidationErrorExpired != 0:
+			return ErrTokenExpired
+		case ve.Errors&jwt.ValidationErrorNotValidYet != 0:
+			return ErrTokenNotYetValid
+		}
+	}
+
+	return errors.Wrapf(ErrInvalidToken, "parsing token: %v", err)
+}
@@ -0,0 +1,76 @@
+package auth_test
+
+import (
+	"garagesale/internal/platform/auth"
+	"testing"
+	"time"
+)
+
+func TestTokenPolicy(t *testing.T) {
+	dir := t.TempDir()
+	writeKey(t, dir, "kid")
+
+	keys, err := auth.NewKeyStore(dir, "RS256")
+	if err != nil {
+		t.Fatalf("could not load keys: %v", err)
+	}
+
+	policy := auth.TokenPolicy{
+		TTL:       10 * time.Minute,
+		Issuer:    "garagesale",
+		Audience:  "sales-api",
+		ClockSkew: time.Minute,
+	}
+
+	a, err := auth.NewKeyStoreAuthenticator(keys, policy)
+	if err != nil {
+		t.Fatalf("could not create authenticator: %v", err)
+	}
+
+	now := time.Now()
+	tkn, err := a.GenerateToken(auth.NewClaims("user", nil, now, 0))
+	if err != nil {
+		t.Fatalf("could not generate token: %v", err)
+	}
+
+	claims, err := a.ParseClaims(tkn)
+	if err != nil {
+		t.Fatalf("could not parse token: %v", err)
+	}
+
+	if claims.Issuer != policy.Issuer || claims.Audience != policy.Audience {
+		t.Fatalf("expected issuer and audience of the policy, got %q and %q", claims.Issuer, claims.Audience)
+	}
+	if want := now.Add(policy.TTL).Unix(); claims.ExpiresAt != want {
+		t.Fatalf("expected expiry %d, got %d", want, claims.ExpiresAt)
+	}
+
+	tests := []struct {
+		name   string
+		policy auth.TokenPolicy
+		claims auth.Claims
+		want   error
+	}{
+		{"expired within skew", policy, auth.NewClaims("user", nil, now.Add(-time.Hour), time.Hour-30*time.Second), nil},
+		{"expired", policy, auth.NewClaims("user", nil, now.Add(-time.Hour), time.Hour-2*time.Minute), auth.ErrTokenExpired},
+		{"issued in the future", policy, auth.NewClaims("user", nil, now.Add(5*time.Minute), time.Hour), auth.ErrTokenNotYetValid},
+		{"other issuer", auth.TokenPolicy{TTL: time.Hour, Issuer: "someone-else", Audience: "sales-api"}, auth.NewClaims("user", nil, now, 0), auth.ErrInvalidIssuer},
+		{"other audience", auth.TokenPolicy{TTL: time.Hour, Issuer: "garagesale", Audience: "another-api"}, auth.NewClaims("user", nil, now, 0), auth.ErrInvalidAudience},
+	}
+
+	for _, tt := range tests {
+		issuer, err := auth.NewKeyStoreAuthenticator(keys, tt.policy)
+		if err != nil {
+			t.Fatalf("%s: could not create authenticator: %v", tt.name, err)
+		}
+
+		tkn, err := issuer.GenerateToken(tt.claims)
+		if err != nil {
+			t.Fatalf("%s: could not generate token: %v", tt.name, err)
+		}
+
+		if _, err := a.ParseClaims(tkn); err != tt.want {
+			t.Errorf("%s: expected %v, got %v", tt.name, tt.want, err)
+		}
+	}
+}
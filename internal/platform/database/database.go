@@ -2,10 +2,11 @@ package database
 
 import (
 	"context"
+	"database/sql"
 	"net/url"
 
 	"github.com/jmoiron/sqlx"
-	_ "github.com/lib/pq" // Register postgres database
+	"github.com/lib/pq"
 )
 
 type Config struct {
@@ -34,7 +35,15 @@ func Open(c Config) (*sqlx.DB, error) {
 		RawQuery: q.Encode(),
 	}
 
-	return sqlx.Open("postgres", u.String())
+	connector, err := pq.NewConnector(u.String())
+	if err != nil {
+		return nil, err
+	}
+
+	// Queries are tagged with the request ID of their context
+	db := sql.OpenDB(traceConnector{Connector: connector})
+
+	return sqlx.NewDb(db, "postgres"), nil
 }
 
 // StatusCheck returns nil if it can successfully talk to
@@ -0,0 +1,95 @@
+package database
+
+import (
+	"context"
+	"database/sql/driver"
+	"garagesale/internal/platform/trace"
+	"strings"
+)
+
+// traceConnector opens connections that tag queries with the request ID of
+// their context so they can be found in the database logs
+type traceConnector struct {
+	driver.Connector
+}
+
+func (c traceConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	conn, err := c.Connector.Connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return &traceConn{conn: conn}, nil
+}
+
+// traceConn prefixes queries run with a context carrying a request ID with
+// a comment naming it
+type traceConn struct {
+	conn driver.Conn
+}
+
+// traced prepends the request ID of ctx to query as a comment. IDs are
+// checked again so nothing that could end the comment reaches the query.
+// COPY statements are left alone as pq recognizes them by their prefix.
+func traced(ctx context.Context, query string) string {
+	id := trace.FromContext(ctx)
+	if !trace.Valid(id) || len(query) >= 4 && strings.EqualFold(query[:4], "COPY") {
+		return query
+	}
+
+	return "/* trace_id=" + id + " */ " + query
+}
+
+func (c *traceConn) Prepare(query string) (driver.Stmt, error) {
+	return c.conn.Prepare(query)
+}
+
+func (c *traceConn) PrepareContext(ctx context.Context, query string) (driver.Stmt, error) {
+	if p, ok := c.conn.(driver.ConnPrepareContext); ok {
+		return p.PrepareContext(ctx, traced(ctx, query))
+	}
+
+	return c.conn.Prepare(traced(ctx, query))
+}
+
+func (c *traceConn) Close() error {
+	return c.conn.Close()
+}
+
+func (c *traceConn) Begin() (driver.Tx, error) {
+	return c.conn.Begin()
+}
+
+func (c *traceConn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	if b, ok := c.conn.(driver.ConnBeginTx); ok {
+		return b.BeginTx(ctx, opts)
+	}
+
+	return c.conn.Begin()
+}
+
+func (c *traceConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	e, ok := c.conn.(driver.ExecerContext)
+	if !ok {
+		return nil, driver.ErrSkip
+	}
+
+	return e.ExecContext(ctx, traced(ctx, query), args)
+}
+
+func (c *traceConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	q, ok := c.conn.(driver.QueryerContext)
+	if !ok {
+		return nil, driver.ErrSkip
+	}
+
+	return q.QueryContext(ctx, traced(ctx, query), args)
+}
+
+func (c *traceConn) Ping(ctx context.Context) error {
+	if p, ok := c.conn.(driver.Pinger); ok {
+		return p.Ping(ctx)
+	}
+
+	return nil
+}
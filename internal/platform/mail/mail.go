@@ -0,0 +1,170 @@
+// Package mail sends messages to the email addresses of users
+package mail
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"mime"
+	"net"
+	"net/smtp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Message is an email to a single recipient
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Mailer delivers messages. Messages can carry secrets such as verification
+// tokens, implementations must not log their bodies.
+type Mailer interface {
+	Send(ctx context.Context, m Message) error
+}
+
+// ErrInvalidConfig is returned for SMTP settings messages cannot be sent with
+var ErrInvalidConfig = errors.New("mail config is invalid")
+
+// Config describes the SMTP server messages are sent through. Sending is
+// disabled when Host is blank.
+type Config struct {
+	Host     string
+	Port     int `default:"587"`
+	Username string
+	Password string
+	From     string
+
+	// InsecureSkipVerify accepts any certificate of the server. It is meant
+	// for mail catchers during local development.
+	InsecureSkipVerify bool          `split_words:"true"`
+	Timeout            time.Duration `default:"10s"`
+}
+
+// Validate returns an error when messages cannot be sent with the config
+func (c Config) Validate() error {
+	if c.Host == "" || c.From == "" {
+		return errors.Wrap(ErrInvalidConfig, "host and sender cannot be blank")
+	}
+
+	if c.Port <= 0 || c.Port > 65535 {
+		return errors.Wrapf(ErrInvalidConfig, "invalid port %d", c.Port)
+	}
+
+	if c.Timeout <= 0 {
+		return errors.Wrap(ErrInvalidConfig, "timeout must be positive")
+	}
+
+	return nil
+}
+
+// SMTP is a Mailer that sends messages through an SMTP server. The
+// connection is upgraded with STARTTLS whenever the server offers it;
+// credentials are only sent over TLS.
+type SMTP struct {
+	cfg Config
+}
+
+// NewSMTP constructs an SMTP Mailer for use
+func NewSMTP(cfg Config) (*SMTP, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+
+	return &SMTP{cfg: cfg}, nil
+}
+
+// Send delivers the message to the server
+func (s *SMTP) Send(ctx context.Context, m Message) error {
+	data, err := s.format(m)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, s.cfg.Timeout)
+	defer cancel()
+
+	addr := net.JoinHostPort(s.cfg.Host, strconv.Itoa(s.cfg.Port))
+
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return errors.Wrap(err, "connecting to mail server")
+	}
+	defer conn.Close()
+
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	c, err := smtp.NewClient(conn, s.cfg.Host)
+	if err != nil {
+		return errors.Wrap(err, "greeting mail server")
+	}
+	defer c.Close()
+
+	if ok, _ := c.Extension("STARTTLS"); ok {
+		tlsCfg := tls.Config{ServerName: s.cfg.Host, InsecureSkipVerify: s.cfg.InsecureSkipVerify}
+		if err := c.StartTLS(&tlsCfg); err != nil {
+			return errors.Wrap(err, "starting TLS")
+		}
+	}
+
+	if s.cfg.Username != "" {
+		auth := smtp.PlainAuth("", s.cfg.Username, s.cfg.Password, s.cfg.Host)
+		if err := c.Auth(auth); err != nil {
+			return errors.Wrap(err, "authenticating to mail server")
+		}
+	}
+
+	if err := c.Mail(s.cfg.From); err != nil {
+		return errors.Wrap(err, "setting sender")
+	}
+
+	if err := c.Rcpt(m.To); err != nil {
+		return errors.Wrap(err, "setting recipient")
+	}
+
+	w, err := c.Data()
+	if err != nil {
+		return errors.Wrap(err, "starting message")
+	}
+
+	if _, err := w.Write(data); err != nil {
+		return errors.Wrap(err, "writing message")
+	}
+
+	if err := w.Close(); err != nil {
+		return errors.Wrap(err, "sending message")
+	}
+
+	return c.Quit()
+}
+
+// format renders the message with its headers. Header values cannot span
+// lines so recipients cannot add headers of their own.
+func (s *SMTP) format(m Message) ([]byte, error) {
+	for _, v := range []string{m.To, m.Subject} {
+		if strings.ContainsAny(v, "\r\n") {
+			return nil, errors.New("message headers cannot contain line breaks")
+		}
+	}
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "From: %s\r\n", s.cfg.From)
+	fmt.Fprintf(&buf, "To: %s\r\n", m.To)
+	fmt.Fprintf(&buf, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", m.Subject))
+	buf.WriteString("MIME-Version: 1.0\r\n")
+	buf.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	buf.WriteString("\r\n")
+	buf.WriteString(strings.ReplaceAll(m.Body, "\n", "\r\n"))
+	buf.WriteString("\r\n")
+
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,132 @@
+package mail_test
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"garagesale/internal/platform/mail"
+	"github.com/pkg/errors"
+)
+
+// serve accepts one SMTP session on ln and returns the message data it
+// received
+func serve(t *testing.T, ln net.Listener) <-chan string {
+	t.Helper()
+
+	out := make(chan string, 1)
+	go func() {
+		defer close(out)
+
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		r := bufio.NewReader(conn)
+		reply := func(s string) { conn.Write([]byte(s + "\r\n")) }
+
+		reply("220 localhost ready")
+		var data strings.Builder
+		inData := false
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				return
+			}
+
+			if inData {
+				if line == ".\r\n" {
+					inData = false
+					reply("250 queued")
+					continue
+				}
+				data.WriteString(line)
+				continue
+			}
+
+			switch cmd := strings.ToUpper(strings.TrimSpace(line)); {
+			case strings.HasPrefix(cmd, "EHLO"), strings.HasPrefix(cmd, "HELO"):
+				reply("250 localhost")
+			case cmd == "DATA":
+				inData = true
+				reply("354 go ahead")
+			case cmd == "QUIT":
+				reply("221 bye")
+				out <- data.String()
+				return
+			default:
+				reply("250 ok")
+			}
+		}
+	}()
+
+	return out
+}
+
+func TestSMTPSend(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	received := serve(t, ln)
+
+	addr := ln.Addr().(*net.TCPAddr)
+	m, err := mail.NewSMTP(mail.Config{
+		Host:    "127.0.0.1",
+		Port:    addr.Port,
+		From:    "noreply@garagesale.test",
+		Timeout: 5 * time.Second,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	msg := mail.Message{To: "jane@example.com", Subject: "Verify", Body: "token: abc\n"}
+	if err := m.Send(context.Background(), msg); err != nil {
+		t.Fatalf("sending: %s", err)
+	}
+
+	data := <-received
+	for _, want := range []string{"From: noreply@garagesale.test\r\n", "To: jane@example.com\r\n", "Subject: Verify\r\n", "token: abc\r\n"} {
+		if !strings.Contains(data, want) {
+			t.Errorf("message should contain %q, got:\n%s", want, data)
+		}
+	}
+}
+
+func TestSMTPRejectsHeaderInjection(t *testing.T) {
+	m, err := mail.NewSMTP(mail.Config{Host: "127.0.0.1", Port: 25, From: "noreply@garagesale.test", Timeout: 5 * time.Second})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	msg := mail.Message{To: "jane@example.com\r\nBcc: eve@example.com", Subject: "Verify"}
+	if err := m.Send(context.Background(), msg); err == nil {
+		t.Fatal("a recipient spanning lines should be rejected")
+	}
+}
+
+func TestNewSMTPValidates(t *testing.T) {
+	tests := []mail.Config{
+		{Port: 587, From: "noreply@garagesale.test", Timeout: 5 * time.Second},
+		{Host: "smtp.test", Port: 587, Timeout: 5 * time.Second},
+		{Host: "smtp.test", Port: 0, From: "noreply@garagesale.test", Timeout: 5 * time.Second},
+		{Host: "smtp.test", Port: 587, From: "noreply@garagesale.test"},
+	}
+
+	for i, cfg := range tests {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			if _, err := mail.NewSMTP(cfg); errors.Cause(err) != mail.ErrInvalidConfig {
+				t.Fatalf("should fail with ErrInvalidConfig, got %v", err)
+			}
+		})
+	}
+}
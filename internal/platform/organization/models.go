@@ -0,0 +1,32 @@
+package organization
+
+import (
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// DefaultID identifies the organization created by the migrations. Data that
+// existed before organizations were introduced belongs to it.
+const DefaultID = "00000000-0000-0000-0000-000000000001"
+
+// Organization is an independent group with its own users, products and sales
+type Organization struct {
+	ID          string    `db:"org_id" json:"id"`
+	Name        string    `db:"name" json:"name"`
+	DateCreated time.Time `db:"date_created" json:"date_created"`
+	DateUpdated time.Time `db:"date_updated" json:"date_updated"`
+}
+
+// NewOrganization contains information needed to create a new Organization
+type NewOrganization struct {
+	Name string `json:"name" validate:"required"`
+}
+
+// Membership grants a user a set of roles inside one Organization
+type Membership struct {
+	OrgID       string         `db:"org_id" json:"org_id"`
+	UserID      string         `db:"user_id" json:"user_id"`
+	Roles       pq.StringArray `db:"roles" json:"roles"`
+	DateCreated time.Time      `db:"date_created" json:"date_created"`
+}
@@ -0,0 +1,185 @@
+package organization
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+	"github.com/pkg/errors"
+)
+
+// Predefined errors for known failure scenarios
+var (
+	ErrNotFound           = errors.New("organization not found")
+	ErrInvalidID          = errors.New("ID provided was not a valid ID")
+	ErrMembershipNotFound = errors.New("user is not a member of the organization")
+	ErrAlreadyMember      = errors.New("user is already a member of the organization")
+)
+
+// Create inserts a new Organization into the database
+func Create(ctx context.Context, db *sqlx.DB, no NewOrganization, now time.Time) (*Organization, error) {
+	o := Organization{
+		ID:          uuid.New().String(),
+		Name:        no.Name,
+		DateCreated: now.UTC(),
+		DateUpdated: now.UTC(),
+	}
+
+	const q = `
+		INSERT INTO organizations
+		(org_id, name, date_created, date_updated)
+		VALUES ($1, $2, $3, $4)
+	`
+
+	if _, err := db.ExecContext(ctx, q, o.ID, o.Name, o.DateCreated, o.DateUpdated); err != nil {
+		return nil, errors.Wrap(err, "inserting organization")
+	}
+
+	return &o, nil
+}
+
+// Retrieve returns a single Organization
+func Retrieve(ctx context.Context, db *sqlx.DB, id string) (*Organization, error) {
+	if _, err := uuid.Parse(id); err != nil {
+		return nil, ErrInvalidID
+	}
+
+	const q = `SELECT * FROM organizations WHERE org_id = $1`
+
+	var o Organization
+	if err := db.GetContext(ctx, &o, q, id); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrNotFound
+		}
+
+		return nil, errors.Wrapf(err, "selecting organization %q", id)
+	}
+
+	return &o, nil
+}
+
+// List returns all known Organizations
+func List(ctx context.Context, db *sqlx.DB) ([]Organization, error) {
+	list := []Organization{}
+
+	const q = `SELECT * FROM organizations ORDER BY name`
+	if err := db.SelectContext(ctx, &list, q); err != nil {
+		return nil, errors.Wrap(err, "selecting organizations")
+	}
+
+	return list, nil
+}
+
+// Memberships returns every Organization the user belongs to, oldest first
+func Memberships(ctx context.Context, db *sqlx.DB, userID string) ([]Membership, error) {
+	list := []Membership{}
+
+	const q = `SELECT * FROM memberships WHERE user_id = $1 ORDER BY date_created, org_id`
+	if err := db.SelectContext(ctx, &list, q, userID); err != nil {
+		return nil, errors.Wrapf(err, "selecting memberships of user %q", userID)
+	}
+
+	return list, nil
+}
+
+// RetrieveMembership returns the Membership of a user in a single Organization
+func RetrieveMembership(ctx context.Context, db *sqlx.DB, orgID, userID string) (*Membership, error) {
+	if _, err := uuid.Parse(orgID); err != nil {
+		return nil, ErrInvalidID
+	}
+
+	const q = `SELECT * FROM memberships WHERE org_id = $1 AND user_id = $2`
+
+	var m Membership
+	if err := db.GetContext(ctx, &m, q, orgID, userID); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrMembershipNotFound
+		}
+
+		return nil, errors.Wrap(err, "selecting membership")
+	}
+
+	return &m, nil
+}
+
+// AddMember grants a user roles inside an Organization. Existing roles of
+// the user in that Organization are replaced.
+func AddMember(ctx context.Context, db *sqlx.DB, orgID, userID string, roles []string, now time.Time) error {
+	if _, err := uuid.Parse(orgID); err != nil {
+		return ErrInvalidID
+	}
+
+	const q = `
+		INSERT INTO memberships
+		(org_id, user_id, roles, date_created)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (org_id, user_id) DO UPDATE SET roles = EXCLUDED.roles
+	`
+
+	if _, err := db.ExecContext(ctx, q, orgID, userID, pq.StringArray(roles), now.UTC()); err != nil {
+		return errors.Wrap(err, "inserting membership")
+	}
+
+	return nil
+}
+
+// RemoveMember removes a user from an Organization
+func RemoveMember(ctx context.Context, db *sqlx.DB, orgID, userID string) error {
+	if _, err := uuid.Parse(orgID); err != nil {
+		return ErrInvalidID
+	}
+
+	const q = `DELETE FROM memberships WHERE org_id = $1 AND user_id = $2`
+
+	res, err := db.ExecContext(ctx, q, orgID, userID)
+	if err != nil {
+		return errors.Wrap(err, "deleting membership")
+	}
+
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return ErrMembershipNotFound
+	}
+
+	return nil
+}
+
+// MoveMember moves a user with their roles from one Organization to another.
+// Users that already belong to the other Organization keep their roles there
+// and are not moved.
+func MoveMember(ctx context.Context, db *sqlx.DB, fromOrgID, toOrgID, userID string) error {
+	if _, err := uuid.Parse(fromOrgID); err != nil {
+		return ErrInvalidID
+	}
+	if _, err := uuid.Parse(toOrgID); err != nil {
+		return ErrInvalidID
+	}
+
+	const q = `
+		UPDATE memberships SET
+		org_id = $2
+		WHERE org_id = $1 AND user_id = $3
+	`
+
+	res, err := db.ExecContext(ctx, q, fromOrgID, toOrgID, userID)
+	if err != nil {
+		if pqErr, ok := err.(*pq.Error); ok {
+			switch pqErr.Code {
+			case "23505":
+				return ErrAlreadyMember
+			case "23503":
+				return ErrNotFound
+			}
+		}
+
+		return errors.Wrap(err, "moving membership")
+	}
+
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return ErrMembershipNotFound
+	}
+
+	return nil
+}
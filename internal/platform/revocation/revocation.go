@@ -0,0 +1,225 @@
+// Package revocation keeps track of tokens that were revoked before they
+// expired. Entries are only kept until the token would have expired anyway.
+package revocation
+
+import (
+	"context"
+	"garagesale/internal/platform/auth"
+	"sync"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+)
+
+// ErrRevoked is returned for claims of a revoked token
+var ErrRevoked = errors.New("token has been revoked")
+
+// sweepInterval is how often the in-memory entries are scanned for ones that
+// expired. Expired entries are ignored when read, sweeping only bounds memory
+// without scanning every entry on each write.
+const sweepInterval = time.Minute
+
+// Store records revoked token IDs until they expire
+type Store interface {
+	// Revoke marks the token ID as revoked until expires
+	Revoke(ctx context.Context, jti string, expires time.Time) error
+
+	// IsRevoked reports whether the token ID was revoked and has not expired yet
+	IsRevoked(ctx context.Context, jti string, now time.Time) (bool, error)
+}
+
+// Memory is a Store that lives in the memory of a single process
+type Memory struct {
+	mu        sync.Mutex
+	entries   map[string]time.Time
+	nextSweep time.Time
+}
+
+// NewMemory constructs an empty Memory store for use
+func NewMemory() *Memory {
+	return &Memory{entries: make(map[string]time.Time)}
+}
+
+// Revoke marks the token ID as revoked until expires. Expired entries are
+// dropped on the way, at most once every sweepInterval.
+func (m *Memory) Revoke(ctx context.Context, jti string, expires time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if now := time.Now(); !now.Before(m.nextSweep) {
+		for id, exp := range m.entries {
+			if !now.Before(exp) {
+				delete(m.entries, id)
+			}
+		}
+		m.nextSweep = now.Add(sweepInterval)
+	}
+
+	m.entries[jti] = expires
+	return nil
+}
+
+// IsRevoked reports whether the token ID was revoked and has not expired yet
+func (m *Memory) IsRevoked(ctx context.Context, jti string, now time.Time) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	exp, ok := m.entries[jti]
+	if !ok {
+		return false, nil
+	}
+
+	if !now.Before(exp) {
+		delete(m.entries, jti)
+		return false, nil
+	}
+
+	return true, nil
+}
+
+// Postgres is a Store shared by every process using the database
+type Postgres struct {
+	db *sqlx.DB
+}
+
+// NewPostgres constructs a Postgres store for use
+func NewPostgres(db *sqlx.DB) *Postgres {
+	return &Postgres{db: db}
+}
+
+// Revoke marks the token ID as revoked until expires. Expired entries are
+// deleted on the way.
+func (p *Postgres) Revoke(ctx context.Context, jti string, expires time.Time) error {
+	const qd = `DELETE FROM revoked_tokens WHERE expires_at <= $1`
+	if _, err := p.db.ExecContext(ctx, qd, time.Now().UTC()); err != nil {
+		return errors.Wrap(err, "deleting expired revocations")
+	}
+
+	const q = `
+		INSERT INTO revoked_tokens
+		(jti, expires_at)
+		VALUES
+		($1, $2)
+		ON CONFLICT (jti) DO NOTHING
+	`
+
+	if _, err := p.db.ExecContext(ctx, q, jti, expires.UTC()); err != nil {
+		return errors.Wrapf(err, "revoking token %q", jti)
+	}
+
+	return nil
+}
+
+// IsRevoked reports whether the token ID was revoked and has not expired yet
+func (p *Postgres) IsRevoked(ctx context.Context, jti string, now time.Time) (bool, error) {
+	const q = `SELECT EXISTS (SELECT 1 FROM revoked_tokens WHERE jti = $1 AND expires_at > $2)`
+
+	var revoked bool
+	if err := p.db.GetContext(ctx, &revoked, q, jti, now.UTC()); err != nil {
+		return false, errors.Wrapf(err, "checking revocation of token %q", jti)
+	}
+
+	return revoked, nil
+}
+
+// cacheEntry is a cached answer of the underlying store that is trusted
+// until the given time
+type cacheEntry struct {
+	revoked bool
+	until   time.Time
+}
+
+// Cache keeps answers of a Store in memory to keep them off the store for
+// every request. A revoked token stays revoked until it expires, so only the
+// answer that a token is not revoked is read again after ttl. Revocations made
+// through the cache are seen right away, revocations made by other processes
+// within ttl.
+type Cache struct {
+	store Store
+	ttl   time.Duration
+
+	mu        sync.Mutex
+	entries   map[string]cacheEntry
+	nextSweep time.Time
+}
+
+// NewCache constructs a Cache in front of store for use
+func NewCache(store Store, ttl time.Duration) *Cache {
+	return &Cache{
+		store:   store,
+		ttl:     ttl,
+		entries: make(map[string]cacheEntry),
+	}
+}
+
+// Revoke marks the token of the claims as revoked until it expires
+func (c *Cache) Revoke(ctx context.Context, claims auth.Claims) error {
+	if claims.Id == "" {
+		return errors.New("token has no ID to revoke")
+	}
+
+	expires := time.Unix(claims.ExpiresAt, 0)
+	if err := c.store.Revoke(ctx, claims.Id, expires); err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.entries[claims.Id] = cacheEntry{revoked: true, until: expires}
+	return nil
+}
+
+// Check returns ErrRevoked if the token of the claims was revoked. Tokens
+// without an ID predate revocation and are let through. It can be used as a
+// middleware.ClaimsCheck.
+func (c *Cache) Check(ctx context.Context, claims auth.Claims) error {
+	if claims.Id == "" {
+		return nil
+	}
+
+	now := time.Now()
+
+	c.mu.Lock()
+	e, ok := c.entries[claims.Id]
+	c.mu.Unlock()
+
+	if !ok || !now.Before(e.until) {
+		revoked, err := c.store.IsRevoked(ctx, claims.Id, now)
+		if err != nil {
+			return err
+		}
+
+		e = cacheEntry{revoked: revoked, until: now.Add(c.ttl)}
+		if revoked {
+			e.until = time.Unix(claims.ExpiresAt, 0)
+		}
+
+		c.mu.Lock()
+		c.prune(now)
+		c.entries[claims.Id] = e
+		c.mu.Unlock()
+	}
+
+	if e.revoked {
+		return ErrRevoked
+	}
+
+	return nil
+}
+
+// prune drops entries that are no longer trusted, at most once every
+// sweepInterval. The caller must hold mu.
+func (c *Cache) prune(now time.Time) {
+	if now.Before(c.nextSweep) {
+		return
+	}
+	c.nextSweep = now.Add(sweepInterval)
+
+	for jti, e := range c.entries {
+		if !now.Before(e.until) {
+			delete(c.entries, jti)
+		}
+	}
+}
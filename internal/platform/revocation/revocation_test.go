@@ -0,0 +1,109 @@
+package revocation_test
+
+import (
+	"context"
+	"garagesale/internal/platform/auth"
+	"garagesale/internal/platform/database/databasetest"
+	"garagesale/internal/platform/revocation"
+	"testing"
+	"time"
+)
+
+func TestMemoryExpires(t *testing.T) {
+	ctx := context.Background()
+	store := revocation.NewMemory()
+	now := time.Now()
+
+	if err := store.Revoke(ctx, "jti", now.Add(time.Minute)); err != nil {
+		t.Fatalf("could not revoke: %v", err)
+	}
+
+	if revoked, _ := store.IsRevoked(ctx, "jti", now); !revoked {
+		t.Fatal("expected token to be revoked")
+	}
+
+	if revoked, _ := store.IsRevoked(ctx, "jti", now.Add(2*time.Minute)); revoked {
+		t.Fatal("expected revocation to expire with the token")
+	}
+}
+
+func TestPostgres(t *testing.T) {
+	db, teardown := databasetest.Setup(t)
+	t.Cleanup(teardown)
+	ctx := context.Background()
+
+	store := revocation.NewPostgres(db)
+	now := time.Now()
+
+	if revoked, err := store.IsRevoked(ctx, "jti", now); err != nil || revoked {
+		t.Fatalf("expected token not to be revoked, got %v %v", revoked, err)
+	}
+
+	if err := store.Revoke(ctx, "jti", now.Add(time.Minute)); err != nil {
+		t.Fatalf("could not revoke: %v", err)
+	}
+
+	// Revoking twice is not an error
+	if err := store.Revoke(ctx, "jti", now.Add(time.Minute)); err != nil {
+		t.Fatalf("could not revoke again: %v", err)
+	}
+
+	if revoked, err := store.IsRevoked(ctx, "jti", now); err != nil || !revoked {
+		t.Fatalf("expected token to be revoked, got %v %v", revoked, err)
+	}
+
+	if revoked, err := store.IsRevoked(ctx, "other", now); err != nil || revoked {
+		t.Fatalf("expected other token not to be revoked, got %v %v", revoked, err)
+	}
+
+	// Another store on the same database sees the revocation
+	if revoked, err := revocation.NewPostgres(db).IsRevoked(ctx, "jti", now); err != nil || !revoked {
+		t.Fatalf("expected revocation to be shared, got %v %v", revoked, err)
+	}
+
+	if revoked, err := store.IsRevoked(ctx, "jti", now.Add(2*time.Minute)); err != nil || revoked {
+		t.Fatalf("expected revocation to expire with the token, got %v %v", revoked, err)
+	}
+}
+
+func TestCacheCheck(t *testing.T) {
+	ctx := context.Background()
+	store := revocation.NewMemory()
+	cache := revocation.NewCache(store, time.Hour)
+
+	claims := auth.NewClaims("user", nil, time.Now(), time.Hour)
+	if claims.Id == "" {
+		t.Fatal("expected claims to have a token ID")
+	}
+
+	if err := cache.Check(ctx, claims); err != nil {
+		t.Fatalf("expected token to pass: %v", err)
+	}
+
+	if err := cache.Revoke(ctx, claims); err != nil {
+		t.Fatalf("could not revoke: %v", err)
+	}
+
+	if err := cache.Check(ctx, claims); err != revocation.ErrRevoked {
+		t.Fatalf("expected %v, got %v", revocation.ErrRevoked, err)
+	}
+
+	// Revocations made elsewhere are only seen once the cached answer expires
+	other := auth.NewClaims("user", nil, time.Now(), time.Hour)
+	if err := cache.Check(ctx, other); err != nil {
+		t.Fatalf("expected token to pass: %v", err)
+	}
+
+	if err := store.Revoke(ctx, other.Id, time.Now().Add(time.Hour)); err != nil {
+		t.Fatalf("could not revoke: %v", err)
+	}
+
+	if err := cache.Check(ctx, other); err != nil {
+		t.Fatalf("expected cached answer within ttl, got %v", err)
+	}
+
+	fresh := revocation.NewCache(store, time.Hour)
+	if err := fresh.Check(ctx, other); err != revocation.ErrRevoked {
+		t.Fatalf("expected %v, got %v", revocation.ErrRevoked, err)
+	}
+}
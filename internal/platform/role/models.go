@@ -0,0 +1,34 @@
+package role
+
+import (
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// Role is a named set of permissions. Built-in roles have no OrgID and are
+// shared by every organization, other roles belong to a single organization.
+type Role struct {
+	ID          string         `db:"role_id" json:"id"`
+	OrgID       *string        `db:"org_id" json:"org_id"`
+	Name        string         `db:"name" json:"name"`
+	Permissions pq.StringArray `db:"permissions" json:"permissions"`
+	DateCreated time.Time      `db:"date_created" json:"date_created"`
+	DateUpdated time.Time      `db:"date_updated" json:"date_updated"`
+}
+
+// NewRole contains information needed to create a new Role
+type NewRole struct {
+	Name        string   `json:"name" validate:"required"`
+	Permissions []string `json:"permissions" validate:"required"`
+}
+
+// UpdateRole defines the permissions that replace the ones of an existing Role
+type UpdateRole struct {
+	Permissions []string `json:"permissions" validate:"required"`
+}
+
+// AssignRoles defines the roles that replace the ones of an organization member
+type AssignRoles struct {
+	Roles []string `json:"roles" validate:"required"`
+}
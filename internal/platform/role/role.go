@@ -0,0 +1,255 @@
+package role
+
+import (
+	"context"
+	"database/sql"
+	"garagesale/internal/platform/auth"
+	"garagesale/internal/platform/organization"
+	"sort"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+	"github.com/pkg/errors"
+)
+
+// Predefined errors for known failure scenarios
+var (
+	ErrNotFound          = errors.New("role not found")
+	ErrBuiltIn           = errors.New("built-in roles cannot be changed")
+	ErrExists            = errors.New("role already exists")
+	ErrUnknownPermission = errors.New("unknown permission")
+	ErrPermissionDenied  = errors.New("role cannot have permissions the caller does not have")
+	ErrForbidden         = errors.New("attempted action is not allowed")
+	ErrNotOrgMember      = errors.New("user is not a member of the organization")
+)
+
+// orgScope returns the organization role changes made for the claims are
+// limited to
+func orgScope(claims auth.Claims) (string, error) {
+	if claims.OrgID == "" {
+		return "", ErrForbidden
+	}
+
+	return claims.OrgID, nil
+}
+
+// validatePermissions makes sure every permission is a known one the caller
+// holds, so managing roles cannot be used to grant more than the caller has
+func validatePermissions(claims auth.Claims, perms []string) error {
+	for _, p := range perms {
+		if !auth.IsPermission(p) {
+			return errors.Wrap(ErrUnknownPermission, p)
+		}
+
+		if !claims.HasPermission(p) {
+			return errors.Wrap(ErrPermissionDenied, p)
+		}
+	}
+
+	return nil
+}
+
+// List returns the built-in roles and the roles of the caller's organization
+func List(ctx context.Context, db *sqlx.DB, claims auth.Claims) ([]Role, error) {
+	orgID, err := orgScope(claims)
+	if err != nil {
+		return nil, err
+	}
+
+	list := []Role{}
+
+	const q = `SELECT * FROM roles WHERE org_id IS NULL OR org_id = $1 ORDER BY org_id NULLS FIRST, name`
+	if err := db.SelectContext(ctx, &list, q, orgID); err != nil {
+		return nil, errors.Wrap(err, "selecting roles")
+	}
+
+	return list, nil
+}
+
+// Retrieve returns a single Role visible to the caller's organization
+func Retrieve(ctx context.Context, db *sqlx.DB, claims auth.Claims, name string) (*Role, error) {
+	orgID, err := orgScope(claims)
+	if err != nil {
+		return nil, err
+	}
+
+	const q = `
+		SELECT * FROM roles
+		WHERE name = $1 AND (org_id IS NULL OR org_id = $2)
+		ORDER BY org_id NULLS LAST
+		LIMIT 1
+	`
+
+	var r Role
+	if err := db.GetContext(ctx, &r, q, name, orgID); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrNotFound
+		}
+
+		return nil, errors.Wrapf(err, "selecting role %q", name)
+	}
+
+	return &r, nil
+}
+
+// Create adds a Role to the caller's organization
+func Create(ctx context.Context, db *sqlx.DB, claims auth.Claims, nr NewRole, now time.Time) (*Role, error) {
+	orgID, err := orgScope(claims)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := validatePermissions(claims, nr.Permissions); err != nil {
+		return nil, err
+	}
+
+	if existing, err := Retrieve(ctx, db, claims, nr.Name); err == nil {
+		if existing.OrgID == nil {
+			return nil, ErrBuiltIn
+		}
+
+		return nil, ErrExists
+	} else if err != ErrNotFound {
+		return nil, err
+	}
+
+	r := Role{
+		ID:          uuid.New().String(),
+		OrgID:       &orgID,
+		Name:        nr.Name,
+		Permissions: nr.Permissions,
+		DateCreated: now.UTC(),
+		DateUpdated: now.UTC(),
+	}
+
+	const q = `
+		INSERT INTO roles
+		(role_id, org_id, name, permissions, date_created, date_updated)
+		VALUES ($1, $2, $3, $4, $5, $6)
+	`
+
+	_, err = db.ExecContext(ctx, q, r.ID, r.OrgID, r.Name, r.Permissions, r.DateCreated, r.DateUpdated)
+	if err != nil {
+		if pqErr, ok := err.(*pq.Error); ok && pqErr.Code == "23505" {
+			return nil, ErrExists
+		}
+
+		return nil, errors.Wrap(err, "inserting role")
+	}
+
+	return &r, nil
+}
+
+// Update replaces the permissions of a Role of the caller's organization.
+// Tokens that were already issued keep the permissions they were issued with.
+func Update(ctx context.Context, db *sqlx.DB, claims auth.Claims, name string, ur UpdateRole, now time.Time) (*Role, error) {
+	if err := validatePermissions(claims, ur.Permissions); err != nil {
+		return nil, err
+	}
+
+	r, err := Retrieve(ctx, db, claims, name)
+	if err != nil {
+		return nil, err
+	}
+
+	if r.OrgID == nil {
+		return nil, ErrBuiltIn
+	}
+
+	r.Permissions = ur.Permissions
+	r.DateUpdated = now.UTC()
+
+	const q = `
+		UPDATE roles SET
+		permissions = $2,
+		date_updated = $3
+		WHERE role_id = $1
+	`
+
+	if _, err := db.ExecContext(ctx, q, r.ID, r.Permissions, r.DateUpdated); err != nil {
+		return nil, errors.Wrap(err, "updating role")
+	}
+
+	return r, nil
+}
+
+// Delete removes a Role of the caller's organization
+func Delete(ctx context.Context, db *sqlx.DB, claims auth.Claims, name string) error {
+	r, err := Retrieve(ctx, db, claims, name)
+	if err != nil {
+		return err
+	}
+
+	if r.OrgID == nil {
+		return ErrBuiltIn
+	}
+
+	const q = `DELETE FROM roles WHERE role_id = $1`
+
+	if _, err := db.ExecContext(ctx, q, r.ID); err != nil {
+		return errors.Wrap(err, "deleting role")
+	}
+
+	return nil
+}
+
+// Assign replaces the roles of a member of the caller's organization. Every
+// role must exist and the caller must hold all the permissions they grant.
+func Assign(ctx context.Context, db *sqlx.DB, claims auth.Claims, userID string, ar AssignRoles, now time.Time) error {
+	orgID, err := orgScope(claims)
+	if err != nil {
+		return err
+	}
+
+	if _, err := uuid.Parse(userID); err != nil {
+		return ErrNotOrgMember
+	}
+
+	for _, name := range ar.Roles {
+		if _, err := Retrieve(ctx, db, claims, name); err != nil {
+			if err == ErrNotFound {
+				return errors.Wrap(err, name)
+			}
+
+			return err
+		}
+	}
+
+	perms, err := Permissions(ctx, db, orgID, ar.Roles)
+	if err != nil {
+		return err
+	}
+
+	if err := validatePermissions(claims, perms); err != nil {
+		return err
+	}
+
+	if _, err := organization.RetrieveMembership(ctx, db, orgID, userID); err != nil {
+		if err == organization.ErrMembershipNotFound {
+			return ErrNotOrgMember
+		}
+
+		return err
+	}
+
+	return organization.AddMember(ctx, db, orgID, userID, ar.Roles, now)
+}
+
+// Permissions resolves the union of permissions granted by the named roles
+// inside an organization. Unknown role names grant nothing.
+func Permissions(ctx context.Context, db *sqlx.DB, orgID string, roles []string) ([]string, error) {
+	const q = `
+		SELECT DISTINCT UNNEST(permissions) FROM roles
+		WHERE name = ANY($1) AND (org_id IS NULL OR org_id = $2)
+	`
+
+	perms := []string{}
+	if err := db.SelectContext(ctx, &perms, q, pq.StringArray(roles), orgID); err != nil {
+		return nil, errors.Wrap(err, "resolving permissions")
+	}
+
+	sort.Strings(perms)
+	return perms, nil
+}
@@ -0,0 +1,118 @@
+package role_test
+
+import (
+	"context"
+	"garagesale/internal/platform/auth"
+	"garagesale/internal/platform/database/databasetest"
+	"garagesale/internal/platform/organization"
+	"garagesale/internal/platform/role"
+	"garagesale/internal/platform/user"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+func TestRolePermissionsLimitedToCaller(t *testing.T) {
+	db, teardown := databasetest.Setup(t)
+	t.Cleanup(teardown)
+	ctx := context.Background()
+	now := time.Now()
+
+	claims := auth.Claims{
+		OrgID:       organization.DefaultID,
+		Permissions: []string{auth.PermRoleManage, auth.PermProductRead},
+	}
+
+	_, err := role.Create(ctx, db, claims, role.NewRole{
+		Name:        "too wide",
+		Permissions: []string{auth.PermProductRead, auth.PermProductDelete},
+	}, now)
+	if errors.Cause(err) != role.ErrPermissionDenied {
+		t.Fatalf("expected %v creating a role wider than the caller, got %v", role.ErrPermissionDenied, err)
+	}
+
+	if _, err := role.Create(ctx, db, claims, role.NewRole{
+		Name:        "reader",
+		Permissions: []string{auth.PermProductRead},
+	}, now); err != nil {
+		t.Fatalf("could not create role: %v", err)
+	}
+
+	_, err = role.Update(ctx, db, claims, "reader", role.UpdateRole{
+		Permissions: []string{auth.PermProductDelete},
+	}, now)
+	if errors.Cause(err) != role.ErrPermissionDenied {
+		t.Fatalf("expected %v widening a role past the caller, got %v", role.ErrPermissionDenied, err)
+	}
+
+	r, err := role.Retrieve(ctx, db, claims, "reader")
+	if err != nil {
+		t.Fatalf("could not retrieve role: %v", err)
+	}
+	if len(r.Permissions) != 1 || r.Permissions[0] != auth.PermProductRead {
+		t.Fatalf("role should keep its permissions after a denied update, got %v", r.Permissions)
+	}
+}
+
+func TestAssign(t *testing.T) {
+	db, teardown := databasetest.Setup(t)
+	t.Cleanup(teardown)
+	ctx := context.Background()
+	now := time.Now()
+
+	nu := user.NewUser{
+		Name:            "member",
+		Email:           "member@example.com",
+		Roles:           []string{auth.RoleUser},
+		Password:        "secret",
+		PasswordConfirm: "secret",
+	}
+	u, err := user.Create(ctx, db, auth.DefaultPasswordPolicy, nu, now)
+	if err != nil {
+		t.Fatalf("could not create user: %v", err)
+	}
+
+	claims := auth.Claims{
+		OrgID:       organization.DefaultID,
+		Permissions: []string{auth.PermRoleManage, auth.PermUserManage, auth.PermProductRead},
+	}
+
+	if _, err := role.Create(ctx, db, claims, role.NewRole{
+		Name:        "reader",
+		Permissions: []string{auth.PermProductRead},
+	}, now); err != nil {
+		t.Fatalf("could not create role: %v", err)
+	}
+
+	tests := []struct {
+		name   string
+		userID string
+		roles  []string
+		want   error
+	}{
+		{"unknown role", u.ID, []string{"missing"}, role.ErrNotFound},
+		{"wider than caller", u.ID, []string{auth.RoleAdmin}, role.ErrPermissionDenied},
+		{"not a member", "5cf37266-3473-4006-984f-9325122678b7", []string{"reader"}, role.ErrNotOrgMember},
+		{"invalid user", "abc", []string{"reader"}, role.ErrNotOrgMember},
+	}
+
+	for _, tt := range tests {
+		ar := role.AssignRoles{Roles: tt.roles}
+		if err := role.Assign(ctx, db, claims, tt.userID, ar, now); errors.Cause(err) != tt.want {
+			t.Errorf("%s: expected %v, got %v", tt.name, tt.want, err)
+		}
+	}
+
+	if err := role.Assign(ctx, db, claims, u.ID, role.AssignRoles{Roles: []string{"reader"}}, now); err != nil {
+		t.Fatalf("could not assign role: %v", err)
+	}
+
+	m, err := organization.RetrieveMembership(ctx, db, organization.DefaultID, u.ID)
+	if err != nil {
+		t.Fatalf("could not retrieve membership: %v", err)
+	}
+	if len(m.Roles) != 1 || m.Roles[0] != "reader" {
+		t.Fatalf("expected member to have role reader, got %v", m.Roles)
+	}
+}
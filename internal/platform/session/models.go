@@ -0,0 +1,44 @@
+package session
+
+import (
+	"time"
+)
+
+// Config describes the cookies browser sessions are carried in and how long
+// they last. Sessions expire after TTL without use and after Lifetime at most.
+type Config struct {
+	CookieName     string        `default:"gs_session" split_words:"true"`
+	CSRFCookieName string        `default:"gs_csrf" envconfig:"CSRF_COOKIE_NAME"`
+	CSRFHeader     string        `default:"X-CSRF-Token" envconfig:"CSRF_HEADER"`
+	SameSite       string        `default:"strict" split_words:"true"`
+	TTL            time.Duration `default:"30m"`
+	Lifetime       time.Duration `default:"12h"`
+
+	// InsecureCookies drops the Secure attribute so sessions work over plain
+	// HTTP during local development
+	InsecureCookies bool `split_words:"true"`
+}
+
+// DefaultConfig matches the defaults of the Config tags
+var DefaultConfig = Config{
+	CookieName:     "gs_session",
+	CSRFCookieName: "gs_csrf",
+	CSRFHeader:     "X-CSRF-Token",
+	SameSite:       "strict",
+	TTL:            30 * time.Minute,
+	Lifetime:       12 * time.Hour,
+}
+
+// Session is a server-side browser session. It holds who logged in and the
+// token version of the user at the time; the claims are built from the
+// current state of the user on every request. Only a hash of the session
+// token is stored.
+type Session struct {
+	ID           string    `db:"session_id"`
+	TokenHash    string    `db:"token_hash"`
+	UserID       string    `db:"user_id"`
+	OrgID        string    `db:"org_id"`
+	TokenVersion int       `db:"token_version"`
+	ExpiresAt    time.Time `db:"expires_at"`
+	DateCreated  time.Time `db:"date_created"`
+}
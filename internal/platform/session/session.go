@@ -0,0 +1,209 @@
+// Package session stores browser sessions server-side. The browser only
+// holds an opaque token in a cookie; the session in the database only knows
+// the user it belongs to.
+package session
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"garagesale/internal/platform/auth"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+)
+
+// Predefined errors for known failure scenarios
+var (
+	ErrInvalidSession = errors.New("session is invalid or expired")
+	ErrInvalidConfig  = errors.New("session config is invalid")
+)
+
+// slideResolution is how much a session has to be extended before its expiry
+// is written again. It keeps busy sessions from updating on every request.
+const slideResolution = time.Minute
+
+// Validate returns an error when sessions cannot be issued with the config
+func (c Config) Validate() error {
+	if c.CookieName == "" || c.CSRFCookieName == "" || c.CSRFHeader == "" {
+		return errors.Wrap(ErrInvalidConfig, "cookie and header names cannot be blank")
+	}
+
+	if c.TTL <= 0 || c.Lifetime < c.TTL {
+		return errors.Wrap(ErrInvalidConfig, "TTL must be positive and not exceed lifetime")
+	}
+
+	if _, err := c.sameSite(); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// sameSite maps the configured SameSite attribute to its cookie value
+func (c Config) sameSite() (http.SameSite, error) {
+	switch strings.ToLower(c.SameSite) {
+	case "strict":
+		return http.SameSiteStrictMode, nil
+	case "lax":
+		return http.SameSiteLaxMode, nil
+	case "none":
+		return http.SameSiteNoneMode, nil
+	default:
+		return 0, errors.Wrapf(ErrInvalidConfig, "unknown SameSite %q", c.SameSite)
+	}
+}
+
+// Create stores a new session for the user and organization of the claims
+// and returns the token to hand to the browser. Expired sessions of the user
+// are deleted on the way.
+func Create(ctx context.Context, db *sqlx.DB, cfg Config, claims auth.Claims, now time.Time) (string, *Session, error) {
+	token, err := newToken()
+	if err != nil {
+		return "", nil, err
+	}
+
+	s := Session{
+		ID:           uuid.New().String(),
+		TokenHash:    hashToken(token),
+		UserID:       claims.Subject,
+		OrgID:        claims.OrgID,
+		TokenVersion: claims.TokenVersion,
+		ExpiresAt:    now.Add(cfg.TTL).UTC(),
+		DateCreated:  now.UTC(),
+	}
+
+	const qd = `DELETE FROM sessions WHERE user_id = $1 AND expires_at <= $2`
+	if _, err := db.ExecContext(ctx, qd, s.UserID, s.DateCreated); err != nil {
+		return "", nil, errors.Wrap(err, "deleting expired sessions")
+	}
+
+	const q = `
+		INSERT INTO sessions
+		(session_id, token_hash, user_id, org_id, token_version, expires_at, date_created)
+		VALUES
+		($1, $2, $3, $4, $5, $6, $7)
+	`
+
+	if _, err := db.ExecContext(ctx, q, s.ID, s.TokenHash, s.UserID, s.OrgID, s.TokenVersion, s.ExpiresAt, s.DateCreated); err != nil {
+		return "", nil, errors.Wrap(err, "inserting session")
+	}
+
+	return token, &s, nil
+}
+
+// Authenticate returns the session behind token and slides its expiry
+// forward by TTL, but never past its Lifetime. The claims of the session have
+// to be built from the current state of its user.
+func Authenticate(ctx context.Context, db *sqlx.DB, cfg Config, token string, now time.Time) (*Session, error) {
+	const q = `SELECT * FROM sessions WHERE token_hash = $1 AND expires_at > $2`
+
+	var s Session
+	if err := db.GetContext(ctx, &s, q, hashToken(token), now.UTC()); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrInvalidSession
+		}
+
+		return nil, errors.Wrap(err, "selecting session")
+	}
+
+	expires := now.Add(cfg.TTL)
+	if limit := s.DateCreated.Add(cfg.Lifetime); expires.After(limit) {
+		expires = limit
+	}
+
+	if expires.Sub(s.ExpiresAt) >= slideResolution {
+		const qu = `UPDATE sessions SET expires_at = $2 WHERE session_id = $1`
+		if _, err := db.ExecContext(ctx, qu, s.ID, expires.UTC()); err != nil {
+			return nil, errors.Wrap(err, "extending session")
+		}
+		s.ExpiresAt = expires
+	}
+
+	return &s, nil
+}
+
+// Delete ends the session behind token. Unknown tokens are ignored so
+// logging out twice is not an error.
+func Delete(ctx context.Context, db *sqlx.DB, token string) error {
+	const q = `DELETE FROM sessions WHERE token_hash = $1`
+
+	if _, err := db.ExecContext(ctx, q, hashToken(token)); err != nil {
+		return errors.Wrap(err, "deleting session")
+	}
+
+	return nil
+}
+
+// SetCookies writes the session cookie and the CSRF cookie the browser has to
+// echo in the CSRF header. The session cookie cannot be read by scripts.
+func SetCookies(w http.ResponseWriter, cfg Config, token string, s *Session) error {
+	sameSite, err := cfg.sameSite()
+	if err != nil {
+		return err
+	}
+
+	csrf, err := newToken()
+	if err != nil {
+		return err
+	}
+
+	expires := s.DateCreated.Add(cfg.Lifetime)
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     cfg.CookieName,
+		Value:    token,
+		Path:     "/",
+		Expires:  expires,
+		HttpOnly: true,
+		Secure:   !cfg.InsecureCookies,
+		SameSite: sameSite,
+	})
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     cfg.CSRFCookieName,
+		Value:    csrf,
+		Path:     "/",
+		Expires:  expires,
+		Secure:   !cfg.InsecureCookies,
+		SameSite: sameSite,
+	})
+
+	return nil
+}
+
+// ClearCookies tells the browser to drop the session and CSRF cookies
+func ClearCookies(w http.ResponseWriter, cfg Config) {
+	for _, name := range []string{cfg.CookieName, cfg.CSRFCookieName} {
+		http.SetCookie(w, &http.Cookie{
+			Name:     name,
+			Path:     "/",
+			MaxAge:   -1,
+			HttpOnly: name == cfg.CookieName,
+			Secure:   !cfg.InsecureCookies,
+		})
+	}
+}
+
+// newToken returns a random token to hand out
+func newToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", errors.Wrap(err, "generating session token")
+	}
+
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// hashToken returns hex encoded sha256 of a token
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,85 @@
+package session_test
+
+import (
+	"context"
+	"garagesale/internal/platform/auth"
+	"garagesale/internal/platform/database/databasetest"
+	"garagesale/internal/platform/session"
+	"garagesale/internal/platform/user"
+	"testing"
+	"time"
+)
+
+func TestSession(t *testing.T) {
+	db, teardown := databasetest.Setup(t)
+	t.Cleanup(teardown)
+	ctx := context.Background()
+
+	nu := user.NewUser{
+		Name:            "test",
+		Email:           "session@example.com",
+		Roles:           []string{auth.RoleUser},
+		Password:        "secret",
+		PasswordConfirm: "secret",
+	}
+
+	if _, err := user.Create(ctx, db, auth.DefaultPasswordPolicy, nu, time.Now()); err != nil {
+		t.Fatalf("could not create user: %v", err)
+	}
+
+	claims, err := user.Authenticate(ctx, db, auth.DefaultPasswordPolicy, time.Now(), nu.Email, nu.Password, "")
+	if err != nil {
+		t.Fatalf("could not authenticate: %v", err)
+	}
+
+	cfg := session.DefaultConfig
+	cfg.TTL = 10 * time.Minute
+	cfg.Lifetime = time.Hour
+
+	now := time.Now()
+	token, _, err := session.Create(ctx, db, cfg, claims, now)
+	if err != nil {
+		t.Fatalf("could not create session: %v", err)
+	}
+
+	// Using the session slides its expiry forward
+	later := now.Add(8 * time.Minute)
+	got, err := session.Authenticate(ctx, db, cfg, token, later)
+	if err != nil {
+		t.Fatalf("could not authenticate session: %v", err)
+	}
+	if got.UserID != claims.Subject || got.OrgID != claims.OrgID {
+		t.Fatalf("expected session of %q, got %+v", claims.Subject, got)
+	}
+	if want := later.Add(cfg.TTL).Unix(); got.ExpiresAt.Unix() != want {
+		t.Fatalf("expected expiry %d, got %d", want, got.ExpiresAt.Unix())
+	}
+
+	// The claims are built from the current state of the user
+	sc, err := user.SessionClaims(ctx, db, got, false, later)
+	if err != nil {
+		t.Fatalf("could not build session claims: %v", err)
+	}
+	if sc.Subject != claims.Subject || !sc.HasRoles(auth.RoleUser) || sc.ExpiresAt != got.ExpiresAt.Unix() {
+		t.Fatalf("expected claims of %q, got %+v", claims.Subject, sc)
+	}
+
+	if err := user.RevokeTokens(ctx, db, "", claims.Subject, later); err != nil {
+		t.Fatalf("could not revoke tokens: %v", err)
+	}
+	if _, err := user.SessionClaims(ctx, db, got, false, later); err != user.ErrTokenRevoked {
+		t.Fatalf("expected %v after revoking tokens, got %v", user.ErrTokenRevoked, err)
+	}
+
+	if _, err := session.Authenticate(ctx, db, cfg, token, later.Add(cfg.TTL+time.Second)); err != session.ErrInvalidSession {
+		t.Fatalf("expected %v after idle timeout, got %v", session.ErrInvalidSession, err)
+	}
+
+	if err := session.Delete(ctx, db, token); err != nil {
+		t.Fatalf("could not delete session: %v", err)
+	}
+
+	if _, err := session.Authenticate(ctx, db, cfg, token, later); err != session.ErrInvalidSession {
+		t.Fatalf("expected %v after logout, got %v", session.ErrInvalidSession, err)
+	}
+}
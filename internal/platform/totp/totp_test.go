@@ -0,0 +1,74 @@
+package totp_test
+
+import (
+	"encoding/base32"
+	"garagesale/internal/platform/totp"
+	"strings"
+	"testing"
+	"time"
+)
+
+// rfcSecret is the SHA1 seed used by the test vectors of RFC 6238 appendix B
+var rfcSecret = base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString([]byte("12345678901234567890"))
+
+func TestCode(t *testing.T) {
+	// RFC 6238 lists eight digit codes, the six digit codes are their suffix
+	tests := []struct {
+		unix int64
+		want string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+		{20000000000, "353130"},
+	}
+
+	for _, tt := range tests {
+		got, err := totp.Code(rfcSecret, totp.Step(time.Unix(tt.unix, 0)))
+		if err != nil {
+			t.Fatalf("could not generate code: %v", err)
+		}
+
+		if got != tt.want {
+			t.Fatalf("code at %d: want %s, got %s", tt.unix, tt.want, got)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	now := time.Unix(1111111111, 0)
+
+	code, err := totp.Code(rfcSecret, totp.Step(now.Add(-totp.Period)))
+	if err != nil {
+		t.Fatalf("could not generate code: %v", err)
+	}
+
+	step, ok := totp.Validate(rfcSecret, code, now, 1)
+	if !ok {
+		t.Fatal("expected code of previous step to be valid with skew 1")
+	}
+	if step != totp.Step(now)-1 {
+		t.Fatalf("expected matched step %d, got %d", totp.Step(now)-1, step)
+	}
+
+	if _, ok := totp.Validate(rfcSecret, code, now, 0); ok {
+		t.Fatal("expected code of previous step to be invalid without skew")
+	}
+
+	if _, ok := totp.Validate(rfcSecret, "12345", now, 1); ok {
+		t.Fatal("expected short code to be invalid")
+	}
+}
+
+func TestProvisioningURI(t *testing.T) {
+	uri := totp.ProvisioningURI("garagesale", "user@example.com", rfcSecret)
+
+	if !strings.HasPrefix(uri, "otpauth://totp/garagesale:user@example.com?") {
+		t.Fatalf("unexpected uri: %s", uri)
+	}
+	if !strings.Contains(uri, "secret="+rfcSecret) {
+		t.Fatalf("secret missing from uri: %s", uri)
+	}
+}
@@ -0,0 +1,84 @@
+// Package trace carries the ID of a request through context so logs, error
+// responses, outbound calls and database queries made for it can be matched.
+package trace
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/google/uuid"
+)
+
+// Header is the HTTP header request IDs are read from and sent in
+const Header = "X-Request-ID"
+
+// maxLength is the longest request ID accepted from clients
+const maxLength = 128
+
+// ctxKey represents the type of value for the context key
+type ctxKey int
+
+// key is how the request ID is stored in a context
+const key ctxKey = 1
+
+// NewID returns a random request ID
+func NewID() string {
+	return uuid.New().String()
+}
+
+// Valid reports whether id can be used as a request ID. Only letters, digits
+// and -_.: are accepted so an ID taken from a client is safe to put into
+// logs, headers and SQL comments.
+func Valid(id string) bool {
+	if id == "" || len(id) > maxLength {
+		return false
+	}
+
+	for _, c := range id {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+		case c == '-', c == '_', c == '.', c == ':':
+		default:
+			return false
+		}
+	}
+
+	return true
+}
+
+// NewContext returns a copy of ctx carrying the request ID
+func NewContext(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, key, id)
+}
+
+// FromContext returns the request ID of ctx, or a blank string when it has none
+func FromContext(ctx context.Context) string {
+	id, _ := ctx.Value(key).(string)
+	return id
+}
+
+// Transport sends the request ID of the context of outbound requests in the
+// Header so other services can log it too
+type Transport struct {
+	// Base makes the actual requests, http.DefaultTransport when nil
+	Base http.RoundTripper
+}
+
+// RoundTrip implements http.RoundTripper
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	base := t.Base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+
+	id := FromContext(req.Context())
+	if id == "" || req.Header.Get(Header) != "" {
+		return base.RoundTrip(req)
+	}
+
+	// A RoundTripper must not modify the request it was given
+	req = req.Clone(req.Context())
+	req.Header.Set(Header, id)
+
+	return base.RoundTrip(req)
+}
@@ -0,0 +1,73 @@
+package trace_test
+
+import (
+	"context"
+	"garagesale/internal/platform/trace"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestTransport(t *testing.T) {
+	var got []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = append(got, r.Header.Get(trace.Header))
+	}))
+	defer srv.Close()
+
+	client := http.Client{Transport: &trace.Transport{}}
+
+	tests := []struct {
+		ctx    context.Context
+		header string
+		want   string
+	}{
+		{trace.NewContext(context.Background(), "req-1"), "", "req-1"},
+		{trace.NewContext(context.Background(), "req-1"), "own", "own"},
+		{context.Background(), "", ""},
+	}
+
+	for i, tt := range tests {
+		req, err := http.NewRequestWithContext(tt.ctx, http.MethodGet, srv.URL, nil)
+		if err != nil {
+			t.Fatalf("creating request: %v", err)
+		}
+		if tt.header != "" {
+			req.Header.Set(trace.Header, tt.header)
+		}
+
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatalf("sending request: %v", err)
+		}
+		resp.Body.Close()
+
+		if got[i] != tt.want {
+			t.Errorf("request %d: expected header %q, got %q", i, tt.want, got[i])
+		}
+
+		if tt.header == "" && req.Header.Get(trace.Header) != "" {
+			t.Errorf("request %d: the request of the caller was modified", i)
+		}
+	}
+}
+
+func TestValid(t *testing.T) {
+	tests := []struct {
+		id   string
+		want bool
+	}{
+		{trace.NewID(), true},
+		{"svc-a:42_b.c", true},
+		{"", false},
+		{"a b", false},
+		{"*/ SELECT 1 /*", false},
+		{"id\nforged log line", false},
+	}
+
+	for _, tt := range tests {
+		if got := trace.Valid(tt.id); got != tt.want {
+			t.Errorf("%q: expected %v, got %v", tt.id, tt.want, got)
+		}
+	}
+}
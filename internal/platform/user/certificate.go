@@ -0,0 +1,106 @@
+package user
+
+import (
+	"context"
+	"crypto/x509"
+	"database/sql"
+	"garagesale/internal/platform/auth"
+	"garagesale/internal/platform/organization"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+)
+
+// Predefined errors for client certificates
+var (
+	ErrCertificateNotRegistered = errors.New("client certificate is not registered")
+	ErrCertificateTaken         = errors.New("certificate subject is already registered")
+)
+
+// RegisterCertificate lets clients presenting a certificate for subject act
+// as the user identified by id inside orgID. Users without a password work
+// as service accounts for machines such as scanners.
+func RegisterCertificate(ctx context.Context, db *sqlx.DB, subject, id, orgID string, now time.Time) error {
+	if orgID == "" {
+		orgID = organization.DefaultID
+	}
+
+	if _, err := uuid.Parse(id); err != nil {
+		return ErrInvalidID
+	}
+
+	if _, err := membership(ctx, db, id, orgID); err != nil {
+		return err
+	}
+
+	const q = `
+		INSERT INTO client_certificates
+		(subject, user_id, org_id, date_created)
+		VALUES
+		($1, $2, $3, $4)
+	`
+
+	if _, err := db.ExecContext(ctx, q, subject, id, orgID, now.UTC()); err != nil {
+		if isUniqueViolation(err) {
+			return ErrCertificateTaken
+		}
+
+		return errors.Wrap(err, "inserting client certificate")
+	}
+
+	return nil
+}
+
+// UnregisterCertificate stops accepting certificates for subject
+func UnregisterCertificate(ctx context.Context, db *sqlx.DB, subject string) error {
+	const q = `DELETE FROM client_certificates WHERE subject = $1`
+
+	res, err := db.ExecContext(ctx, q, subject)
+	if err != nil {
+		return errors.Wrap(err, "deleting client certificate")
+	}
+
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return ErrCertificateNotRegistered
+	}
+
+	return nil
+}
+
+// AuthenticateCertificate returns the claims of the user the subject of a
+// verified client certificate is registered to. The claims expire together
+// with the certificate. When requireAdminTwoFactor is set admins without
+// two-factor authentication are given the claims without the admin role, as
+// when they log in with a password. Everything is read in a single query
+// since it runs on every request made with the certificate.
+func AuthenticateCertificate(ctx context.Context, db *sqlx.DB, cert *x509.Certificate, requireAdminTwoFactor bool, now time.Time) (auth.Claims, error) {
+	const q = `
+		SELECT c.org_id, ` + memberColumns + `
+		FROM client_certificates AS c
+		JOIN users AS u ON u.user_id = c.user_id
+		LEFT JOIN memberships AS m ON m.user_id = c.user_id AND m.org_id = c.org_id
+		WHERE c.subject = $1
+	`
+
+	var reg struct {
+		OrgID string `db:"org_id"`
+		member
+	}
+	if err := db.GetContext(ctx, &reg, q, auth.CertificateSubject(cert), requireAdminTwoFactor, auth.RoleAdmin); err != nil {
+		if err == sql.ErrNoRows {
+			return auth.Claims{}, ErrCertificateNotRegistered
+		}
+
+		return auth.Claims{}, errors.Wrap(err, "selecting client certificate")
+	}
+
+	claims, err := reg.claims(reg.OrgID, requireAdminTwoFactor, now)
+	if err != nil {
+		return auth.Claims{}, err
+	}
+
+	claims.ExpiresAt = cert.NotAfter.Unix()
+	return claims, nil
+}
@@ -0,0 +1,198 @@
+package user
+
+import (
+	"context"
+	"database/sql"
+	"garagesale/internal/platform/auth"
+	"garagesale/internal/platform/organization"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+	"github.com/pkg/errors"
+)
+
+// externalToken is a token of an external provider seen before. It pins the
+// token version of the user and the ID of the token the first time it was
+// seen, so tokens of the provider are revoked like our own.
+type externalToken struct {
+	JTI          string `db:"jti"`
+	UserID       string `db:"user_id"`
+	OrgID        string `db:"org_id"`
+	TokenVersion int    `db:"token_version"`
+}
+
+// AuthenticateExternal returns the claims of the user behind an identity
+// verified by an external provider. Users are created on their first login
+// and their roles in orgID follow the roles mapped from the provider on every
+// login. The claims expire together with the token of the provider.
+//
+// Each token of the provider gets an ID of our own and the token version of
+// the user when it is first seen. Later requests with the same token only
+// read them, so revoking the ID or bumping the token version revokes the
+// token as for tokens we issue.
+//
+// Users created this way have no password and can only log in through the
+// provider. An existing local user with the same email is not taken over.
+func AuthenticateExternal(ctx context.Context, db *sqlx.DB, id auth.ExternalIdentity, orgID string, now time.Time) (auth.Claims, error) {
+	if orgID == "" {
+		orgID = organization.DefaultID
+	}
+
+	const q = `
+		SELECT jti, user_id, org_id, token_version FROM external_tokens
+		WHERE token_hash = $1 AND expires_at > $2
+	`
+
+	var t externalToken
+	err := db.GetContext(ctx, &t, q, id.TokenHash, now.UTC())
+	switch {
+	case err == sql.ErrNoRows:
+		t, err = login(ctx, db, id, orgID, now)
+		if err != nil {
+			return auth.Claims{}, err
+		}
+	case err != nil:
+		return auth.Claims{}, errors.Wrap(err, "selecting external token")
+	}
+
+	u, err := Retrieve(ctx, db, t.UserID)
+	if err != nil {
+		return auth.Claims{}, err
+	}
+
+	if u.Status != StatusActive {
+		return auth.Claims{}, ErrDisabled
+	}
+
+	m, err := membership(ctx, db, u.ID, t.OrgID)
+	if err != nil {
+		return auth.Claims{}, err
+	}
+
+	claims, err := newClaims(ctx, db, u, m, now)
+	if err != nil {
+		return auth.Claims{}, err
+	}
+
+	claims.Id = t.JTI
+	claims.TokenVersion = t.TokenVersion
+	claims.ExpiresAt = id.ExpiresAt
+	return claims, nil
+}
+
+// login records a token of the provider seen for the first time. The user of
+// the identity is provisioned when missing and their roles in orgID are
+// updated when the provider maps them differently now.
+func login(ctx context.Context, db *sqlx.DB, id auth.ExternalIdentity, orgID string, now time.Time) (externalToken, error) {
+	tx, err := db.BeginTxx(ctx, nil)
+	if err != nil {
+		return externalToken{}, errors.Wrap(err, "starting transaction")
+	}
+	defer tx.Rollback()
+
+	const q = `SELECT user_id FROM external_identities WHERE issuer = $1 AND subject = $2`
+
+	t := externalToken{JTI: uuid.New().String(), OrgID: orgID}
+	err = tx.GetContext(ctx, &t.UserID, q, id.Issuer, id.Subject)
+	switch {
+	case err == sql.ErrNoRows:
+		t.UserID, err = provision(ctx, tx, id, now)
+		if err != nil {
+			return externalToken{}, err
+		}
+	case err != nil:
+		return externalToken{}, errors.Wrap(err, "selecting external identity")
+	}
+
+	// Only touch the membership when the mapped roles changed
+	const qm = `
+		INSERT INTO memberships
+		(org_id, user_id, roles, date_created)
+		VALUES
+		($1, $2, $3, $4)
+		ON CONFLICT (org_id, user_id) DO UPDATE SET roles = EXCLUDED.roles
+		WHERE memberships.roles IS DISTINCT FROM EXCLUDED.roles
+	`
+
+	if _, err := tx.ExecContext(ctx, qm, orgID, t.UserID, pq.StringArray(id.Roles), now.UTC()); err != nil {
+		return externalToken{}, errors.Wrap(err, "updating membership")
+	}
+
+	const qv = `SELECT token_version FROM users WHERE user_id = $1`
+	if err := tx.GetContext(ctx, &t.TokenVersion, qv, t.UserID); err != nil {
+		return externalToken{}, errors.Wrap(err, "selecting token version")
+	}
+
+	const qd = `DELETE FROM external_tokens WHERE user_id = $1 AND expires_at <= $2`
+	if _, err := tx.ExecContext(ctx, qd, t.UserID, now.UTC()); err != nil {
+		return externalToken{}, errors.Wrap(err, "deleting expired external tokens")
+	}
+
+	// Concurrent first requests with the same token agree on the first ID
+	const qt = `
+		INSERT INTO external_tokens
+		(token_hash, jti, user_id, org_id, token_version, expires_at, date_created)
+		VALUES
+		($1, $2, $3, $4, $5, $6, $7)
+		ON CONFLICT (token_hash) DO UPDATE SET token_hash = EXCLUDED.token_hash
+		RETURNING jti, token_version
+	`
+
+	expires := time.Unix(id.ExpiresAt, 0).UTC()
+	row := tx.QueryRowxContext(ctx, qt, id.TokenHash, t.JTI, t.UserID, t.OrgID, t.TokenVersion, expires, now.UTC())
+	if err := row.Scan(&t.JTI, &t.TokenVersion); err != nil {
+		return externalToken{}, errors.Wrap(err, "inserting external token")
+	}
+
+	if err := tx.Commit(); err != nil {
+		return externalToken{}, errors.Wrap(err, "commiting external token")
+	}
+
+	return t, nil
+}
+
+// provision creates a user without a password for an external identity
+func provision(ctx context.Context, tx *sqlx.Tx, id auth.ExternalIdentity, now time.Time) (string, error) {
+	const qe = `SELECT EXISTS (SELECT 1 FROM users WHERE email = $1)`
+
+	var taken bool
+	if err := tx.GetContext(ctx, &taken, qe, id.Email); err != nil {
+		return "", errors.Wrap(err, "checking email")
+	}
+	if taken {
+		return "", ErrEmailTaken
+	}
+
+	name := id.Name
+	if name == "" {
+		name = id.Email
+	}
+
+	userID := uuid.New().String()
+
+	const qu = `
+		INSERT INTO users
+		(user_id, name, email, password_hash, email_verified, date_created, date_updated)
+		VALUES
+		($1, $2, $3, '', $4, $5, $5)
+	`
+
+	if _, err := tx.ExecContext(ctx, qu, userID, name, id.Email, id.EmailVerified, now.UTC()); err != nil {
+		return "", errors.Wrap(err, "inserting user")
+	}
+
+	const qi = `
+		INSERT INTO external_identities
+		(issuer, subject, user_id, date_created)
+		VALUES
+		($1, $2, $3, $4)
+	`
+
+	if _, err := tx.ExecContext(ctx, qi, id.Issuer, id.Subject, userID, now.UTC()); err != nil {
+		return "", errors.Wrap(err, "inserting external identity")
+	}
+
+	return userID, nil
+}
@@ -0,0 +1,54 @@
+package user
+
+import (
+	"context"
+	"garagesale/internal/platform/auth"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+)
+
+// ErrCannotImpersonate is returned when the caller may not impersonate the user
+var ErrCannotImpersonate = errors.New("impersonation is not allowed for this session")
+
+// impersonationTTL is how long a token issued to impersonate a user lasts
+const impersonationTTL = 15 * time.Minute
+
+// Impersonate returns short-lived claims of the User identified by id inside
+// the organization of the actor, with the actor recorded in the claims. The
+// actor needs auth.PermUserImpersonate and the user must be active.
+// Impersonated sessions and API keys cannot impersonate, and nobody can
+// impersonate themselves.
+func Impersonate(ctx context.Context, db *sqlx.DB, actor auth.Claims, id string, now time.Time) (auth.Claims, error) {
+	if !actor.HasPermission(auth.PermUserImpersonate) || actor.Impersonated() || actor.APIKeyID != "" || actor.Subject == id {
+		return auth.Claims{}, ErrCannotImpersonate
+	}
+
+	if actor.OrgID == "" {
+		return auth.Claims{}, ErrNoMembership
+	}
+
+	u, err := Retrieve(ctx, db, id)
+	if err != nil {
+		return auth.Claims{}, err
+	}
+
+	if u.Status != StatusActive {
+		return auth.Claims{}, ErrDisabled
+	}
+
+	m, err := membership(ctx, db, u.ID, actor.OrgID)
+	if err != nil {
+		return auth.Claims{}, err
+	}
+
+	claims, err := newClaims(ctx, db, u, m, now)
+	if err != nil {
+		return auth.Claims{}, err
+	}
+
+	claims.ExpiresAt = now.Add(impersonationTTL).Unix()
+	claims.Actor = &auth.Actor{Subject: actor.Subject}
+	return claims, nil
+}
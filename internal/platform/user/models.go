@@ -3,25 +3,86 @@ package user
 import (
 	"time"
 
-	"github.com/lib/pq"
+	"github.com/jmoiron/sqlx/types"
+)
+
+// These are expected values for User.Status
+const (
+	StatusActive   = "active"
+	StatusDisabled = "disabled"
 )
 
 // User represents someone with access to the system
 type User struct {
-	ID           string         `db:"user_id" json:"id"`
-	Name         string         `db:"name" json:"name"`
-	Email        string         `db:"email" json:"email"`
-	Roles        pq.StringArray `db:"roles" json:"roles"`
-	PasswordHash []byte         `db:"password_hash" json:"-"`
-	DateCreated  time.Time      `db:"date_created" json:"date_created"`
-	DateUpdated  time.Time      `db:"date_updated" json:"date_updated"`
+	ID                    string         `db:"user_id" json:"id"`
+	Name                  string         `db:"name" json:"name"`
+	Email                 string         `db:"email" json:"email"`
+	PasswordHash          []byte         `db:"password_hash" json:"-"`
+	Preferences           types.JSONText `db:"preferences" json:"preferences"`
+	EmailVerified         bool           `db:"email_verified" json:"email_verified"`
+	PendingEmail          *string        `db:"pending_email" json:"pending_email,omitempty"`
+	EmailVerificationHash *string        `db:"email_verification_hash" json:"-"`
+	Status                string         `db:"status" json:"status"`
+	TokenVersion          int            `db:"token_version" json:"-"`
+	TOTPSecret            *string        `db:"totp_secret" json:"-"`
+	TOTPEnabled           bool           `db:"totp_enabled" json:"totp_enabled"`
+	TOTPLastStep          int64          `db:"totp_last_step" json:"-"`
+	DateCreated           time.Time      `db:"date_created" json:"date_created"`
+	DateUpdated           time.Time      `db:"date_updated" json:"date_updated"`
 }
 
-// NewUser contains information needed to create a new User
+// NewUser contains information needed to create a new User. The user becomes
+// a member of OrgID with Roles, or of the default organization if OrgID is blank.
 type NewUser struct {
 	Name            string   `json:"name" validate:"required"`
-	Email           string   `json:"email" validate:"required"`
+	Email           string   `json:"email" validate:"required,email"`
+	OrgID           string   `json:"org_id" validate:"omitempty,uuid"`
 	Roles           []string `json:"roles" validate:"required"`
 	Password        string   `json:"password" validate:"required"`
 	PasswordConfirm string   `json:"password_confirm" validate:"eqfield=Password"`
 }
+
+// UpdateUser defines what information a user can change about themselves.
+// All fields are optional so clients can send just the fields they want changed.
+// A new email is not applied until it has been verified.
+type UpdateUser struct {
+	Name        *string                `json:"name" validate:"omitempty,min=1"`
+	Email       *string                `json:"email" validate:"omitempty,email"`
+	Preferences map[string]interface{} `json:"preferences"`
+}
+
+// UpdatePassword is what we require from a user to replace their password
+type UpdatePassword struct {
+	Current         string `json:"current" validate:"required"`
+	Password        string `json:"password" validate:"required"`
+	PasswordConfirm string `json:"password_confirm" validate:"eqfield=Password"`
+}
+
+// VerifyEmail is what we require from the client to confirm a pending email change
+type VerifyEmail struct {
+	Token string `json:"token" validate:"required"`
+}
+
+// UpdateStatus is what we require from admins to activate or disable a user
+type UpdateStatus struct {
+	Status string `json:"status" validate:"required,oneof=active disabled"`
+}
+
+// TOTPEnrollment is returned once when a user starts two-factor enrollment.
+// URI is meant to be rendered as a QR code for authenticator apps.
+type TOTPEnrollment struct {
+	Secret        string   `json:"secret"`
+	URI           string   `json:"uri"`
+	RecoveryCodes []string `json:"recovery_codes"`
+}
+
+// TOTPCode is what we require from the client to prove they hold the second factor.
+// Code is either a current TOTP code or an unused recovery code.
+type TOTPCode struct {
+	Code string `json:"code" validate:"required"`
+}
+
+// RefreshRequest is what we require from the client to refresh a token or log out.
+type RefreshRequest struct {
+	RefreshToken string `json:"refresh_token" validate:"required"`
+}
@@ -0,0 +1,198 @@
+package user
+
+import (
+	"context"
+	"database/sql"
+	"garagesale/internal/platform/auth"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+)
+
+// Predefined errors for refresh tokens
+var (
+	ErrInvalidRefreshToken = errors.New("refresh token is invalid")
+	ErrRefreshTokenReused  = errors.New("refresh token was already used")
+)
+
+// refreshTTL is how long a refresh token can be exchanged. Every exchange
+// issues a new token with a new lifetime.
+const refreshTTL = 30 * 24 * time.Hour
+
+// refreshToken is a stored refresh token. Tokens issued by exchanging one
+// another share its family so a stolen token can revoke every descendant.
+type refreshToken struct {
+	ID           string     `db:"token_id"`
+	FamilyID     string     `db:"family_id"`
+	UserID       string     `db:"user_id"`
+	OrgID        string     `db:"org_id"`
+	TokenHash    string     `db:"token_hash"`
+	TokenVersion int        `db:"token_version"`
+	ExpiresAt    time.Time  `db:"expires_at"`
+	DateUsed     *time.Time `db:"date_used"`
+	DateRevoked  *time.Time `db:"date_revoked"`
+	DateCreated  time.Time  `db:"date_created"`
+}
+
+// IssueRefreshToken starts a new token family for the user and organization
+// of the claims and returns its first refresh token. Only the hash of the
+// token is stored.
+func IssueRefreshToken(ctx context.Context, db *sqlx.DB, claims auth.Claims, now time.Time) (string, error) {
+	tx, err := db.BeginTxx(ctx, nil)
+	if err != nil {
+		return "", errors.Wrap(err, "starting transaction")
+	}
+	defer tx.Rollback()
+
+	token, err := insertRefreshToken(ctx, tx, uuid.New().String(), claims.Subject, claims.OrgID, claims.TokenVersion, now)
+	if err != nil {
+		return "", err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return "", errors.Wrap(err, "commiting refresh token")
+	}
+
+	return token, nil
+}
+
+// Refresh exchanges a refresh token for fresh claims of its user and a new
+// refresh token of the same family. Each token can only be exchanged once:
+// presenting a used token again revokes the whole family and returns
+// ErrRefreshTokenReused.
+func Refresh(ctx context.Context, db *sqlx.DB, token string, now time.Time) (auth.Claims, string, error) {
+	tx, err := db.BeginTxx(ctx, nil)
+	if err != nil {
+		return auth.Claims{}, "", errors.Wrap(err, "starting transaction")
+	}
+	defer tx.Rollback()
+
+	const q = `SELECT * FROM refresh_tokens WHERE token_hash = $1 FOR UPDATE`
+
+	var rt refreshToken
+	if err := tx.GetContext(ctx, &rt, q, hashToken(token)); err != nil {
+		if err == sql.ErrNoRows {
+			return auth.Claims{}, "", ErrInvalidRefreshToken
+		}
+
+		return auth.Claims{}, "", errors.Wrap(err, "selecting refresh token")
+	}
+
+	if rt.DateRevoked != nil {
+		return auth.Claims{}, "", ErrInvalidRefreshToken
+	}
+
+	if rt.DateUsed != nil {
+		if err := revokeFamily(ctx, tx, rt.FamilyID, now); err != nil {
+			return auth.Claims{}, "", err
+		}
+
+		if err := tx.Commit(); err != nil {
+			return auth.Claims{}, "", errors.Wrap(err, "commiting revoked family")
+		}
+
+		return auth.Claims{}, "", ErrRefreshTokenReused
+	}
+
+	if !now.Before(rt.ExpiresAt) {
+		return auth.Claims{}, "", ErrInvalidRefreshToken
+	}
+
+	const qu = `UPDATE refresh_tokens SET date_used = $2 WHERE token_id = $1`
+	if _, err := tx.ExecContext(ctx, qu, rt.ID, now.UTC()); err != nil {
+		return auth.Claims{}, "", errors.Wrap(err, "marking refresh token used")
+	}
+
+	u, err := Retrieve(ctx, db, rt.UserID)
+	if err != nil {
+		return auth.Claims{}, "", err
+	}
+
+	if u.Status != StatusActive {
+		return auth.Claims{}, "", ErrDisabled
+	}
+
+	if u.TokenVersion != rt.TokenVersion {
+		return auth.Claims{}, "", ErrTokenRevoked
+	}
+
+	m, err := membership(ctx, db, u.ID, rt.OrgID)
+	if err != nil {
+		return auth.Claims{}, "", err
+	}
+
+	claims, err := newClaims(ctx, db, u, m, now)
+	if err != nil {
+		return auth.Claims{}, "", err
+	}
+
+	next, err := insertRefreshToken(ctx, tx, rt.FamilyID, u.ID, m.OrgID, u.TokenVersion, now)
+	if err != nil {
+		return auth.Claims{}, "", err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return auth.Claims{}, "", errors.Wrap(err, "commiting refresh token")
+	}
+
+	return claims, next, nil
+}
+
+// Logout revokes the family of a refresh token. Unknown tokens are ignored
+// so logging out twice is not an error.
+func Logout(ctx context.Context, db *sqlx.DB, token string, now time.Time) error {
+	const q = `
+		UPDATE refresh_tokens SET
+		date_revoked = $2
+		WHERE date_revoked IS NULL AND family_id = (
+			SELECT family_id FROM refresh_tokens WHERE token_hash = $1
+		)
+	`
+
+	if _, err := db.ExecContext(ctx, q, hashToken(token), now.UTC()); err != nil {
+		return errors.Wrap(err, "revoking refresh tokens")
+	}
+
+	return nil
+}
+
+// insertRefreshToken stores a new token of a family and returns it
+func insertRefreshToken(ctx context.Context, tx *sqlx.Tx, familyID, userID, orgID string, tokenVersion int, now time.Time) (string, error) {
+	token, hash, err := newVerificationToken()
+	if err != nil {
+		return "", err
+	}
+
+	const q = `
+		INSERT INTO refresh_tokens
+		(token_id, family_id, user_id, org_id, token_hash, token_version, expires_at, date_created)
+		VALUES
+		($1, $2, $3, $4, $5, $6, $7, $8)
+	`
+
+	_, err = tx.ExecContext(ctx, q,
+		uuid.New().String(), familyID, userID, orgID, hash, tokenVersion, now.Add(refreshTTL).UTC(), now.UTC(),
+	)
+	if err != nil {
+		return "", errors.Wrap(err, "inserting refresh token")
+	}
+
+	return token, nil
+}
+
+// revokeFamily revokes every token of a family
+func revokeFamily(ctx context.Context, tx *sqlx.Tx, familyID string, now time.Time) error {
+	const q = `
+		UPDATE refresh_tokens SET
+		date_revoked = $2
+		WHERE family_id = $1 AND date_revoked IS NULL
+	`
+
+	if _, err := tx.ExecContext(ctx, q, familyID, now.UTC()); err != nil {
+		return errors.Wrap(err, "revoking refresh token family")
+	}
+
+	return nil
+}
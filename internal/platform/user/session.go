@@ -0,0 +1,46 @@
+package user
+
+import (
+	"context"
+	"database/sql"
+	"garagesale/internal/platform/auth"
+	"garagesale/internal/platform/session"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+)
+
+// SessionClaims returns the claims of the user of a browser session, built
+// from their current state. Sessions started before the token version of the
+// user was bumped are revoked. The claims expire together with the session.
+// requireAdminTwoFactor restricts admins as for AuthenticateCertificate.
+func SessionClaims(ctx context.Context, db *sqlx.DB, s *session.Session, requireAdminTwoFactor bool, now time.Time) (auth.Claims, error) {
+	const q = `
+		SELECT ` + memberColumns + `
+		FROM users AS u
+		LEFT JOIN memberships AS m ON m.user_id = u.user_id AND m.org_id = $4
+		WHERE u.user_id = $1
+	`
+
+	var m member
+	if err := db.GetContext(ctx, &m, q, s.UserID, requireAdminTwoFactor, auth.RoleAdmin, s.OrgID); err != nil {
+		if err == sql.ErrNoRows {
+			return auth.Claims{}, ErrNotFound
+		}
+
+		return auth.Claims{}, errors.Wrapf(err, "selecting user %q", s.UserID)
+	}
+
+	if m.TokenVersion != s.TokenVersion {
+		return auth.Claims{}, ErrTokenRevoked
+	}
+
+	claims, err := m.claims(s.OrgID, requireAdminTwoFactor, now)
+	if err != nil {
+		return auth.Claims{}, err
+	}
+
+	claims.ExpiresAt = s.ExpiresAt.Unix()
+	return claims, nil
+}
@@ -0,0 +1,131 @@
+package user
+
+import (
+	"context"
+	"database/sql"
+	"garagesale/internal/platform/auth"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+)
+
+// statusEntry is a cached copy of the user state a token is checked against
+type statusEntry struct {
+	status       string
+	tokenVersion int
+	fetched      time.Time
+}
+
+// StatusCache answers whether the claims of a token still belong to an active
+// user with the same token version. Lookups are cached for ttl to keep them
+// off the database for every request. Changes made through the cache are
+// seen right away, changes made by other processes within ttl.
+type StatusCache struct {
+	db  *sqlx.DB
+	ttl time.Duration
+
+	mu      sync.Mutex
+	entries map[string]statusEntry
+}
+
+// NewStatusCache constructs a StatusCache for use
+func NewStatusCache(db *sqlx.DB, ttl time.Duration) *StatusCache {
+	return &StatusCache{
+		db:      db,
+		ttl:     ttl,
+		entries: make(map[string]statusEntry),
+	}
+}
+
+// Check returns nil if the user behind the claims is active and the token was
+// issued for their current token version
+func (c *StatusCache) Check(ctx context.Context, claims auth.Claims) error {
+	e, err := c.lookup(ctx, claims.Subject)
+	if err != nil {
+		return err
+	}
+
+	if e.status != StatusActive {
+		return ErrDisabled
+	}
+
+	if claims.TokenVersion != e.tokenVersion {
+		return ErrTokenRevoked
+	}
+
+	return nil
+}
+
+// SetStatus changes the status of a member of orgID and drops their cached
+// entry
+func (c *StatusCache) SetStatus(ctx context.Context, orgID, id, status string, now time.Time) error {
+	if err := SetStatus(ctx, c.db, orgID, id, status, now); err != nil {
+		return err
+	}
+
+	c.Invalidate(id)
+	return nil
+}
+
+// RevokeTokens invalidates all tokens of a member of orgID and drops their
+// cached entry
+func (c *StatusCache) RevokeTokens(ctx context.Context, orgID, id string, now time.Time) error {
+	if err := RevokeTokens(ctx, c.db, orgID, id, now); err != nil {
+		return err
+	}
+
+	c.Invalidate(id)
+	return nil
+}
+
+// Invalidate removes the cached entry of a user so the next Check reads it again
+func (c *StatusCache) Invalidate(id string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	delete(c.entries, id)
+}
+
+// lookup returns the cached entry of a user or loads it from the database
+func (c *StatusCache) lookup(ctx context.Context, id string) (statusEntry, error) {
+	c.mu.Lock()
+	e, ok := c.entries[id]
+	c.mu.Unlock()
+
+	if ok && time.Since(e.fetched) < c.ttl {
+		return e, nil
+	}
+
+	if _, err := uuid.Parse(id); err != nil {
+		return statusEntry{}, ErrInvalidID
+	}
+
+	const q = `SELECT status, token_version FROM users WHERE user_id = $1`
+
+	var row struct {
+		Status       string `db:"status"`
+		TokenVersion int    `db:"token_version"`
+	}
+	if err := c.db.GetContext(ctx, &row, q, id); err != nil {
+		if err == sql.ErrNoRows {
+			return statusEntry{}, ErrNotFound
+		}
+
+		return statusEntry{}, errors.Wrapf(err, "selecting status of user %q", id)
+	}
+
+	e = statusEntry{
+		status:       row.Status,
+		tokenVersion: row.TokenVersion,
+		fetched:      time.Now(),
+	}
+
+	c.mu.Lock()
+	c.entries[id] = e
+	c.mu.Unlock()
+
+	return e, nil
+}
@@ -0,0 +1,340 @@
+package user
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base32"
+	"garagesale/internal/platform/auth"
+	"garagesale/internal/platform/totp"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
+)
+
+// Predefined errors for two-factor authentication
+var (
+	ErrTwoFactorEnabled    = errors.New("two-factor authentication is already enabled")
+	ErrTwoFactorNotEnabled = errors.New("two-factor authentication is not enabled")
+	ErrTwoFactorNotStarted = errors.New("two-factor enrollment was not started")
+	ErrInvalidCode         = errors.New("two-factor code is invalid")
+	ErrInvalidChallenge    = errors.New("two-factor challenge is invalid")
+	ErrTooManyAttempts     = errors.New("too many two-factor attempts, try again later")
+)
+
+const (
+	// challengeTTL is how long a user has to provide the second factor after
+	// their password was accepted
+	challengeTTL = 5 * time.Minute
+
+	// recoveryCodeCount is how many recovery codes are issued on enrollment
+	recoveryCodeCount = 10
+
+	// totpSkew is how many time steps of clock drift are tolerated
+	totpSkew = 1
+
+	// challengeAttempts is how many codes can be tried against one challenge
+	// before the user has to log in again
+	challengeAttempts = 5
+
+	// userAttempts is how many codes can be tried for a user within
+	// attemptWindow across all of their challenges
+	userAttempts = 10
+
+	// attemptWindow is how long failed attempts count against a user
+	attemptWindow = 15 * time.Minute
+)
+
+// EnrollTOTP starts two-factor enrollment for the User identified by id. It
+// generates a new secret and recovery codes; two-factor authentication is
+// enabled once a code is confirmed with ConfirmTOTP.
+func EnrollTOTP(ctx context.Context, db *sqlx.DB, id, issuer string, now time.Time) (*TOTPEnrollment, error) {
+	u, err := Retrieve(ctx, db, id)
+	if err != nil {
+		return nil, err
+	}
+
+	if u.TOTPEnabled {
+		return nil, ErrTwoFactorEnabled
+	}
+
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		return nil, err
+	}
+
+	codes := make([]string, recoveryCodeCount)
+	for i := range codes {
+		if codes[i], err = newRecoveryCode(); err != nil {
+			return nil, err
+		}
+	}
+
+	tx, err := db.BeginTxx(ctx, nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "starting transaction")
+	}
+	defer tx.Rollback()
+
+	const qu = `
+		UPDATE users SET
+		totp_secret = $2,
+		totp_last_step = 0,
+		date_updated = $3
+		WHERE user_id = $1
+	`
+	if _, err := tx.ExecContext(ctx, qu, u.ID, secret, now.UTC()); err != nil {
+		return nil, errors.Wrap(err, "storing totp secret")
+	}
+
+	const qd = `DELETE FROM recovery_codes WHERE user_id = $1`
+	if _, err := tx.ExecContext(ctx, qd, u.ID); err != nil {
+		return nil, errors.Wrap(err, "deleting recovery codes")
+	}
+
+	const qi = `INSERT INTO recovery_codes (user_id, code_hash) VALUES ($1, $2)`
+	for _, c := range codes {
+		if _, err := tx.ExecContext(ctx, qi, u.ID, hashToken(c)); err != nil {
+			return nil, errors.Wrap(err, "inserting recovery code")
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, errors.Wrap(err, "commiting enrollment")
+	}
+
+	e := TOTPEnrollment{
+		Secret:        secret,
+		URI:           totp.ProvisioningURI(issuer, u.Email, secret),
+		RecoveryCodes: codes,
+	}
+
+	return &e, nil
+}
+
+// ConfirmTOTP enables two-factor authentication once the user proves their
+// authenticator app produces valid codes for the enrolled secret
+func ConfirmTOTP(ctx context.Context, db *sqlx.DB, id, code string, now time.Time) error {
+	u, err := Retrieve(ctx, db, id)
+	if err != nil {
+		return err
+	}
+
+	if u.TOTPEnabled {
+		return ErrTwoFactorEnabled
+	}
+
+	if u.TOTPSecret == nil {
+		return ErrTwoFactorNotStarted
+	}
+
+	step, ok := totp.Validate(*u.TOTPSecret, code, now, totpSkew)
+	if !ok {
+		return ErrInvalidCode
+	}
+
+	const q = `
+		UPDATE users SET
+		totp_enabled = TRUE,
+		totp_last_step = $2,
+		date_updated = $3
+		WHERE user_id = $1
+	`
+	if _, err := db.ExecContext(ctx, q, u.ID, step, now.UTC()); err != nil {
+		return errors.Wrap(err, "enabling two-factor authentication")
+	}
+
+	return nil
+}
+
+// DisableTOTP turns two-factor authentication off after checking a second factor
+func DisableTOTP(ctx context.Context, db *sqlx.DB, id, code string, now time.Time) error {
+	if err := verifySecondFactor(ctx, db, id, code, now); err != nil {
+		return err
+	}
+
+	const q = `
+		UPDATE users SET
+		totp_enabled = FALSE,
+		totp_secret = NULL,
+		totp_last_step = 0,
+		date_updated = $2
+		WHERE user_id = $1
+	`
+	if _, err := db.ExecContext(ctx, q, id, now.UTC()); err != nil {
+		return errors.Wrap(err, "disabling two-factor authentication")
+	}
+
+	const qd = `DELETE FROM recovery_codes WHERE user_id = $1`
+	if _, err := db.ExecContext(ctx, qd, id); err != nil {
+		return errors.Wrap(err, "deleting recovery codes")
+	}
+
+	return nil
+}
+
+// newChallenge returns the claims of a challenge for the user and stores its
+// ID so attempts to answer it can be counted. Challenges older than
+// attemptWindow are deleted on the way.
+func newChallenge(ctx context.Context, db *sqlx.DB, u *User, orgID string, now time.Time) (auth.Claims, error) {
+	claims := auth.NewClaims(u.ID, nil, now, challengeTTL)
+	claims.OrgID = orgID
+	claims.Purpose = auth.PurposeTwoFactor
+	claims.TokenVersion = u.TokenVersion
+
+	const qd = `DELETE FROM two_factor_challenges WHERE user_id = $1 AND date_created <= $2`
+	if _, err := db.ExecContext(ctx, qd, u.ID, now.Add(-attemptWindow).UTC()); err != nil {
+		return auth.Claims{}, errors.Wrap(err, "deleting old challenges")
+	}
+
+	const q = `
+		INSERT INTO two_factor_challenges
+		(jti, user_id, expires_at, date_created)
+		VALUES ($1, $2, $3, $4)
+	`
+	if _, err := db.ExecContext(ctx, q, claims.Id, u.ID, now.Add(challengeTTL).UTC(), now.UTC()); err != nil {
+		return auth.Claims{}, errors.Wrap(err, "inserting challenge")
+	}
+
+	return claims, nil
+}
+
+// CompleteTwoFactor exchanges the claims of a challenge issued by Authenticate
+// and a second factor for the real claims of the user. Each challenge can be
+// answered challengeAttempts times and is used up by the first right answer.
+// Users that tried more than userAttempts codes within attemptWindow get
+// ErrTooManyAttempts until older attempts leave the window.
+func CompleteTwoFactor(ctx context.Context, db *sqlx.DB, challenge auth.Claims, code string, now time.Time) (auth.Claims, error) {
+	if challenge.Purpose != auth.PurposeTwoFactor || challenge.Id == "" {
+		return auth.Claims{}, ErrInvalidChallenge
+	}
+
+	// The attempt is counted before the code is checked so concurrent
+	// requests cannot try more codes than allowed
+	const qa = `
+		UPDATE two_factor_challenges SET
+		attempts = attempts + 1
+		WHERE jti = $1 AND user_id = $2 AND expires_at > $3 AND attempts < $4
+	`
+	res, err := db.ExecContext(ctx, qa, challenge.Id, challenge.Subject, now.UTC(), challengeAttempts)
+	if err != nil {
+		return auth.Claims{}, errors.Wrap(err, "counting challenge attempt")
+	}
+	if n, err := res.RowsAffected(); err != nil || n == 0 {
+		return auth.Claims{}, ErrInvalidChallenge
+	}
+
+	const qc = `
+		SELECT COALESCE(SUM(attempts), 0) FROM two_factor_challenges
+		WHERE user_id = $1 AND date_created > $2
+	`
+	var attempts int
+	if err := db.GetContext(ctx, &attempts, qc, challenge.Subject, now.Add(-attemptWindow).UTC()); err != nil {
+		return auth.Claims{}, errors.Wrap(err, "counting attempts of user")
+	}
+	if attempts > userAttempts {
+		return auth.Claims{}, ErrTooManyAttempts
+	}
+
+	if err := verifySecondFactor(ctx, db, challenge.Subject, code, now); err != nil {
+		return auth.Claims{}, err
+	}
+
+	const qd = `DELETE FROM two_factor_challenges WHERE jti = $1`
+	if _, err := db.ExecContext(ctx, qd, challenge.Id); err != nil {
+		return auth.Claims{}, errors.Wrap(err, "deleting challenge")
+	}
+
+	u, err := Retrieve(ctx, db, challenge.Subject)
+	if err != nil {
+		return auth.Claims{}, err
+	}
+
+	if u.Status != StatusActive {
+		return auth.Claims{}, ErrDisabled
+	}
+
+	if u.TokenVersion != challenge.TokenVersion {
+		return auth.Claims{}, ErrTokenRevoked
+	}
+
+	m, err := membership(ctx, db, u.ID, challenge.OrgID)
+	if err != nil {
+		return auth.Claims{}, err
+	}
+
+	return newClaims(ctx, db, u, m, now)
+}
+
+// verifySecondFactor accepts either a TOTP code that was not used before or
+// an unused recovery code of the user
+func verifySecondFactor(ctx context.Context, db *sqlx.DB, id, code string, now time.Time) error {
+	u, err := Retrieve(ctx, db, id)
+	if err != nil {
+		return err
+	}
+
+	if !u.TOTPEnabled || u.TOTPSecret == nil {
+		return ErrTwoFactorNotEnabled
+	}
+
+	if step, ok := totp.Validate(*u.TOTPSecret, code, now, totpSkew); ok {
+		// Only move forward so a code cannot be replayed within its window
+		const q = `
+			UPDATE users SET
+			totp_last_step = $2
+			WHERE user_id = $1 AND totp_last_step < $2
+		`
+
+		res, err := db.ExecContext(ctx, q, u.ID, step)
+		if err != nil {
+			return errors.Wrap(err, "storing totp step")
+		}
+
+		if n, err := res.RowsAffected(); err != nil || n == 0 {
+			return ErrInvalidCode
+		}
+
+		return nil
+	}
+
+	const q = `
+		UPDATE recovery_codes SET
+		date_used = $3
+		WHERE user_id = $1 AND code_hash = $2 AND date_used IS NULL
+	`
+
+	res, err := db.ExecContext(ctx, q, u.ID, hashToken(normalizeRecoveryCode(code)), now.UTC())
+	if err != nil {
+		return errors.Wrap(err, "using recovery code")
+	}
+
+	if n, err := res.RowsAffected(); err != nil || n == 0 {
+		return ErrInvalidCode
+	}
+
+	return nil
+}
+
+// newRecoveryCode returns a random code in the form xxxxx-xxxxx
+func newRecoveryCode() (string, error) {
+	b := make([]byte, 7)
+	if _, err := rand.Read(b); err != nil {
+		return "", errors.Wrap(err, "generating recovery code")
+	}
+
+	s := strings.ToLower(base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(b))[:10]
+	return s[:5] + "-" + s[5:], nil
+}
+
+// normalizeRecoveryCode makes recovery codes case insensitive and tolerant
+// to a missing dash
+func normalizeRecoveryCode(code string) string {
+	code = strings.ToLower(strings.ReplaceAll(strings.TrimSpace(code), "-", ""))
+	if len(code) != 10 {
+		return code
+	}
+
+	return code[:5] + "-" + code[5:]
+}
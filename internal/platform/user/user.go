@@ -2,60 +2,113 @@ package user
 
 import (
 	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
 	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
 	"garagesale/internal/platform/auth"
+	"garagesale/internal/platform/organization"
+	"garagesale/internal/platform/role"
+	"sort"
 	"time"
 
 	"github.com/google/uuid"
 	"github.com/jmoiron/sqlx"
+	"github.com/jmoiron/sqlx/types"
+	"github.com/lib/pq"
 	"github.com/pkg/errors"
-	"golang.org/x/crypto/bcrypt"
 )
 
+// Predefined errors for known failure scenarios
 var (
 	ErrAuthenticationFailure = errors.New("Authentication failed")
+	ErrNotFound              = errors.New("user not found")
+	ErrInvalidID             = errors.New("ID provided was not a valid ID")
+	ErrEmailTaken            = errors.New("email is already in use")
+	ErrInvalidVerification   = errors.New("email verification token is invalid")
+	ErrDisabled              = errors.New("user is disabled")
+	ErrTokenRevoked          = errors.New("token has been revoked")
+	ErrInvalidStatus         = errors.New("status must be active or disabled")
+	ErrNoMembership          = errors.New("user is not a member of the organization")
 )
 
-// Create insert new user into the database
-func Create(ctx context.Context, db *sqlx.DB, nu NewUser, now time.Time) (*User, error) {
-	hash, err := bcrypt.GenerateFromPassword([]byte(nu.Password), bcrypt.DefaultCost)
+// Create insert new user into the database and adds them to their organization.
+// The password is hashed according to policy.
+func Create(ctx context.Context, db *sqlx.DB, policy auth.PasswordPolicy, nu NewUser, now time.Time) (*User, error) {
+	hash, err := policy.Hash(nu.Password)
 	if err != nil {
 		return nil, errors.Wrap(err, "generate password hash")
 	}
 
+	orgID := nu.OrgID
+	if orgID == "" {
+		orgID = organization.DefaultID
+	}
+
 	u := User{
 		ID:           uuid.New().String(),
 		Name:         nu.Name,
 		Email:        nu.Email,
-		Roles:        nu.Roles,
 		PasswordHash: hash,
+		Preferences:  types.JSONText("{}"),
+		Status:       StatusActive,
 		DateCreated:  now.UTC(),
 		DateUpdated:  now.UTC(),
 	}
 
-	const q = `
+	tx, err := db.BeginTxx(ctx, nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "starting transaction")
+	}
+	defer tx.Rollback()
+
+	const qu = `
 		INSERT INTO users
-		(user_id, name, email, roles, password_hash, date_created, date_updated)
+		(user_id, name, email, password_hash, date_created, date_updated)
 		VALUES
-		($1, $2, $3, $4, $5, $6, $7)
+		($1, $2, $3, $4, $5, $6)
 	`
 
-	_, err = db.ExecContext(
-		ctx, q,
-		u.ID, u.Name, u.Email, u.Roles, u.PasswordHash, u.DateCreated, u.DateUpdated,
+	_, err = tx.ExecContext(
+		ctx, qu,
+		u.ID, u.Name, u.Email, u.PasswordHash, u.DateCreated, u.DateUpdated,
 	)
-
 	if err != nil {
 		return nil, err
 	}
 
+	const qm = `
+		INSERT INTO memberships
+		(org_id, user_id, roles, date_created)
+		VALUES
+		($1, $2, $3, $4)
+	`
+
+	if _, err := tx.ExecContext(ctx, qm, orgID, u.ID, pq.StringArray(nu.Roles), u.DateCreated); err != nil {
+		return nil, errors.Wrap(err, "inserting membership")
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, errors.Wrap(err, "commiting user")
+	}
+
 	return &u, nil
 }
 
 // Authenticate find a user by their email and verifies their password. On success it returns
-// a Claims value representing this user. The claims can be used to generate a token for future
-// authentication.
-func Authenticate(ctx context.Context, db *sqlx.DB, now time.Time, email, password string) (auth.Claims, error) {
+// a Claims value representing this user inside orgID. If orgID is blank the oldest membership
+// of the user is used. The claims can be used to generate a token for future authentication.
+//
+// The stored password hash is verified with the algorithm it was made with. When it is
+// outdated for policy it is replaced by a new hash of the password, unless the user
+// is disabled.
+//
+// For users with two-factor authentication enabled the returned claims are a short-lived
+// challenge with auth.PurposeTwoFactor that has to be passed to CompleteTwoFactor.
+func Authenticate(ctx context.Context, db *sqlx.DB, policy auth.PasswordPolicy, now time.Time, email, password, orgID string) (auth.Claims, error) {
 	const q = `SELECT * FROM users WHERE email = $1;`
 
 	var u User
@@ -68,10 +121,493 @@ func Authenticate(ctx context.Context, db *sqlx.DB, now time.Time, email, passwo
 		return auth.Claims{}, errors.Wrap(err, "selecting single user")
 	}
 
-	if err := bcrypt.CompareHashAndPassword(u.PasswordHash, []byte(password)); err != nil {
+	// Users provisioned by an external provider have no password
+	if len(u.PasswordHash) == 0 {
 		return auth.Claims{}, ErrAuthenticationFailure
 	}
 
-	claims := auth.NewClaims(u.ID, u.Roles, now, time.Hour)
+	ok, rehash, err := policy.Verify(u.PasswordHash, password)
+	if err != nil {
+		return auth.Claims{}, errors.Wrap(err, "verifying password")
+	}
+
+	if !ok {
+		return auth.Claims{}, ErrAuthenticationFailure
+	}
+
+	if u.Status != StatusActive {
+		return auth.Claims{}, ErrDisabled
+	}
+
+	if rehash {
+		if err := updatePasswordHash(ctx, db, policy, u.ID, password, now); err != nil {
+			return auth.Claims{}, err
+		}
+	}
+
+	m, err := membership(ctx, db, u.ID, orgID)
+	if err != nil {
+		return auth.Claims{}, err
+	}
+
+	if u.TOTPEnabled {
+		return newChallenge(ctx, db, &u, m.OrgID, now)
+	}
+
+	return newClaims(ctx, db, &u, m, now)
+}
+
+// updatePasswordHash replaces the stored hash of a user with one made by policy
+func updatePasswordHash(ctx context.Context, db *sqlx.DB, policy auth.PasswordPolicy, id, password string, now time.Time) error {
+	hash, err := policy.Hash(password)
+	if err != nil {
+		return errors.Wrap(err, "generate password hash")
+	}
+
+	const q = `
+		UPDATE users SET
+		password_hash = $2,
+		date_updated = $3
+		WHERE user_id = $1
+	`
+
+	if _, err := db.ExecContext(ctx, q, id, hash, now.UTC()); err != nil {
+		return errors.Wrap(err, "updating password hash")
+	}
+
+	return nil
+}
+
+// ChangePassword replaces the password of the User identified by id once their
+// current password is verified. Users provisioned by an external provider have
+// no password to change. Every token, refresh token and session issued to the
+// user before is revoked together with the change.
+func ChangePassword(ctx context.Context, db *sqlx.DB, policy auth.PasswordPolicy, id string, up UpdatePassword, now time.Time) error {
+	u, err := Retrieve(ctx, db, id)
+	if err != nil {
+		return err
+	}
+
+	if len(u.PasswordHash) == 0 {
+		return ErrAuthenticationFailure
+	}
+
+	ok, _, err := policy.Verify(u.PasswordHash, up.Current)
+	if err != nil {
+		return errors.Wrap(err, "verifying password")
+	}
+
+	if !ok {
+		return ErrAuthenticationFailure
+	}
+
+	hash, err := policy.Hash(up.Password)
+	if err != nil {
+		return errors.Wrap(err, "generate password hash")
+	}
+
+	tx, err := db.BeginTxx(ctx, nil)
+	if err != nil {
+		return errors.Wrap(err, "starting transaction")
+	}
+	defer tx.Rollback()
+
+	const qu = `
+		UPDATE users SET
+		password_hash = $2,
+		token_version = token_version + 1,
+		date_updated = $3
+		WHERE user_id = $1
+	`
+
+	if _, err := tx.ExecContext(ctx, qu, u.ID, hash, now.UTC()); err != nil {
+		return errors.Wrap(err, "updating password hash")
+	}
+
+	const qr = `
+		UPDATE refresh_tokens SET
+		date_revoked = $2
+		WHERE user_id = $1 AND date_revoked IS NULL
+	`
+
+	if _, err := tx.ExecContext(ctx, qr, u.ID, now.UTC()); err != nil {
+		return errors.Wrap(err, "revoking refresh tokens")
+	}
+
+	const qs = `DELETE FROM sessions WHERE user_id = $1`
+
+	if _, err := tx.ExecContext(ctx, qs, u.ID); err != nil {
+		return errors.Wrap(err, "deleting sessions")
+	}
+
+	if err := tx.Commit(); err != nil {
+		return errors.Wrap(err, "commiting password")
+	}
+
+	return nil
+}
+
+// newClaims builds the claims of a user inside the organization of a membership
+func newClaims(ctx context.Context, db *sqlx.DB, u *User, m *organization.Membership, now time.Time) (auth.Claims, error) {
+	perms, err := role.Permissions(ctx, db, m.OrgID, m.Roles)
+	if err != nil {
+		return auth.Claims{}, err
+	}
+
+	// The lifetime of the token is decided by the authenticator issuing it
+	claims := auth.NewClaims(u.ID, m.Roles, now, 0)
+	claims.OrgID = m.OrgID
+	claims.Permissions = perms
+	claims.TokenVersion = u.TokenVersion
 	return claims, nil
 }
+
+// memberColumns selects the current state of user u inside the organization
+// of membership m that claims are built from. The membership columns are NULL
+// when the user is not a member. When $2 is set admins without two-factor
+// authentication get no permissions from the admin role, which is $3.
+const memberColumns = `
+	u.user_id, u.status, u.token_version, u.totp_enabled, m.roles, ARRAY(
+		SELECT DISTINCT UNNEST(r.permissions) FROM roles AS r
+		WHERE r.name = ANY(m.roles) AND (r.org_id IS NULL OR r.org_id = m.org_id)
+		AND NOT ($2 AND NOT u.totp_enabled AND r.name = $3)
+	) AS permissions
+`
+
+// member is the state of a user inside an organization read by memberColumns
+type member struct {
+	UserID       string         `db:"user_id"`
+	Status       string         `db:"status"`
+	TokenVersion int            `db:"token_version"`
+	TOTPEnabled  bool           `db:"totp_enabled"`
+	Roles        pq.StringArray `db:"roles"`
+	Permissions  pq.StringArray `db:"permissions"`
+}
+
+// claims builds the claims of the member inside orgID. It fails when the
+// user is disabled or not a member. When requireAdminTwoFactor is set the
+// admin role is removed from admins without two-factor authentication, as
+// when they log in with a password.
+func (m member) claims(orgID string, requireAdminTwoFactor bool, now time.Time) (auth.Claims, error) {
+	if m.Status != StatusActive {
+		return auth.Claims{}, ErrDisabled
+	}
+
+	if m.Roles == nil {
+		return auth.Claims{}, ErrNoMembership
+	}
+
+	roles := []string(m.Roles)
+	if requireAdminTwoFactor && !m.TOTPEnabled {
+		roles = []string{}
+		for _, r := range m.Roles {
+			if r != auth.RoleAdmin {
+				roles = append(roles, r)
+			}
+		}
+	}
+
+	perms := []string(m.Permissions)
+	sort.Strings(perms)
+
+	// The lifetime of the claims is decided by the caller
+	claims := auth.NewClaims(m.UserID, roles, now, 0)
+	claims.OrgID = orgID
+	claims.Permissions = perms
+	claims.TokenVersion = m.TokenVersion
+	return claims, nil
+}
+
+// WithoutRoles returns a copy of the claims with the roles removed and the
+// permissions resolved again for the remaining roles
+func WithoutRoles(ctx context.Context, db *sqlx.DB, claims auth.Claims, roles ...string) (auth.Claims, error) {
+	kept := []string{}
+	for _, has := range claims.Roles {
+		drop := false
+		for _, r := range roles {
+			if has == r {
+				drop = true
+				break
+			}
+		}
+
+		if !drop {
+			kept = append(kept, has)
+		}
+	}
+
+	perms, err := role.Permissions(ctx, db, claims.OrgID, kept)
+	if err != nil {
+		return auth.Claims{}, err
+	}
+
+	claims.Roles = kept
+	claims.Permissions = perms
+	return claims, nil
+}
+
+// Retrieve returns a single User identified by id
+func Retrieve(ctx context.Context, db *sqlx.DB, id string) (*User, error) {
+	if _, err := uuid.Parse(id); err != nil {
+		return nil, ErrInvalidID
+	}
+
+	const q = `SELECT * FROM users WHERE user_id = $1`
+
+	var u User
+	if err := db.GetContext(ctx, &u, q, id); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrNotFound
+		}
+
+		return nil, errors.Wrapf(err, "selecting user %q", id)
+	}
+
+	return &u, nil
+}
+
+// EmailTaken reports whether email is the email of a user other than the one
+// identified by id
+func EmailTaken(ctx context.Context, db *sqlx.DB, email, id string) (bool, error) {
+	const q = `SELECT EXISTS (SELECT 1 FROM users WHERE email = $1 AND user_id <> $2)`
+
+	var taken bool
+	if err := db.GetContext(ctx, &taken, q, email, id); err != nil {
+		return false, errors.Wrap(err, "checking email")
+	}
+
+	return taken, nil
+}
+
+// Update modifies the profile of the User identified by id. An email change
+// is not applied right away: it is stored as pending and the returned token
+// must be passed to VerifyEmailChange to confirm it. The token is empty when
+// the email was not changed.
+func Update(ctx context.Context, db *sqlx.DB, id string, uu UpdateUser, now time.Time) (*User, string, error) {
+	u, err := Retrieve(ctx, db, id)
+	if err != nil {
+		return nil, "", err
+	}
+
+	if uu.Name != nil {
+		u.Name = *uu.Name
+	}
+
+	if uu.Preferences != nil {
+		prefs, err := json.Marshal(uu.Preferences)
+		if err != nil {
+			return nil, "", errors.Wrap(err, "marshalling preferences")
+		}
+		u.Preferences = prefs
+	}
+
+	var token string
+	if uu.Email != nil && *uu.Email != u.Email {
+		taken, err := EmailTaken(ctx, db, *uu.Email, u.ID)
+		if err != nil {
+			return nil, "", err
+		}
+		if taken {
+			return nil, "", ErrEmailTaken
+		}
+
+		var hash string
+		token, hash, err = newVerificationToken()
+		if err != nil {
+			return nil, "", err
+		}
+
+		u.PendingEmail = uu.Email
+		u.EmailVerificationHash = &hash
+	}
+
+	u.DateUpdated = now.UTC()
+
+	const q = `
+		UPDATE users SET
+		name = $2,
+		preferences = $3,
+		pending_email = $4,
+		email_verification_hash = $5,
+		date_updated = $6
+		WHERE user_id = $1
+	`
+
+	_, err = db.ExecContext(ctx, q, u.ID,
+		u.Name, u.Preferences, u.PendingEmail, u.EmailVerificationHash, u.DateUpdated,
+	)
+	if err != nil {
+		return nil, "", errors.Wrap(err, "updating user")
+	}
+
+	return u, token, nil
+}
+
+// VerifyEmailChange applies a pending email change for the User identified by id
+// when the token matches the one issued by Update.
+func VerifyEmailChange(ctx context.Context, db *sqlx.DB, id, token string, now time.Time) (*User, error) {
+	u, err := Retrieve(ctx, db, id)
+	if err != nil {
+		return nil, err
+	}
+
+	if u.PendingEmail == nil || u.EmailVerificationHash == nil {
+		return nil, ErrInvalidVerification
+	}
+
+	if subtle.ConstantTimeCompare([]byte(hashToken(token)), []byte(*u.EmailVerificationHash)) != 1 {
+		return nil, ErrInvalidVerification
+	}
+
+	u.Email = *u.PendingEmail
+	u.EmailVerified = true
+	u.PendingEmail = nil
+	u.EmailVerificationHash = nil
+	u.DateUpdated = now.UTC()
+
+	const q = `
+		UPDATE users SET
+		email = $2,
+		email_verified = TRUE,
+		pending_email = NULL,
+		email_verification_hash = NULL,
+		date_updated = $3
+		WHERE user_id = $1
+	`
+
+	if _, err := db.ExecContext(ctx, q, u.ID, u.Email, u.DateUpdated); err != nil {
+		if isUniqueViolation(err) {
+			return nil, ErrEmailTaken
+		}
+
+		return nil, errors.Wrap(err, "verifying email")
+	}
+
+	return u, nil
+}
+
+// membership returns the membership of the user in orgID, or their oldest
+// membership when orgID is blank
+func membership(ctx context.Context, db *sqlx.DB, userID, orgID string) (*organization.Membership, error) {
+	if orgID != "" {
+		m, err := organization.RetrieveMembership(ctx, db, orgID, userID)
+		if err != nil {
+			if err == organization.ErrMembershipNotFound || err == organization.ErrInvalidID {
+				return nil, ErrNoMembership
+			}
+
+			return nil, err
+		}
+
+		return m, nil
+	}
+
+	ms, err := organization.Memberships(ctx, db, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(ms) == 0 {
+		return nil, ErrNoMembership
+	}
+
+	return &ms[0], nil
+}
+
+// SetStatus changes the status of the User identified by id. Disabling a user
+// also bumps their token version so every token issued before is revoked.
+// Users that are not a member of orgID are not found, a blank orgID reaches
+// every user.
+func SetStatus(ctx context.Context, db *sqlx.DB, orgID, id, status string, now time.Time) error {
+	if _, err := uuid.Parse(id); err != nil {
+		return ErrInvalidID
+	}
+
+	if status != StatusActive && status != StatusDisabled {
+		return ErrInvalidStatus
+	}
+
+	const q = `
+		UPDATE users SET
+		status = $2,
+		token_version = CASE WHEN $2 = 'disabled' THEN token_version + 1 ELSE token_version END,
+		date_updated = $3
+		WHERE user_id = $1
+		AND ($4 = '' OR EXISTS (
+			SELECT 1 FROM memberships AS m
+			WHERE m.user_id = users.user_id AND m.org_id = NULLIF($4, '')::UUID
+		))
+	`
+
+	res, err := db.ExecContext(ctx, q, id, status, now.UTC(), orgID)
+	if err != nil {
+		return errors.Wrap(err, "updating user status")
+	}
+
+	return expectOneRow(res)
+}
+
+// RevokeTokens invalidates every token issued to the User identified by id
+// without changing their status. Users that are not a member of orgID are not
+// found, a blank orgID reaches every user.
+func RevokeTokens(ctx context.Context, db *sqlx.DB, orgID, id string, now time.Time) error {
+	if _, err := uuid.Parse(id); err != nil {
+		return ErrInvalidID
+	}
+
+	const q = `
+		UPDATE users SET
+		token_version = token_version + 1,
+		date_updated = $2
+		WHERE user_id = $1
+		AND ($3 = '' OR EXISTS (
+			SELECT 1 FROM memberships AS m
+			WHERE m.user_id = users.user_id AND m.org_id = NULLIF($3, '')::UUID
+		))
+	`
+
+	res, err := db.ExecContext(ctx, q, id, now.UTC(), orgID)
+	if err != nil {
+		return errors.Wrap(err, "revoking user tokens")
+	}
+
+	return expectOneRow(res)
+}
+
+// expectOneRow returns ErrNotFound when a statement did not touch any user
+func expectOneRow(res sql.Result) error {
+	n, err := res.RowsAffected()
+	if err != nil {
+		return errors.Wrap(err, "counting affected rows")
+	}
+
+	if n == 0 {
+		return ErrNotFound
+	}
+
+	return nil
+}
+
+// newVerificationToken returns a random token to hand out to the user and the
+// hash of it that is safe to store
+func newVerificationToken() (string, string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", errors.Wrap(err, "generating token")
+	}
+
+	token := base64.RawURLEncoding.EncodeToString(b)
+	return token, hashToken(token), nil
+}
+
+// hashToken returns hex encoded sha256 of the token
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// isUniqueViolation reports whether err was caused by a unique constraint
+func isUniqueViolation(err error) bool {
+	pqErr, ok := errors.Cause(err).(*pq.Error)
+	return ok && pqErr.Code == "23505"
+}
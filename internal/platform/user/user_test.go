@@ -0,0 +1,663 @@
+package user_test
+
+import (
+	"context"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"garagesale/internal/platform/auth"
+	"garagesale/internal/platform/database/databasetest"
+	"garagesale/internal/platform/organization"
+	"garagesale/internal/platform/totp"
+	"garagesale/internal/platform/user"
+	"testing"
+	"time"
+)
+
+func TestUserProfile(t *testing.T) {
+	db, teardown := databasetest.Setup(t)
+	t.Cleanup(teardown)
+	ctx := context.Background()
+
+	nu := user.NewUser{
+		Name:            "test",
+		Email:           "test@example.com",
+		Roles:           []string{auth.RoleUser},
+		Password:        "secret",
+		PasswordConfirm: "secret",
+	}
+
+	created, err := user.Create(ctx, db, auth.DefaultPasswordPolicy, nu, time.Now())
+	if err != nil {
+		t.Fatalf("could not create user: %v", err)
+	}
+
+	name := "updated"
+	email := "updated@example.com"
+	uu := user.UpdateUser{
+		Name:        &name,
+		Email:       &email,
+		Preferences: map[string]interface{}{"theme": "dark"},
+	}
+
+	updated, token, err := user.Update(ctx, db, created.ID, uu, time.Now())
+	if err != nil {
+		t.Fatalf("could not update user: %v", err)
+	}
+
+	if updated.Name != name {
+		t.Fatalf("expected name %q, got %q", name, updated.Name)
+	}
+	if updated.Email != nu.Email {
+		t.Fatalf("email must not change before verification, got %q", updated.Email)
+	}
+	if token == "" {
+		t.Fatal("expected verification token for email change")
+	}
+
+	if _, err := user.VerifyEmailChange(ctx, db, created.ID, "wrong", time.Now()); err != user.ErrInvalidVerification {
+		t.Fatalf("expected %v, got %v", user.ErrInvalidVerification, err)
+	}
+
+	verified, err := user.VerifyEmailChange(ctx, db, created.ID, token, time.Now())
+	if err != nil {
+		t.Fatalf("could not verify email: %v", err)
+	}
+
+	saved, err := user.Retrieve(ctx, db, created.ID)
+	if err != nil {
+		t.Fatalf("could not retrieve user: %v", err)
+	}
+
+	if saved.Email != email || !saved.EmailVerified || saved.PendingEmail != nil {
+		t.Fatalf("email change not applied: %+v", saved)
+	}
+	if verified.Email != saved.Email {
+		t.Fatalf("expected email %q, got %q", saved.Email, verified.Email)
+	}
+
+	// The own email of a user is not taken for them
+	if taken, err := user.EmailTaken(ctx, db, email, created.ID); err != nil || taken {
+		t.Fatalf("expected own email to be free, got %v %v", taken, err)
+	}
+	if taken, err := user.EmailTaken(ctx, db, email, "00000000-0000-0000-0000-00000000beef"); err != nil || !taken {
+		t.Fatalf("expected email to be taken for other users, got %v %v", taken, err)
+	}
+}
+
+func TestAuthenticateRehash(t *testing.T) {
+	db, teardown := databasetest.Setup(t)
+	t.Cleanup(teardown)
+	ctx := context.Background()
+
+	weak := auth.DefaultPasswordPolicy
+	weak.Argon2Time = 1
+	weak.Argon2Memory = 8 * 1024
+
+	nu := user.NewUser{
+		Name:            "test",
+		Email:           "rehash@example.com",
+		Roles:           []string{auth.RoleUser},
+		Password:        "secret",
+		PasswordConfirm: "secret",
+	}
+
+	created, err := user.Create(ctx, db, weak, nu, time.Now())
+	if err != nil {
+		t.Fatalf("could not create user: %v", err)
+	}
+
+	// Logging in under a stronger policy replaces the weak hash
+	if _, err := user.Authenticate(ctx, db, auth.DefaultPasswordPolicy, time.Now(), nu.Email, nu.Password, ""); err != nil {
+		t.Fatalf("could not authenticate: %v", err)
+	}
+
+	saved, err := user.Retrieve(ctx, db, created.ID)
+	if err != nil {
+		t.Fatalf("could not retrieve user: %v", err)
+	}
+
+	if string(saved.PasswordHash) == string(created.PasswordHash) {
+		t.Fatal("expected the weak hash to be replaced on login")
+	}
+
+	ok, rehash, err := auth.DefaultPasswordPolicy.Verify(saved.PasswordHash, nu.Password)
+	if err != nil || !ok || rehash {
+		t.Fatalf("expected stored hash to match the policy, got ok=%v rehash=%v err=%v", ok, rehash, err)
+	}
+
+	// The new hash still logs in
+	if _, err := user.Authenticate(ctx, db, auth.DefaultPasswordPolicy, time.Now(), nu.Email, nu.Password, ""); err != nil {
+		t.Fatalf("could not authenticate with the new hash: %v", err)
+	}
+
+	// Disabled users cannot log in and keep their weak hash
+	nu.Email = "disabled-rehash@example.com"
+	disabled, err := user.Create(ctx, db, weak, nu, time.Now())
+	if err != nil {
+		t.Fatalf("could not create user: %v", err)
+	}
+
+	if err := user.SetStatus(ctx, db, "", disabled.ID, user.StatusDisabled, time.Now()); err != nil {
+		t.Fatalf("could not disable user: %v", err)
+	}
+
+	if _, err := user.Authenticate(ctx, db, auth.DefaultPasswordPolicy, time.Now(), nu.Email, nu.Password, ""); err != user.ErrDisabled {
+		t.Fatalf("expected %v for a disabled user, got %v", user.ErrDisabled, err)
+	}
+
+	kept, err := user.Retrieve(ctx, db, disabled.ID)
+	if err != nil {
+		t.Fatalf("could not retrieve user: %v", err)
+	}
+
+	if string(kept.PasswordHash) != string(disabled.PasswordHash) {
+		t.Fatal("expected the hash of a disabled user to be left unchanged")
+	}
+}
+
+func TestUserStatus(t *testing.T) {
+	db, teardown := databasetest.Setup(t)
+	t.Cleanup(teardown)
+	ctx := context.Background()
+
+	nu := user.NewUser{
+		Name:            "test",
+		Email:           "status@example.com",
+		Roles:           []string{auth.RoleUser},
+		Password:        "secret",
+		PasswordConfirm: "secret",
+	}
+
+	if _, err := user.Create(ctx, db, auth.DefaultPasswordPolicy, nu, time.Now()); err != nil {
+		t.Fatalf("could not create user: %v", err)
+	}
+
+	claims, err := user.Authenticate(ctx, db, auth.DefaultPasswordPolicy, time.Now(), nu.Email, nu.Password, "")
+	if err != nil {
+		t.Fatalf("could not authenticate: %v", err)
+	}
+
+	cache := user.NewStatusCache(db, time.Minute)
+	if err := cache.Check(ctx, claims); err != nil {
+		t.Fatalf("expected active user to pass the check: %v", err)
+	}
+
+	other, err := organization.Create(ctx, db, organization.NewOrganization{Name: "other"}, time.Now())
+	if err != nil {
+		t.Fatalf("could not create organization: %v", err)
+	}
+
+	if err := cache.SetStatus(ctx, other.ID, claims.Subject, user.StatusDisabled, time.Now()); err != user.ErrNotFound {
+		t.Fatalf("expected %v for a user of another organization, got %v", user.ErrNotFound, err)
+	}
+	if err := user.RevokeTokens(ctx, db, other.ID, claims.Subject, time.Now()); err != user.ErrNotFound {
+		t.Fatalf("expected %v for a user of another organization, got %v", user.ErrNotFound, err)
+	}
+
+	if err := organization.AddMember(ctx, db, other.ID, claims.Subject, []string{auth.RoleUser}, time.Now()); err != nil {
+		t.Fatalf("could not add member: %v", err)
+	}
+	if err := organization.MoveMember(ctx, db, claims.OrgID, other.ID, claims.Subject); err != organization.ErrAlreadyMember {
+		t.Fatalf("expected %v moving into an organization of the user, got %v", organization.ErrAlreadyMember, err)
+	}
+
+	if err := cache.SetStatus(ctx, claims.OrgID, claims.Subject, user.StatusDisabled, time.Now()); err != nil {
+		t.Fatalf("could not disable user: %v", err)
+	}
+
+	if err := cache.Check(ctx, claims); err != user.ErrDisabled {
+		t.Fatalf("expected %v, got %v", user.ErrDisabled, err)
+	}
+
+	if _, err := user.Authenticate(ctx, db, auth.DefaultPasswordPolicy, time.Now(), nu.Email, nu.Password, ""); err != user.ErrDisabled {
+		t.Fatalf("expected %v, got %v", user.ErrDisabled, err)
+	}
+
+	if err := cache.SetStatus(ctx, claims.OrgID, claims.Subject, user.StatusActive, time.Now()); err != nil {
+		t.Fatalf("could not activate user: %v", err)
+	}
+
+	if err := cache.Check(ctx, claims); err != user.ErrTokenRevoked {
+		t.Fatalf("expected old token to stay revoked, got %v", err)
+	}
+}
+
+func TestChangePassword(t *testing.T) {
+	db, teardown := databasetest.Setup(t)
+	t.Cleanup(teardown)
+	ctx := context.Background()
+
+	nu := user.NewUser{
+		Name:            "test",
+		Email:           "password@example.com",
+		Roles:           []string{auth.RoleUser},
+		Password:        "secret",
+		PasswordConfirm: "secret",
+	}
+
+	if _, err := user.Create(ctx, db, auth.DefaultPasswordPolicy, nu, time.Now()); err != nil {
+		t.Fatalf("could not create user: %v", err)
+	}
+
+	claims, err := user.Authenticate(ctx, db, auth.DefaultPasswordPolicy, time.Now(), nu.Email, nu.Password, "")
+	if err != nil {
+		t.Fatalf("could not authenticate: %v", err)
+	}
+
+	refresh, err := user.IssueRefreshToken(ctx, db, claims, time.Now())
+	if err != nil {
+		t.Fatalf("could not issue refresh token: %v", err)
+	}
+
+	up := user.UpdatePassword{Current: nu.Password, Password: "changed", PasswordConfirm: "changed"}
+	if err := user.ChangePassword(ctx, db, auth.DefaultPasswordPolicy, claims.Subject, up, time.Now()); err != nil {
+		t.Fatalf("could not change password: %v", err)
+	}
+
+	cache := user.NewStatusCache(db, time.Minute)
+	if err := cache.Check(ctx, claims); err != user.ErrTokenRevoked {
+		t.Fatalf("expected token from before the change to be revoked, got %v", err)
+	}
+
+	if _, _, err := user.Refresh(ctx, db, refresh, time.Now()); err != user.ErrInvalidRefreshToken {
+		t.Fatalf("expected refresh token from before the change to be revoked, got %v", err)
+	}
+
+	if _, err := user.Authenticate(ctx, db, auth.DefaultPasswordPolicy, time.Now(), nu.Email, up.Password, ""); err != nil {
+		t.Fatalf("could not authenticate with new password: %v", err)
+	}
+}
+
+func TestTwoFactor(t *testing.T) {
+	db, teardown := databasetest.Setup(t)
+	t.Cleanup(teardown)
+	ctx := context.Background()
+	now := time.Now()
+
+	nu := user.NewUser{
+		Name:            "test",
+		Email:           "2fa@example.com",
+		Roles:           []string{auth.RoleUser},
+		Password:        "secret",
+		PasswordConfirm: "secret",
+	}
+
+	u, err := user.Create(ctx, db, auth.DefaultPasswordPolicy, nu, now)
+	if err != nil {
+		t.Fatalf("could not create user: %v", err)
+	}
+
+	enrollment, err := user.EnrollTOTP(ctx, db, u.ID, "garagesale", now)
+	if err != nil {
+		t.Fatalf("could not enroll: %v", err)
+	}
+	if len(enrollment.RecoveryCodes) == 0 {
+		t.Fatal("expected recovery codes on enrollment")
+	}
+
+	// Each code is generated for a later time step so none is a replay
+	code := func(at time.Time) string {
+		t.Helper()
+		c, err := totp.Code(enrollment.Secret, totp.Step(at))
+		if err != nil {
+			t.Fatalf("could not generate code: %v", err)
+		}
+		return c
+	}
+	step := func(i int) time.Time {
+		return now.Add(time.Duration(i) * 30 * time.Second)
+	}
+
+	if err := user.ConfirmTOTP(ctx, db, u.ID, "wrong", now); err != user.ErrInvalidCode {
+		t.Fatalf("expected %v confirming a wrong code, got %v", user.ErrInvalidCode, err)
+	}
+	if err := user.ConfirmTOTP(ctx, db, u.ID, code(step(0)), step(0)); err != nil {
+		t.Fatalf("could not confirm: %v", err)
+	}
+	if _, err := user.EnrollTOTP(ctx, db, u.ID, "garagesale", now); err != user.ErrTwoFactorEnabled {
+		t.Fatalf("expected %v enrolling twice, got %v", user.ErrTwoFactorEnabled, err)
+	}
+
+	challenge, err := user.Authenticate(ctx, db, auth.DefaultPasswordPolicy, step(1), nu.Email, nu.Password, "")
+	if err != nil {
+		t.Fatalf("could not authenticate: %v", err)
+	}
+	if challenge.Purpose != auth.PurposeTwoFactor {
+		t.Fatalf("expected a challenge, got %+v", challenge)
+	}
+
+	forged := auth.NewClaims(u.ID, nil, step(1), time.Minute)
+	forged.Purpose = auth.PurposeTwoFactor
+	if _, err := user.CompleteTwoFactor(ctx, db, forged, code(step(1)), step(1)); err != user.ErrInvalidChallenge {
+		t.Fatalf("expected %v for a challenge that was not issued, got %v", user.ErrInvalidChallenge, err)
+	}
+
+	// A challenge is used up after a number of wrong codes
+	for i := 0; ; i++ {
+		_, err := user.CompleteTwoFactor(ctx, db, challenge, "wrong", step(1))
+		if err == user.ErrInvalidChallenge {
+			break
+		}
+		if err != user.ErrInvalidCode || i > 20 {
+			t.Fatalf("expected %v after too many wrong codes, got %v", user.ErrInvalidChallenge, err)
+		}
+	}
+
+	challenge, err = user.Authenticate(ctx, db, auth.DefaultPasswordPolicy, step(2), nu.Email, nu.Password, "")
+	if err != nil {
+		t.Fatalf("could not authenticate: %v", err)
+	}
+
+	claims, err := user.CompleteTwoFactor(ctx, db, challenge, code(step(2)), step(2))
+	if err != nil {
+		t.Fatalf("could not complete challenge: %v", err)
+	}
+	if claims.Subject != u.ID || claims.Purpose != "" || !claims.HasRoles(auth.RoleUser) {
+		t.Fatalf("unexpected claims after challenge: %+v", claims)
+	}
+
+	if _, err := user.CompleteTwoFactor(ctx, db, challenge, code(step(3)), step(3)); err != user.ErrInvalidChallenge {
+		t.Fatalf("expected %v answering a challenge twice, got %v", user.ErrInvalidChallenge, err)
+	}
+
+	// Logging in again does not reset the attempts counted for the user
+	for i := 0; ; i++ {
+		challenge, err = user.Authenticate(ctx, db, auth.DefaultPasswordPolicy, step(3), nu.Email, nu.Password, "")
+		if err != nil {
+			t.Fatalf("could not authenticate: %v", err)
+		}
+
+		_, err := user.CompleteTwoFactor(ctx, db, challenge, "wrong", step(3))
+		if err == user.ErrTooManyAttempts {
+			break
+		}
+		if err != user.ErrInvalidCode || i > 20 {
+			t.Fatalf("expected %v for a user trying too many codes, got %v", user.ErrTooManyAttempts, err)
+		}
+	}
+
+	if err := user.DisableTOTP(ctx, db, u.ID, "wrong", step(4)); err != user.ErrInvalidCode {
+		t.Fatalf("expected %v disabling with a wrong code, got %v", user.ErrInvalidCode, err)
+	}
+	if err := user.DisableTOTP(ctx, db, u.ID, enrollment.RecoveryCodes[0], step(4)); err != nil {
+		t.Fatalf("could not disable with a recovery code: %v", err)
+	}
+
+	claims, err = user.Authenticate(ctx, db, auth.DefaultPasswordPolicy, step(5), nu.Email, nu.Password, "")
+	if err != nil {
+		t.Fatalf("could not authenticate: %v", err)
+	}
+	if claims.Purpose != "" {
+		t.Fatalf("expected no challenge once two-factor is disabled, got %+v", claims)
+	}
+}
+
+func TestRefreshToken(t *testing.T) {
+	db, teardown := databasetest.Setup(t)
+	t.Cleanup(teardown)
+	ctx := context.Background()
+
+	nu := user.NewUser{
+		Name:            "test",
+		Email:           "refresh@example.com",
+		Roles:           []string{auth.RoleUser},
+		Password:        "secret",
+		PasswordConfirm: "secret",
+	}
+
+	if _, err := user.Create(ctx, db, auth.DefaultPasswordPolicy, nu, time.Now()); err != nil {
+		t.Fatalf("could not create user: %v", err)
+	}
+
+	claims, err := user.Authenticate(ctx, db, auth.DefaultPasswordPolicy, time.Now(), nu.Email, nu.Password, "")
+	if err != nil {
+		t.Fatalf("could not authenticate: %v", err)
+	}
+
+	first, err := user.IssueRefreshToken(ctx, db, claims, time.Now())
+	if err != nil {
+		t.Fatalf("could not issue refresh token: %v", err)
+	}
+
+	refreshed, second, err := user.Refresh(ctx, db, first, time.Now())
+	if err != nil {
+		t.Fatalf("could not refresh: %v", err)
+	}
+	if refreshed.Subject != claims.Subject || second == first {
+		t.Fatalf("expected new token for %q, got %+v", claims.Subject, refreshed)
+	}
+
+	if _, _, err := user.Refresh(ctx, db, first, time.Now()); err != user.ErrRefreshTokenReused {
+		t.Fatalf("expected %v, got %v", user.ErrRefreshTokenReused, err)
+	}
+
+	if _, _, err := user.Refresh(ctx, db, second, time.Now()); err != user.ErrInvalidRefreshToken {
+		t.Fatalf("expected family to be revoked after reuse, got %v", err)
+	}
+
+	third, err := user.IssueRefreshToken(ctx, db, claims, time.Now())
+	if err != nil {
+		t.Fatalf("could not issue refresh token: %v", err)
+	}
+
+	if err := user.Logout(ctx, db, third, time.Now()); err != nil {
+		t.Fatalf("could not log out: %v", err)
+	}
+
+	if _, _, err := user.Refresh(ctx, db, third, time.Now()); err != user.ErrInvalidRefreshToken {
+		t.Fatalf("expected %v after logout, got %v", user.ErrInvalidRefreshToken, err)
+	}
+}
+
+func TestAuthenticateExternal(t *testing.T) {
+	db, teardown := databasetest.Setup(t)
+	t.Cleanup(teardown)
+	ctx := context.Background()
+
+	id := auth.ExternalIdentity{
+		Issuer:    "https://sso.example.com",
+		Subject:   "idp-user",
+		Email:     "sso@example.com",
+		Roles:     []string{auth.RoleUser},
+		TokenHash: "first-token",
+		ExpiresAt: time.Now().Add(time.Hour).Unix(),
+	}
+
+	claims, err := user.AuthenticateExternal(ctx, db, id, "", time.Now())
+	if err != nil {
+		t.Fatalf("could not provision user: %v", err)
+	}
+
+	if !claims.HasRoles(auth.RoleUser) || claims.Id == "" || claims.ExpiresAt != id.ExpiresAt {
+		t.Fatalf("unexpected claims: %+v", claims)
+	}
+
+	// The same token keeps its ID and roles
+	id.Roles = []string{auth.RoleAdmin}
+	same, err := user.AuthenticateExternal(ctx, db, id, "", time.Now())
+	if err != nil {
+		t.Fatalf("could not authenticate provisioned user: %v", err)
+	}
+
+	if same.Id != claims.Id || !same.HasRoles(auth.RoleUser) {
+		t.Fatalf("expected claims of the first request for the same token, got %+v", same)
+	}
+
+	// A new login of the user follows the roles of the provider
+	id.TokenHash = "second-token"
+	again, err := user.AuthenticateExternal(ctx, db, id, "", time.Now())
+	if err != nil {
+		t.Fatalf("could not authenticate provisioned user: %v", err)
+	}
+
+	if again.Subject != claims.Subject || again.Id == claims.Id || !again.HasRoles(auth.RoleAdmin) || again.HasRoles(auth.RoleUser) {
+		t.Fatalf("expected same user with updated roles, got %+v", again)
+	}
+
+	// Revoking the tokens of the user revokes those of the provider too
+	if err := user.RevokeTokens(ctx, db, "", again.Subject, time.Now()); err != nil {
+		t.Fatalf("could not revoke tokens: %v", err)
+	}
+
+	revoked, err := user.AuthenticateExternal(ctx, db, id, "", time.Now())
+	if err != nil {
+		t.Fatalf("could not authenticate provisioned user: %v", err)
+	}
+
+	cache := user.NewStatusCache(db, time.Minute)
+	if err := cache.Check(ctx, revoked); err != user.ErrTokenRevoked {
+		t.Fatalf("expected %v for a token of the provider seen before revoking, got %v", user.ErrTokenRevoked, err)
+	}
+
+	if _, err := user.Authenticate(ctx, db, auth.DefaultPasswordPolicy, time.Now(), id.Email, "", ""); err != user.ErrAuthenticationFailure {
+		t.Fatalf("expected provisioned user to have no password, got %v", err)
+	}
+
+	// A local user with the email of a new identity is not taken over
+	nu := user.NewUser{
+		Name:            "local",
+		Email:           "local@example.com",
+		Roles:           []string{auth.RoleUser},
+		Password:        "secret",
+		PasswordConfirm: "secret",
+	}
+	if _, err := user.Create(ctx, db, auth.DefaultPasswordPolicy, nu, time.Now()); err != nil {
+		t.Fatalf("could not create local user: %v", err)
+	}
+
+	id.Subject = "idp-local"
+	id.Email = nu.Email
+	id.TokenHash = "local-token"
+	if _, err := user.AuthenticateExternal(ctx, db, id, "", time.Now()); err != user.ErrEmailTaken {
+		t.Fatalf("expected %v for the email of a local user, got %v", user.ErrEmailTaken, err)
+	}
+}
+
+func TestImpersonate(t *testing.T) {
+	db, teardown := databasetest.Setup(t)
+	t.Cleanup(teardown)
+	ctx := context.Background()
+
+	nu := user.NewUser{
+		Name:            "target",
+		Email:           "target@example.com",
+		Roles:           []string{auth.RoleUser},
+		Password:        "secret",
+		PasswordConfirm: "secret",
+	}
+
+	target, err := user.Create(ctx, db, auth.DefaultPasswordPolicy, nu, time.Now())
+	if err != nil {
+		t.Fatalf("could not create user: %v", err)
+	}
+
+	admin := auth.NewClaims("5cf37266-3473-4006-984f-9325122678b7", []string{auth.RoleAdmin}, time.Now(), time.Hour)
+	admin.OrgID = organization.DefaultID
+
+	if _, err := user.Impersonate(ctx, db, admin, target.ID, time.Now()); err != user.ErrCannotImpersonate {
+		t.Fatalf("expected %v without permission, got %v", user.ErrCannotImpersonate, err)
+	}
+
+	admin.Permissions = []string{auth.PermUserImpersonate}
+
+	claims, err := user.Impersonate(ctx, db, admin, target.ID, time.Now())
+	if err != nil {
+		t.Fatalf("could not impersonate: %v", err)
+	}
+
+	if claims.Subject != target.ID || !claims.Impersonated() || claims.Actor.Subject != admin.Subject {
+		t.Fatalf("expected claims of %q acted on by %q, got %+v", target.ID, admin.Subject, claims)
+	}
+
+	if _, err := user.Impersonate(ctx, db, claims, target.ID, time.Now()); err != user.ErrCannotImpersonate {
+		t.Fatalf("expected %v for impersonated session, got %v", user.ErrCannotImpersonate, err)
+	}
+}
+
+func TestAuthenticateCertificate(t *testing.T) {
+	db, teardown := databasetest.Setup(t)
+	t.Cleanup(teardown)
+	ctx := context.Background()
+
+	nu := user.NewUser{
+		Name:            "scanner",
+		Email:           "scanner-01@example.com",
+		Roles:           []string{auth.RoleUser},
+		Password:        "secret",
+		PasswordConfirm: "secret",
+	}
+
+	u, err := user.Create(ctx, db, auth.DefaultPasswordPolicy, nu, time.Now())
+	if err != nil {
+		t.Fatalf("could not create user: %v", err)
+	}
+
+	cert := x509.Certificate{
+		Subject:  pkix.Name{CommonName: "scanner-01", OrganizationalUnit: []string{"Warehouse"}},
+		NotAfter: time.Now().Add(time.Hour),
+	}
+
+	if _, err := user.AuthenticateCertificate(ctx, db, &cert, false, time.Now()); err != user.ErrCertificateNotRegistered {
+		t.Fatalf("expected %v before registering, got %v", user.ErrCertificateNotRegistered, err)
+	}
+
+	subject := auth.CertificateSubject(&cert)
+	if err := user.RegisterCertificate(ctx, db, subject, u.ID, "", time.Now()); err != nil {
+		t.Fatalf("could not register certificate: %v", err)
+	}
+
+	if err := user.RegisterCertificate(ctx, db, subject, u.ID, "", time.Now()); err != user.ErrCertificateTaken {
+		t.Fatalf("expected %v registering twice, got %v", user.ErrCertificateTaken, err)
+	}
+
+	claims, err := user.AuthenticateCertificate(ctx, db, &cert, false, time.Now())
+	if err != nil {
+		t.Fatalf("could not authenticate certificate: %v", err)
+	}
+
+	if claims.Subject != u.ID || claims.OrgID != organization.DefaultID || claims.ExpiresAt != cert.NotAfter.Unix() {
+		t.Fatalf("unexpected claims for certificate: %+v", claims)
+	}
+
+	if err := user.SetStatus(ctx, db, organization.DefaultID, u.ID, user.StatusDisabled, time.Now()); err != nil {
+		t.Fatalf("could not disable user: %v", err)
+	}
+
+	if _, err := user.AuthenticateCertificate(ctx, db, &cert, false, time.Now()); err != user.ErrDisabled {
+		t.Fatalf("expected %v for disabled user, got %v", user.ErrDisabled, err)
+	}
+
+	// Admins without two-factor authentication are restricted like at login
+	nu.Email = "scanner-02@example.com"
+	nu.Roles = []string{auth.RoleAdmin, auth.RoleUser}
+	admin, err := user.Create(ctx, db, auth.DefaultPasswordPolicy, nu, time.Now())
+	if err != nil {
+		t.Fatalf("could not create admin: %v", err)
+	}
+
+	adminCert := x509.Certificate{
+		Subject:  pkix.Name{CommonName: "scanner-02"},
+		NotAfter: time.Now().Add(time.Hour),
+	}
+	if err := user.RegisterCertificate(ctx, db, auth.CertificateSubject(&adminCert), admin.ID, "", time.Now()); err != nil {
+		t.Fatalf("could not register certificate: %v", err)
+	}
+
+	claims, err = user.AuthenticateCertificate(ctx, db, &adminCert, false, time.Now())
+	if err != nil {
+		t.Fatalf("could not authenticate certificate: %v", err)
+	}
+
+	if !claims.HasRoles(auth.RoleAdmin) || !claims.HasPermission(auth.PermUserImpersonate) {
+		t.Fatalf("expected admin claims, got %+v", claims)
+	}
+
+	claims, err = user.AuthenticateCertificate(ctx, db, &adminCert, true, time.Now())
+	if err != nil {
+		t.Fatalf("could not authenticate certificate: %v", err)
+	}
+
+	if claims.HasRoles(auth.RoleAdmin) || claims.HasPermission(auth.PermUserImpersonate) || !claims.HasRoles(auth.RoleUser) {
+		t.Fatalf("expected admin role to be removed without two-factor, got %+v", claims)
+	}
+}
@@ -0,0 +1,295 @@
+package web
+
+import (
+	"database/sql/driver"
+	"encoding"
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// csvColumn is a field of a struct written as a CSV column
+type csvColumn struct {
+	name  string
+	index []int
+}
+
+// csvColumns returns the columns of a struct type named after the JSON names
+// of its fields. Fields of embedded structs become columns of their own.
+func csvColumns(t reflect.Type) []csvColumn {
+	var cols []csvColumn
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+
+		if f.Anonymous && f.Type.Kind() == reflect.Struct {
+			for _, c := range csvColumns(f.Type) {
+				c.index = append([]int{i}, c.index...)
+				cols = append(cols, c)
+			}
+			continue
+		}
+
+		if f.PkgPath != "" {
+			continue
+		}
+
+		name := strings.SplitN(f.Tag.Get("json"), ",", 2)[0]
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+
+		cols = append(cols, csvColumn{name: name, index: []int{i}})
+	}
+
+	return cols
+}
+
+// csvRows returns the struct values of val and their type. val can be a
+// struct or a slice of structs, or pointers to them.
+func csvRows(val interface{}) ([]reflect.Value, reflect.Type, bool) {
+	v := reflect.ValueOf(val)
+	for v.Kind() == reflect.Ptr && !v.IsNil() {
+		v = v.Elem()
+	}
+
+	switch v.Kind() {
+	case reflect.Struct:
+		return []reflect.Value{v}, v.Type(), true
+
+	case reflect.Slice, reflect.Array:
+		t := v.Type().Elem()
+		for t.Kind() == reflect.Ptr {
+			t = t.Elem()
+		}
+		if t.Kind() != reflect.Struct {
+			return nil, nil, false
+		}
+
+		rows := make([]reflect.Value, 0, v.Len())
+		for i := 0; i < v.Len(); i++ {
+			e := v.Index(i)
+			for e.Kind() == reflect.Ptr && !e.IsNil() {
+				e = e.Elem()
+			}
+			rows = append(rows, e)
+		}
+		return rows, t, true
+	}
+
+	return nil, nil, false
+}
+
+// encodeCSV writes a header of column names followed by one record per struct.
+// Other values are refused with ErrNotAcceptable.
+func encodeCSV(w io.Writer, val interface{}) error {
+	rows, t, ok := csvRows(val)
+	if !ok {
+		return errors.Wrap(ErrNotAcceptable, "only lists and objects can be sent as CSV")
+	}
+
+	cols := csvColumns(t)
+	cw := csv.NewWriter(w)
+
+	record := make([]string, len(cols))
+	for i, c := range cols {
+		record[i] = c.name
+	}
+	if err := cw.Write(record); err != nil {
+		return err
+	}
+
+	for _, row := range rows {
+		for i, c := range cols {
+			s, err := csvValue(fieldByIndex(row, c.index))
+			if err != nil {
+				return errors.Wrapf(err, "encoding column %s", c.name)
+			}
+			record[i] = s
+		}
+
+		if err := cw.Write(record); err != nil {
+			return err
+		}
+	}
+
+	cw.Flush()
+	return cw.Error()
+}
+
+// fieldByIndex is reflect.Value.FieldByIndex that gives the zero value for
+// fields of nil embedded pointers instead of panicking
+func fieldByIndex(v reflect.Value, index []int) reflect.Value {
+	for _, i := range index {
+		if v.Kind() == reflect.Ptr {
+			if v.IsNil() {
+				return reflect.Value{}
+			}
+			v = v.Elem()
+		}
+		v = v.Field(i)
+	}
+
+	return v
+}
+
+// csvValue formats a field. Values that have no text form of their own, such
+// as lists, are written as JSON.
+func csvValue(v reflect.Value) (string, error) {
+	if !v.IsValid() {
+		return "", nil
+	}
+
+	for v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return "", nil
+		}
+		v = v.Elem()
+	}
+
+	switch x := v.Interface().(type) {
+	case time.Time:
+		return x.Format(time.RFC3339Nano), nil
+	case driver.Valuer:
+		dv, err := x.Value()
+		if err != nil {
+			return "", err
+		}
+		return csvValue(reflect.ValueOf(dv))
+	case encoding.TextMarshaler:
+		b, err := x.MarshalText()
+		return string(b), err
+	}
+
+	switch v.Kind() {
+	case reflect.String:
+		return v.String(), nil
+	case reflect.Bool:
+		return strconv.FormatBool(v.Bool()), nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.FormatInt(v.Int(), 10), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return strconv.FormatUint(v.Uint(), 10), nil
+	case reflect.Float32, reflect.Float64:
+		return strconv.FormatFloat(v.Float(), 'f', -1, v.Type().Bits()), nil
+	}
+
+	b, err := json.Marshal(v.Interface())
+	return string(b), err
+}
+
+// decodeCSV reads a header of column names and the records below it into a
+// struct, which takes exactly one record, or into a slice of structs
+func decodeCSV(r io.Reader, val interface{}) error {
+	v := reflect.ValueOf(val)
+	if v.Kind() != reflect.Ptr || v.IsNil() {
+		return errors.New("CSV can only be decoded into a pointer")
+	}
+	v = v.Elem()
+
+	records, err := csv.NewReader(r).ReadAll()
+	if err != nil {
+		return err
+	}
+	if len(records) == 0 {
+		return errors.New("CSV document has no header")
+	}
+	header, records := records[0], records[1:]
+
+	switch {
+	case v.Kind() == reflect.Struct:
+		if len(records) != 1 {
+			return errors.Errorf("expected one CSV record, got %d", len(records))
+		}
+		return decodeCSVRecord(header, records[0], v)
+
+	case v.Kind() == reflect.Slice && v.Type().Elem().Kind() == reflect.Struct:
+		list := reflect.MakeSlice(v.Type(), len(records), len(records))
+		for i, record := range records {
+			if err := decodeCSVRecord(header, record, list.Index(i)); err != nil {
+				return errors.Wrapf(err, "record %d", i+1)
+			}
+		}
+		v.Set(list)
+		return nil
+	}
+
+	return errors.Errorf("CSV cannot be decoded into %s", v.Type())
+}
+
+// decodeCSVRecord sets the fields of the struct v named in header
+func decodeCSVRecord(header, record []string, v reflect.Value) error {
+	cols := make(map[string][]int)
+	for _, c := range csvColumns(v.Type()) {
+		cols[c.name] = c.index
+	}
+
+	for i, name := range header {
+		index, ok := cols[name]
+		if !ok {
+			return errors.Errorf("unknown field %q", name)
+		}
+
+		if err := setCSVValue(v.FieldByIndex(index), record[i]); err != nil {
+			return errors.Wrapf(err, "field %q", name)
+		}
+	}
+
+	return nil
+}
+
+// setCSVValue parses s into the field f. Empty values leave pointers nil.
+func setCSVValue(f reflect.Value, s string) error {
+	if f.Kind() == reflect.Ptr {
+		if s == "" {
+			return nil
+		}
+		f.Set(reflect.New(f.Type().Elem()))
+		f = f.Elem()
+	}
+
+	if u, ok := f.Addr().Interface().(encoding.TextUnmarshaler); ok {
+		return u.UnmarshalText([]byte(s))
+	}
+
+	switch f.Kind() {
+	case reflect.String:
+		f.SetString(s)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return err
+		}
+		f.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(s, 10, f.Type().Bits())
+		if err != nil {
+			return err
+		}
+		f.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n, err := strconv.ParseUint(s, 10, f.Type().Bits())
+		if err != nil {
+			return err
+		}
+		f.SetUint(n)
+	case reflect.Float32, reflect.Float64:
+		n, err := strconv.ParseFloat(s, f.Type().Bits())
+		if err != nil {
+			return err
+		}
+		f.SetFloat(n)
+	default:
+		return json.Unmarshal([]byte(s), f.Addr().Interface())
+	}
+
+	return nil
+}
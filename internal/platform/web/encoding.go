@@ -0,0 +1,212 @@
+package web
+
+import (
+	"encoding/json"
+	"io"
+	"mime"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+// Predefined errors for content negotiation
+var (
+	ErrNotAcceptable        = errors.New("none of the accepted media types can be produced")
+	ErrUnsupportedMediaType = errors.New("unsupported content type")
+)
+
+// EncodeFunc writes val to w in the media type it is registered for. Values
+// the media type cannot represent are refused with ErrNotAcceptable before
+// anything is written; they are sent as JSON instead.
+type EncodeFunc func(w io.Writer, val interface{}) error
+
+// DecodeFunc reads a document in the media type it is registered for from r
+// and stores it in val
+type DecodeFunc func(r io.Reader, val interface{}) error
+
+// encoder is an EncodeFunc registered for a media type
+type encoder struct {
+	mediaType   string
+	contentType string
+	encode      EncodeFunc
+}
+
+// codecs holds the registered encoders in the order they are preferred when
+// clients accept several, and the decoders by media type
+var codecs = struct {
+	sync.RWMutex
+	encoders []encoder
+	decoders map[string]DecodeFunc
+}{
+	decoders: make(map[string]DecodeFunc),
+}
+
+func init() {
+	RegisterEncoder("application/json", "application/json; charset=utf-8", encodeJSON)
+	RegisterEncoder("text/csv", "text/csv; charset=utf-8", encodeCSV)
+	RegisterEncoder("application/xml", "application/xml; charset=utf-8", encodeXML)
+	RegisterEncoder("application/msgpack", "application/msgpack", encodeMsgpack)
+	RegisterEncoder(NDJSONContentType, NDJSONContentType, encodeNDJSON)
+
+	RegisterDecoder("application/json", decodeJSON)
+	RegisterDecoder("text/csv", decodeCSV)
+	RegisterDecoder("application/xml", decodeXML)
+	RegisterDecoder("application/msgpack", decodeMsgpack)
+}
+
+// RegisterEncoder makes responses available as mediaType, sent with the
+// contentType header. Registering a media type again replaces its encoder.
+// Encoders registered first are preferred when clients accept several.
+func RegisterEncoder(mediaType, contentType string, f EncodeFunc) {
+	codecs.Lock()
+	defer codecs.Unlock()
+
+	e := encoder{mediaType: mediaType, contentType: contentType, encode: f}
+	for i := range codecs.encoders {
+		if codecs.encoders[i].mediaType == mediaType {
+			codecs.encoders[i] = e
+			return
+		}
+	}
+
+	codecs.encoders = append(codecs.encoders, e)
+}
+
+// RegisterDecoder makes Decode read request bodies of mediaType with f
+func RegisterDecoder(mediaType string, f DecodeFunc) {
+	codecs.Lock()
+	defer codecs.Unlock()
+
+	codecs.decoders[mediaType] = f
+}
+
+// mediaRange is one entry of an Accept header
+type mediaRange struct {
+	mediaType string
+	q         float64
+}
+
+// matches reports whether the media range covers mediaType
+func (m mediaRange) matches(mediaType string) bool {
+	if m.mediaType == "*/*" || m.mediaType == mediaType {
+		return true
+	}
+
+	prefix := strings.TrimSuffix(m.mediaType, "*")
+	return prefix != m.mediaType && strings.HasPrefix(mediaType, prefix)
+}
+
+// negotiate picks the encoder for the Accept header of r. Requests without
+// one get JSON. Problem documents are only used for errors, so accepting
+// them is the same as not saying anything.
+func negotiate(r *http.Request) (encoder, error) {
+	var ranges []mediaRange
+	for _, header := range r.Header.Values("Accept") {
+		for _, part := range strings.Split(header, ",") {
+			name, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+			if err != nil || name == ProblemContentType {
+				continue
+			}
+
+			q := 1.0
+			if v, ok := params["q"]; ok {
+				if q, err = strconv.ParseFloat(v, 64); err != nil {
+					continue
+				}
+			}
+
+			ranges = append(ranges, mediaRange{mediaType: name, q: q})
+		}
+	}
+
+	codecs.RLock()
+	defer codecs.RUnlock()
+
+	if len(ranges) == 0 {
+		return codecs.encoders[0], nil
+	}
+
+	sort.SliceStable(ranges, func(i, j int) bool { return ranges[i].q > ranges[j].q })
+
+	for _, m := range ranges {
+		if m.q <= 0 {
+			break
+		}
+
+		for _, e := range codecs.encoders {
+			if m.matches(e.mediaType) && !excluded(ranges, e.mediaType) {
+				return e, nil
+			}
+		}
+	}
+
+	return encoder{}, ErrNotAcceptable
+}
+
+// excluded reports whether mediaType is explicitly refused with a quality of 0
+func excluded(ranges []mediaRange, mediaType string) bool {
+	for _, m := range ranges {
+		if m.q <= 0 && m.mediaType == mediaType {
+			return true
+		}
+	}
+
+	return false
+}
+
+// decoder returns the decoder for the Content-Type of r. Requests without
+// one are read as JSON.
+func decoder(r *http.Request) (DecodeFunc, error) {
+	mediaType := "application/json"
+	if ct := r.Header.Get("Content-Type"); ct != "" {
+		var err error
+		if mediaType, _, err = mime.ParseMediaType(ct); err != nil {
+			return nil, ErrUnsupportedMediaType
+		}
+	}
+
+	codecs.RLock()
+	defer codecs.RUnlock()
+
+	f, ok := codecs.decoders[mediaType]
+	if !ok {
+		return nil, ErrUnsupportedMediaType
+	}
+
+	return f, nil
+}
+
+func encodeJSON(w io.Writer, val interface{}) error {
+	data, err := json.Marshal(val)
+	if err != nil {
+		return err
+	}
+
+	_, err = w.Write(data)
+	return err
+}
+
+func decodeJSON(r io.Reader, val interface{}) error {
+	decoder := json.NewDecoder(r)
+	decoder.DisallowUnknownFields()
+	return decoder.Decode(val)
+}
+
+// encodeMsgpack uses the JSON names of fields as keys
+func encodeMsgpack(w io.Writer, val interface{}) error {
+	enc := msgpack.NewEncoder(w)
+	enc.SetCustomStructTag("json")
+	return enc.Encode(val)
+}
+
+func decodeMsgpack(r io.Reader, val interface{}) error {
+	dec := msgpack.NewDecoder(r)
+	dec.SetCustomStructTag("json")
+	dec.DisallowUnknownFields(true)
+	return dec.Decode(val)
+}
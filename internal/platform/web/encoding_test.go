@@ -0,0 +1,246 @@
+package web_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"garagesale/internal/middleware"
+	"garagesale/internal/platform/web"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+type item struct {
+	ID      int        `json:"id"`
+	Name    string     `json:"name" validate:"required"`
+	Tags    []string   `json:"tags"`
+	Sold    *time.Time `json:"sold"`
+	Comment string     `json:"-"`
+}
+
+func TestNegotiation(t *testing.T) {
+	sold := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	items := []item{
+		{ID: 1, Name: "comic, used", Tags: []string{"a", "b"}, Sold: &sold},
+		{ID: 2, Name: "hat"},
+	}
+
+	discard := log.New(ioutil.Discard, "", 0)
+	app := web.NewApp(discard, middleware.Errors(discard))
+	app.Handle(http.MethodGet, "/items", func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+		return web.Respond(ctx, w, items, http.StatusOK)
+	})
+	app.Handle(http.MethodGet, "/count", func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+		return web.Respond(ctx, w, map[string]int{"count": len(items)}, http.StatusOK)
+	})
+
+	tests := []struct {
+		path        string
+		accept      string
+		status      int
+		contentType string
+		body        string
+	}{
+		{"/items", "", http.StatusOK, "application/json; charset=utf-8", ""},
+		{"/items", "text/html, */*;q=0.1", http.StatusOK, "application/json; charset=utf-8", ""},
+		{
+			"/items", "text/csv", http.StatusOK, "text/csv; charset=utf-8",
+			"id,name,tags,sold\n1,\"comic, used\",\"[\"\"a\"\",\"\"b\"\"]\",2020-01-02T03:04:05Z\n2,hat,null,\n",
+		},
+		{
+			"/items", "application/json;q=0.5, application/xml", http.StatusOK, "application/xml; charset=utf-8",
+			`<?xml version="1.0" encoding="UTF-8"?>` + "\n" +
+				"<response><item><id>1</id><name>comic, used</name><tags>a</tags><tags>b</tags><sold>2020-01-02T03:04:05Z</sold></item>" +
+				"<item><id>2</id><name>hat</name></item></response>",
+		},
+		{"/items", "application/msgpack", http.StatusOK, "application/msgpack", ""},
+		{"/items", "image/png", http.StatusNotAcceptable, "application/json; charset=utf-8", ""},
+		{"/items", "text/*, application/json;q=0", http.StatusOK, "text/csv; charset=utf-8", ""},
+		{"/count", "text/csv", http.StatusOK, "application/json; charset=utf-8", `{"count":2}`},
+	}
+
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+		if tt.accept != "" {
+			req.Header.Set("Accept", tt.accept)
+		}
+
+		resp := httptest.NewRecorder()
+		app.ServeHTTP(resp, req)
+
+		if resp.Code != tt.status {
+			t.Errorf("%s %q: expected status %d, got %d", tt.path, tt.accept, tt.status, resp.Code)
+		}
+
+		if got := resp.Header().Get("Content-Type"); got != tt.contentType {
+			t.Errorf("%s %q: expected content type %q, got %q", tt.path, tt.accept, tt.contentType, got)
+		}
+
+		if tt.body != "" && resp.Body.String() != tt.body {
+			t.Errorf("%s %q: unexpected body:\n%s", tt.path, tt.accept, resp.Body.String())
+		}
+
+		if tt.contentType == "application/msgpack" {
+			// msgpack keys follow the JSON names of the fields
+			var got []map[string]interface{}
+			if err := msgpack.Unmarshal(resp.Body.Bytes(), &got); err != nil {
+				t.Fatalf("decoding msgpack: %v", err)
+			}
+
+			if len(got) != 2 || got[0]["name"] != items[0].Name || got[1]["id"] != int8(2) {
+				t.Errorf("unexpected msgpack body: %v", got)
+			}
+		}
+	}
+}
+
+func TestDecode(t *testing.T) {
+	msgpackBody, err := msgpack.Marshal(map[string]interface{}{"id": 3, "name": "lamp"})
+	if err != nil {
+		t.Fatalf("encoding msgpack: %v", err)
+	}
+
+	tests := []struct {
+		contentType string
+		body        []byte
+		status      int
+		want        item
+	}{
+		{"", []byte(`{"id": 1, "name": "hat"}`), 0, item{ID: 1, Name: "hat"}},
+		{"application/json; charset=utf-8", []byte(`{"id": 1, "name": "hat"}`), 0, item{ID: 1, Name: "hat"}},
+		{"text/csv", []byte("id,name,tags\n2,\"comic, used\",\"[\"\"a\"\"]\"\n"), 0, item{ID: 2, Name: "comic, used", Tags: []string{"a"}}},
+		{"application/xml", []byte(`<item><id>4</id><name>rug</name><tags>a</tags><tags>b</tags></item>`), 0, item{ID: 4, Name: "rug", Tags: []string{"a", "b"}}},
+		{"application/xml", []byte(`<item><id>4</id><name>rug</name><Comment>hidden</Comment></item>`), http.StatusBadRequest, item{}},
+		{"application/msgpack", msgpackBody, 0, item{ID: 3, Name: "lamp"}},
+		{"text/csv", []byte("id,price\n2,10\n"), http.StatusBadRequest, item{}},
+		{"text/csv", []byte("id,name\n2,\n"), http.StatusBadRequest, item{}},
+		{"text/plain", []byte(`hat`), http.StatusUnsupportedMediaType, item{}},
+	}
+
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodPost, "/items", bytes.NewReader(tt.body))
+		if tt.contentType != "" {
+			req.Header.Set("Content-Type", tt.contentType)
+		}
+
+		var got item
+		err := web.Decode(req.Context(), req, &got)
+
+		if tt.status != 0 {
+			webErr, ok := err.(*web.Error)
+			if !ok || webErr.Status != tt.status {
+				t.Errorf("%q: expected status %d, got %v", tt.contentType, tt.status, err)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Fatalf("%q: decoding: %v", tt.contentType, err)
+		}
+
+		if diff := cmp.Diff(tt.want, got); diff != "" {
+			t.Errorf("%q: unexpected value:\n%s", tt.contentType, diff)
+		}
+	}
+}
+
+func TestRegisterEncoder(t *testing.T) {
+	web.RegisterEncoder("text/plain", "text/plain; charset=utf-8", func(w io.Writer, val interface{}) error {
+		return json.NewEncoder(w).Encode(val)
+	})
+
+	discard := log.New(ioutil.Discard, "", 0)
+	app := web.NewApp(discard, middleware.Errors(discard))
+	app.Handle(http.MethodGet, "/name", func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+		return web.Respond(ctx, w, "hat", http.StatusOK)
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/name", nil)
+	req.Header.Set("Accept", "text/plain")
+
+	resp := httptest.NewRecorder()
+	app.ServeHTTP(resp, req)
+
+	if resp.Header().Get("Content-Type") != "text/plain; charset=utf-8" || strings.TrimSpace(resp.Body.String()) != `"hat"` {
+		t.Errorf("expected the registered encoder to be used, got %q %q", resp.Header().Get("Content-Type"), resp.Body.String())
+	}
+}
+
+func TestHiddenFields(t *testing.T) {
+	type account struct {
+		ID           int               `json:"id"`
+		PasswordHash string            `json:"-"`
+		Nickname     string            `json:"nickname,omitempty"`
+		Preferences  map[string]string `json:"preferences"`
+		Groups       [][]int           `json:"groups"`
+	}
+
+	acct := account{
+		ID:           1,
+		PasswordHash: "s3cret-hash",
+		Preferences:  map[string]string{"theme": "dark", "2fa method": "totp"},
+		Groups:       [][]int{{1, 2}, {3}},
+	}
+
+	discard := log.New(ioutil.Discard, "", 0)
+	app := web.NewApp(discard, middleware.Errors(discard))
+	app.Handle(http.MethodGet, "/account", func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+		return web.Respond(ctx, w, acct, http.StatusOK)
+	})
+	app.Handle(http.MethodGet, "/accounts", func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+		return web.Respond(ctx, w, []account{acct}, http.StatusOK)
+	})
+
+	for _, path := range []string{"/account", "/accounts"} {
+		for _, accept := range []string{"application/json", "text/csv", "application/xml", "application/msgpack", web.NDJSONContentType} {
+			req := httptest.NewRequest(http.MethodGet, path, nil)
+			req.Header.Set("Accept", accept)
+
+			resp := httptest.NewRecorder()
+			app.ServeHTTP(resp, req)
+
+			if resp.Code != http.StatusOK {
+				t.Fatalf("%s %s: expected status 200, got %d", path, accept, resp.Code)
+			}
+
+			body := resp.Body.String()
+			if strings.Contains(body, acct.PasswordHash) || strings.Contains(strings.ToLower(body), "passwordhash") {
+				t.Errorf("%s %s: hidden field was sent:\n%s", path, accept, body)
+			}
+
+			// CSV has a column for every field, the others leave empty ones out
+			if accept != "text/csv" && strings.Contains(body, "nickname") {
+				t.Errorf("%s %s: empty field was sent:\n%s", path, accept, body)
+			}
+		}
+	}
+
+	// XML documents are read back by their JSON names
+	req := httptest.NewRequest(http.MethodGet, "/account", nil)
+	req.Header.Set("Accept", "application/xml")
+
+	resp := httptest.NewRecorder()
+	app.ServeHTTP(resp, req)
+
+	post := httptest.NewRequest(http.MethodPost, "/account", resp.Body)
+	post.Header.Set("Content-Type", "application/xml")
+
+	var got account
+	if err := web.Decode(post.Context(), post, &got); err != nil {
+		t.Fatalf("decoding XML: %v", err)
+	}
+
+	acct.PasswordHash = ""
+	if diff := cmp.Diff(acct, got); diff != "" {
+		t.Errorf("unexpected value read from XML:\n%s", diff)
+	}
+}
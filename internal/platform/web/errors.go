@@ -1,19 +1,66 @@
 package web
 
+import (
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
 // FieldError is used to indicate an error with a specific request field
 type FieldError map[string]string
 
 // ErrorResponse how we respond to the clients when something goes wrong
 type ErrorResponse struct {
 	Error      string     `json:"error"`
+	Code       string     `json:"code,omitempty"`
 	FieldError FieldError `json:"fields,omitempty"`
+	TraceID    string     `json:"trace_id,omitempty"`
+}
+
+// ProblemContentType is the media type of Problem responses. Clients opt in to
+// them by accepting it.
+const ProblemContentType = "application/problem+json"
+
+// Problem is an error response in the format of RFC 7807. Code is a stable
+// identifier of the error clients can match on instead of Detail.
+type Problem struct {
+	Type          string         `json:"type"`
+	Title         string         `json:"title"`
+	Status        int            `json:"status"`
+	Detail        string         `json:"detail,omitempty"`
+	Instance      string         `json:"instance,omitempty"`
+	Code          string         `json:"code"`
+	InvalidParams []InvalidParam `json:"invalid_params,omitempty"`
+	TraceID       string         `json:"trace_id,omitempty"`
+}
+
+// InvalidParam describes why a field of a request was rejected. Code names
+// the validation rule the field broke, such as "required".
+type InvalidParam struct {
+	Name   string `json:"name"`
+	Code   string `json:"code"`
+	Reason string `json:"reason"`
 }
 
+// problemTypePrefix is prepended to the code of a Problem to make its type
+const problemTypePrefix = "urn:garagesale:problem:"
+
 // Error is used to add a web information to a request error
 type Error struct {
 	Err        error
 	Status     int
 	FieldError FieldError `json:"fields,omitempty"`
+
+	// Code overrides the code registered for Err
+	Code string
+
+	// InvalidParams holds the broken validation rules of FieldError
+	InvalidParams []InvalidParam
+
+	// locale is the locale FieldError was translated into
+	locale string
 }
 
 // NewRequestError is when a known error condition is encountered
@@ -24,3 +71,60 @@ func NewRequestError(err error, status int) error {
 func (e *Error) Error() string {
 	return e.Err.Error()
 }
+
+// errorCodes holds the stable codes of the known errors of an App
+type errorCodes struct {
+	sync.RWMutex
+	m map[error]string
+}
+
+// RegisterErrorCodes sets the codes reported to clients for known errors of
+// the handlers of the App. Codes are part of the API and must not change once
+// published.
+func (a *App) RegisterErrorCodes(m map[error]string) {
+	a.codes.Lock()
+	defer a.codes.Unlock()
+
+	for err, code := range m {
+		a.codes.m[err] = code
+	}
+}
+
+// code returns the code of a request error: its own code, the code
+// registered for the cause of its error or a code made from its status,
+// such as "not_found".
+func (c *errorCodes) code(e *Error) string {
+	if e.Code != "" {
+		return e.Code
+	}
+
+	c.RLock()
+	code, ok := c.m[errors.Cause(e.Err)]
+	c.RUnlock()
+	if ok {
+		return code
+	}
+
+	return statusCode(e.Status)
+}
+
+// statusCode turns the text of an HTTP status into a code
+func statusCode(status int) string {
+	text := strings.ToLower(http.StatusText(status))
+	text = strings.ReplaceAll(text, "-", " ")
+	return strings.Join(strings.Fields(text), "_")
+}
+
+// newProblem describes a request error as a Problem about the request r
+func newProblem(r *http.Request, e *Error, code, detail, traceID string) Problem {
+	return Problem{
+		Type:          problemTypePrefix + code,
+		Title:         http.StatusText(e.Status),
+		Status:        e.Status,
+		Detail:        detail,
+		Instance:      r.URL.Path,
+		Code:          code,
+		InvalidParams: e.InvalidParams,
+		TraceID:       traceID,
+	}
+}
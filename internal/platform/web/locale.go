@@ -0,0 +1,140 @@
+package web
+
+import (
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	ut "github.com/go-playground/universal-translator"
+)
+
+// defaultMessages are the messages of the error codes every App reports by
+// locale
+var defaultMessages = map[string]map[string]string{
+	"ru": {
+		"validation_failed":      "ошибка проверки полей",
+		"bad_request":            "некорректный запрос",
+		"unauthorized":           "требуется аутентификация",
+		"forbidden":              "доступ запрещён",
+		"not_found":              "ресурс не найден",
+		"method_not_allowed":     "метод не поддерживается",
+		"not_acceptable":         "запрошенный формат ответа не поддерживается",
+		"unsupported_media_type": "формат тела запроса не поддерживается",
+		"internal_server_error":  "внутренняя ошибка сервера",
+	},
+	"es": {
+		"validation_failed":      "error de validación de campos",
+		"bad_request":            "solicitud incorrecta",
+		"unauthorized":           "se requiere autenticación",
+		"forbidden":              "acceso prohibido",
+		"not_found":              "recurso no encontrado",
+		"method_not_allowed":     "método no permitido",
+		"not_acceptable":         "el formato de respuesta solicitado no está disponible",
+		"unsupported_media_type": "el formato del cuerpo de la solicitud no es compatible",
+		"internal_server_error":  "error interno del servidor",
+	},
+}
+
+// catalog holds the messages of the error codes of an App by locale
+type catalog struct {
+	sync.RWMutex
+	m map[string]map[string]string
+}
+
+// newCatalog returns a catalog of the default messages
+func newCatalog() *catalog {
+	c := catalog{m: make(map[string]map[string]string)}
+	for locale, m := range defaultMessages {
+		c.m[locale] = make(map[string]string, len(m))
+		for code, msg := range m {
+			c.m[locale][code] = msg
+		}
+	}
+
+	return &c
+}
+
+// RegisterMessages sets the messages clients accepting locale get for the
+// error codes in m instead of the message of the error, for requests served
+// by the App. Locales are named like "ru"; only those with validation
+// translations are ever negotiated.
+func (a *App) RegisterMessages(locale string, m map[string]string) {
+	a.messages.Lock()
+	defer a.messages.Unlock()
+
+	if a.messages.m[locale] == nil {
+		a.messages.m[locale] = make(map[string]string)
+	}
+
+	for code, msg := range m {
+		a.messages.m[locale][code] = msg
+	}
+}
+
+// message returns the message of code in locale
+func (c *catalog) message(locale, code string) (string, bool) {
+	c.RLock()
+	defer c.RUnlock()
+
+	msg, ok := c.m[locale][code]
+	return msg, ok
+}
+
+// translator returns the translator of the locale the client prefers in its
+// Accept-Language header, English when none of them is supported
+func (v *validation) translator(r *http.Request) ut.Translator {
+	type lang struct {
+		tag string
+		q   float64
+	}
+
+	var langs []lang
+	for _, header := range r.Header.Values("Accept-Language") {
+		for _, part := range strings.Split(header, ",") {
+			fields := strings.Split(part, ";")
+			l := lang{tag: strings.TrimSpace(fields[0]), q: 1}
+
+			for _, param := range fields[1:] {
+				name, value, _ := cut(strings.TrimSpace(param), "=")
+				if name != "q" {
+					continue
+				}
+				if q, err := strconv.ParseFloat(value, 64); err == nil {
+					l.q = q
+				}
+			}
+
+			// A quality of 0 means not acceptable
+			if l.tag == "" || l.tag == "*" || l.q <= 0 {
+				continue
+			}
+			langs = append(langs, l)
+		}
+	}
+	sort.SliceStable(langs, func(i, j int) bool { return langs[i].q > langs[j].q })
+
+	// Locales are named like es_MX, regional variants fall back to the
+	// language itself
+	locales := make([]string, 0, len(langs)*2)
+	for _, l := range langs {
+		locale := strings.ToLower(strings.ReplaceAll(l.tag, "-", "_"))
+		locales = append(locales, locale)
+		if base, _, ok := cut(locale, "_"); ok {
+			locales = append(locales, base)
+		}
+	}
+
+	trans, _ := v.uni.FindTranslator(locales...)
+	return trans
+}
+
+// cut is strings.Cut, which the go version of the module lacks
+func cut(s, sep string) (before, after string, found bool) {
+	if i := strings.Index(s, sep); i >= 0 {
+		return s[:i], s[i+len(sep):], true
+	}
+
+	return s, "", false
+}
@@ -1,74 +1,86 @@
 package web
 
 import (
-	"encoding/json"
-	"errors"
+	"context"
 	"net/http"
-	"reflect"
-	"strings"
 
-	"github.com/go-playground/locales/en"
 	ut "github.com/go-playground/universal-translator"
 	"github.com/go-playground/validator/v10"
 	en_translations "github.com/go-playground/validator/v10/translations/en"
+	es_translations "github.com/go-playground/validator/v10/translations/es"
+	ru_translations "github.com/go-playground/validator/v10/translations/ru"
+	"github.com/pkg/errors"
 )
 
-// use a single instance , it caches struct info
-var (
-	uni      *ut.UniversalTranslator
-	validate *validator.Validate
-	trans    ut.Translator
-)
-
-func init() {
-	en := en.New()
-	uni = ut.New(en, en)
-
-	// this is usually know or extracted from http 'Accept-Language' header
-	// also see uni.FindTranslator(...)
-	trans, _ = uni.GetTranslator("en")
-
-	validate = validator.New()
-	en_translations.RegisterDefaultTranslations(validate, trans)
-
-	// Uses JSON tag names for errors instead of GO struct names
-	validate.RegisterTagNameFunc(func(fld reflect.StructField) string {
-		name := strings.SplitN(fld.Tag.Get("json"), ",", 2)[0]
-
-		if name == "-" {
-			return ""
-		}
-
-		return name
-	})
+// localeTranslations register the messages of the built-in rules of the
+// locales validation errors are translated into
+var localeTranslations = map[string]func(*validator.Validate, ut.Translator) error{
+	"en": en_translations.RegisterDefaultTranslations,
+	"ru": ru_translations.RegisterDefaultTranslations,
+	"es": es_translations.RegisterDefaultTranslations,
 }
 
-// Decode looks for a JSON document in request body and unmarshals it into value
-func Decode(r *http.Request, val interface{}) error {
-	decoder := json.NewDecoder(r.Body)
-	decoder.DisallowUnknownFields()
+// Decode reads the request body in the media type of its Content-Type, JSON
+// by default, into value and validates it. Validation messages are in the
+// locale negotiated from the Accept-Language header. Rules and checks get
+// ctx, the context of the handler; the rules and checks are those registered
+// on the App serving the request.
+func Decode(ctx context.Context, r *http.Request, val interface{}) error {
+	decode, err := decoder(r)
+	if err != nil {
+		return NewRequestError(err, http.StatusUnsupportedMediaType)
+	}
 
-	if err := decoder.Decode(val); err != nil {
+	if err := decode(r.Body, val); err != nil {
 		return NewRequestError(err, http.StatusBadRequest)
 	}
 
-	if err := validate.Struct(val); err != nil {
+	vd := validationFor(ctx)
+	if err := vd.validate.StructCtx(ctx, val); err != nil {
 		verrors, ok := err.(validator.ValidationErrors)
 		if !ok {
 			return err
 		}
 
-		fields := make(FieldError)
+		trans := vd.translator(r)
+		params := make([]InvalidParam, 0, len(verrors))
 		for _, fieldError := range verrors {
-			fields[fieldError.Field()] = fieldError.Translate(trans)
+			params = append(params, InvalidParam{
+				Name:   fieldError.Field(),
+				Code:   fieldError.Tag(),
+				Reason: fieldError.Translate(trans),
+			})
 		}
 
-		return &Error{
-			Err:        errors.New("field validation error"),
-			Status:     http.StatusBadRequest,
-			FieldError: fields,
-		}
+		return validationError(params, trans.Locale())
+	}
+
+	c := checks(ctx)
+	broken, err := c.check(ctx, val)
+	if err != nil {
+		return errors.Wrap(err, "checking request")
+	}
+	if len(broken) > 0 {
+		locale := vd.translator(r).Locale()
+		return validationError(c.params(locale, broken), locale)
 	}
 
 	return nil
 }
+
+// validationError rejects a request whose fields break the rules of params
+func validationError(params []InvalidParam, locale string) error {
+	fields := make(FieldError, len(params))
+	for _, p := range params {
+		fields[p.Name] = p.Reason
+	}
+
+	return &Error{
+		Err:           errors.New("field validation error"),
+		Status:        http.StatusBadRequest,
+		FieldError:    fields,
+		Code:          "validation_failed",
+		InvalidParams: params,
+		locale:        locale,
+	}
+}
@@ -1,58 +1,157 @@
 package web
 
 import (
+	"bytes"
 	"context"
-	"encoding/json"
+	"mime"
 	"net/http"
+	"strconv"
+	"strings"
 
 	"github.com/pkg/errors"
 )
 
-// Respond marshals value to a JSON and send it to the client
+// Respond encodes value in the media type negotiated for the request, JSON
+// by default, and sends it to the client
 func Respond(ctx context.Context, w http.ResponseWriter, val interface{}, statusCode int) error {
 	v, ok := ctx.Value(KeyValues).(*ContexValues)
 	if !ok {
 		return ErrContextValueMissing
 	}
-	v.StatusCode = statusCode
+
+	enc := v.encoder
+	if enc.encode == nil {
+		enc = jsonEncoder
+	}
+
+	return respond(ctx, w, val, statusCode, enc)
+}
+
+// jsonEncoder is used for error responses whatever the client accepts
+var jsonEncoder = encoder{
+	mediaType:   "application/json",
+	contentType: "application/json; charset=utf-8",
+	encode:      encodeJSON,
+}
+
+// respond encodes value with enc and sends it
+func respond(ctx context.Context, w http.ResponseWriter, val interface{}, statusCode int, enc encoder) error {
+	v, ok := ctx.Value(KeyValues).(*ContexValues)
+	if !ok {
+		return ErrContextValueMissing
+	}
 
 	if statusCode == http.StatusNoContent {
+		v.StatusCode = statusCode
 		w.WriteHeader(statusCode)
 		return nil
 	}
 
-	data, err := json.Marshal(val)
+	var buf bytes.Buffer
+	err := enc.encode(&buf, val)
+
+	// The handler has done its work by now, a value the negotiated media
+	// type cannot represent is sent as JSON rather than failing the request
+	if errors.Cause(err) == ErrNotAcceptable {
+		enc = jsonEncoder
+		buf.Reset()
+		err = enc.encode(&buf, val)
+	}
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
 		return errors.Wrap(err, "error marshalling")
 	}
+	v.StatusCode = statusCode
 
-	w.Header().Set("content-type", "application/json; charset=utf-8")
+	w.Header().Set("content-type", enc.contentType)
 	w.WriteHeader(statusCode)
 
-	if _, err := w.Write(data); err != nil {
+	if _, err := w.Write(buf.Bytes()); err != nil {
 		return errors.Wrap(err, "writing to client")
 	}
 
 	return nil
 }
 
-// Respond error knows how to handle errors going out to the client
-func RespondError(ctx context.Context, w http.ResponseWriter, err error) error {
+// Respond error knows how to handle errors going out to the client. Clients
+// accepting application/problem+json get a Problem, everyone else an
+// ErrorResponse.
+func RespondError(ctx context.Context, w http.ResponseWriter, r *http.Request, err error) error {
+	// Once a response has started, such as a stream that failed half way,
+	// the error can only be logged
+	v, ok := ctx.Value(KeyValues).(*ContexValues)
+	if !ok {
+		return ErrContextValueMissing
+	}
+	if v.StatusCode != 0 {
+		return nil
+	}
+
 	// If the error was of the type *Error the handles
 	// has a specific status code and error to run
-	if webErr, ok := errors.Cause(err).(*Error); ok {
-		resp := ErrorResponse{
-			Error:      webErr.Err.Error(),
-			FieldError: webErr.FieldError,
+	webErr, ok := errors.Cause(err).(*Error)
+	if !ok {
+		webErr = &Error{
+			Err:    errors.New(http.StatusText(http.StatusInternalServerError)),
+			Status: http.StatusInternalServerError,
 		}
+	}
+
+	codes, messages, vd := &errorCodes{}, newCatalog(), defaultValidation
+	if v.app != nil {
+		codes, messages, vd = v.app.codes, v.app.messages, v.app.validation
+	}
+
+	code := codes.code(webErr)
+	msg, locale := localize(r, vd, messages, webErr, code)
+	if locale != "" {
+		w.Header().Set("Content-Language", locale)
+	}
 
-		return Respond(ctx, w, resp, webErr.Status)
+	if accepts(r, ProblemContentType) {
+		problem := encoder{mediaType: ProblemContentType, contentType: ProblemContentType, encode: encodeJSON}
+		return respond(ctx, w, newProblem(r, webErr, code, msg, v.TraceID), webErr.Status, problem)
 	}
 
 	resp := ErrorResponse{
-		Error: http.StatusText(http.StatusInternalServerError),
+		Error:      msg,
+		Code:       code,
+		FieldError: webErr.FieldError,
+		TraceID:    v.TraceID,
+	}
+
+	return respond(ctx, w, resp, webErr.Status, jsonEncoder)
+}
+
+// localize returns the message of e in the locale the client accepts and the
+// locale when the message or the fields of e are translated. Errors without a
+// message in the catalog of the locale keep their own.
+func localize(r *http.Request, vd *validation, messages *catalog, e *Error, code string) (string, string) {
+	locale := vd.translator(r).Locale()
+	if msg, ok := messages.message(locale, code); ok {
+		return msg, locale
+	}
+
+	return e.Err.Error(), e.locale
+}
+
+// accepts reports whether the Accept header of r names mediaType itself.
+// Wildcards do not count so clients have to opt in to mediaType.
+func accepts(r *http.Request, mediaType string) bool {
+	for _, header := range r.Header.Values("Accept") {
+		for _, part := range strings.Split(header, ",") {
+			name, params, _ := mime.ParseMediaType(strings.TrimSpace(part))
+			if name != mediaType {
+				continue
+			}
+
+			// A quality of 0 means not acceptable
+			if q, err := strconv.ParseFloat(params["q"], 64); err == nil && q == 0 {
+				continue
+			}
+
+			return true
+		}
 	}
 
-	return Respond(ctx, w, resp, http.StatusInternalServerError)
+	return false
 }
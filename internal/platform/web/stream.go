@@ -0,0 +1,140 @@
+package web
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"reflect"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// NDJSONContentType is the media type of newline delimited JSON, one value
+// per line
+const NDJSONContentType = "application/x-ndjson"
+
+// streamFlushInterval is how long streamed items may sit in buffers before
+// they are flushed to the client
+const streamFlushInterval = 500 * time.Millisecond
+
+// NextFunc returns the next item of a stream. ok is false once there are no
+// more items.
+type NextFunc func() (item interface{}, ok bool, err error)
+
+// RespondStream writes the items returned by next to the client one at a time
+// instead of marshalling them all at once. Clients accepting NDJSON get one
+// item per line, everyone else a JSON array sent in chunks, even when they
+// asked for a media type that cannot be streamed. Buffered items are
+// flushed periodically and the stream stops early when the client goes away.
+//
+// Errors of next after the first item was written can no longer change the
+// response. They are returned to be logged and the response is cut short.
+func RespondStream(ctx context.Context, w http.ResponseWriter, next NextFunc, statusCode int) error {
+	v, ok := ctx.Value(KeyValues).(*ContexValues)
+	if !ok {
+		return ErrContextValueMissing
+	}
+
+	// Other media types cannot be streamed, they get a JSON array instead
+	s := stream{contentType: "application/json; charset=utf-8", start: "[", sep: ",", end: "]"}
+	if v.encoder.mediaType == NDJSONContentType {
+		s = stream{contentType: NDJSONContentType, after: "\n"}
+	}
+
+	// Nothing is sent until the first item is ready so a failing query can
+	// still be answered with an error
+	item, ok, err := next()
+	if err != nil {
+		return err
+	}
+
+	v.StatusCode = statusCode
+	w.Header().Set("content-type", s.contentType)
+	w.WriteHeader(statusCode)
+
+	flusher, _ := w.(http.Flusher)
+	flushed := time.Now()
+	if flusher != nil {
+		defer flusher.Flush()
+	}
+
+	// Writes fail once the client is gone, there is nobody left to tell
+	write := func(b []byte) error {
+		if _, err := w.Write(b); err != nil && ctx.Err() == nil {
+			return errors.Wrap(err, "writing to client")
+		}
+		return ctx.Err()
+	}
+
+	if err := write([]byte(s.start)); err != nil {
+		return ignoreCanceled(err)
+	}
+
+	for i := 0; ok; i++ {
+		if i > 0 {
+			if err := write([]byte(s.sep)); err != nil {
+				return ignoreCanceled(err)
+			}
+		}
+
+		data, err := json.Marshal(item)
+		if err != nil {
+			return errors.Wrap(err, "error marshalling")
+		}
+		if err := write(append(data, s.after...)); err != nil {
+			return ignoreCanceled(err)
+		}
+
+		if flusher != nil && time.Since(flushed) >= streamFlushInterval {
+			flusher.Flush()
+			flushed = time.Now()
+		}
+
+		if item, ok, err = next(); err != nil {
+			return errors.Wrap(err, "streaming response")
+		}
+	}
+
+	return ignoreCanceled(write([]byte(s.end)))
+}
+
+// ignoreCanceled drops the error of a stream stopped because the client went
+// away
+func ignoreCanceled(err error) error {
+	if err == context.Canceled || err == context.DeadlineExceeded {
+		return nil
+	}
+
+	return err
+}
+
+// stream describes how the items of a streamed response are framed: start
+// and end enclose all items, sep goes between them and after follows each
+type stream struct {
+	contentType string
+	start       string
+	sep         string
+	after       string
+	end         string
+}
+
+// encodeNDJSON writes the elements of a list one per line, and any other
+// value as a single line
+func encodeNDJSON(w io.Writer, val interface{}) error {
+	enc := json.NewEncoder(w)
+
+	v := reflect.ValueOf(val)
+	if v.Kind() != reflect.Slice && v.Kind() != reflect.Array {
+		return enc.Encode(val)
+	}
+
+	for i := 0; i < v.Len(); i++ {
+		if err := enc.Encode(v.Index(i).Interface()); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
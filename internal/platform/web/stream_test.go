@@ -0,0 +1,113 @@
+package web_test
+
+import (
+	"context"
+	"garagesale/internal/middleware"
+	"garagesale/internal/platform/web"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/pkg/errors"
+)
+
+func TestRespondStream(t *testing.T) {
+	var codes []int
+	var logged strings.Builder
+	var calls int
+
+	// items yields n items and then fails when fail is set
+	items := func(n int, fail bool) web.NextFunc {
+		i := 0
+		return func() (interface{}, bool, error) {
+			calls++
+			if i == n {
+				if fail {
+					return nil, false, errors.New("connection reset")
+				}
+				return nil, false, nil
+			}
+			i++
+			return map[string]int{"id": i}, true, nil
+		}
+	}
+
+	app := web.NewApp(log.New(ioutil.Discard, "", 0), status(&codes), middleware.Errors(log.New(&logged, "", 0)))
+	app.Handle(http.MethodGet, "/items", func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+		return web.RespondStream(ctx, w, items(3, false), http.StatusOK)
+	})
+	app.Handle(http.MethodGet, "/empty", func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+		return web.RespondStream(ctx, w, items(0, false), http.StatusOK)
+	})
+	app.Handle(http.MethodGet, "/broken", func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+		return web.RespondStream(ctx, w, items(0, true), http.StatusOK)
+	})
+	app.Handle(http.MethodGet, "/cut", func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+		return web.RespondStream(ctx, w, items(2, true), http.StatusOK)
+	})
+
+	tests := []struct {
+		path        string
+		accept      string
+		status      int
+		contentType string
+		body        string
+	}{
+		{"/items", "", http.StatusOK, "application/json; charset=utf-8", `[{"id":1},{"id":2},{"id":3}]`},
+		{"/items", web.NDJSONContentType, http.StatusOK, web.NDJSONContentType, "{\"id\":1}\n{\"id\":2}\n{\"id\":3}\n"},
+		{"/empty", "", http.StatusOK, "application/json; charset=utf-8", `[]`},
+		{"/empty", web.NDJSONContentType, http.StatusOK, web.NDJSONContentType, ""},
+		{"/items", "text/csv", http.StatusOK, "application/json; charset=utf-8", `[{"id":1},{"id":2},{"id":3}]`},
+		{"/broken", "", http.StatusInternalServerError, "application/json; charset=utf-8", `{"error":"Internal Server Error","code":"internal_server_error","trace_id":"req-1"}`},
+		{"/cut", "", http.StatusOK, "application/json; charset=utf-8", `[{"id":1},{"id":2}`},
+	}
+
+	for _, tt := range tests {
+		codes = nil
+
+		req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+		req.Header.Set("X-Request-ID", "req-1")
+		if tt.accept != "" {
+			req.Header.Set("Accept", tt.accept)
+		}
+
+		resp := httptest.NewRecorder()
+		app.ServeHTTP(resp, req)
+
+		if resp.Code != tt.status || len(codes) != 1 || codes[0] != tt.status {
+			t.Errorf("%s %q: expected status %d, got %d recorded as %v", tt.path, tt.accept, tt.status, resp.Code, codes)
+		}
+
+		if got := resp.Header().Get("Content-Type"); got != tt.contentType {
+			t.Errorf("%s %q: expected content type %q, got %q", tt.path, tt.accept, tt.contentType, got)
+		}
+
+		if tt.body != "" && resp.Body.String() != tt.body {
+			t.Errorf("%s %q: expected body %q, got %q", tt.path, tt.accept, tt.body, resp.Body.String())
+		}
+
+		if tt.status == http.StatusOK && !resp.Flushed {
+			t.Errorf("%s %q: expected the stream to be flushed", tt.path, tt.accept)
+		}
+	}
+
+	if !strings.Contains(logged.String(), "streaming response: connection reset") {
+		t.Errorf("expected the error of a cut stream to be logged, got %q", logged.String())
+	}
+
+	// A client that went away stops the stream without an error
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	calls = 0
+	logged.Reset()
+	resp := httptest.NewRecorder()
+	app.ServeHTTP(resp, httptest.NewRequest(http.MethodGet, "/items", nil).WithContext(ctx))
+
+	if calls != 1 || logged.Len() != 0 {
+		t.Errorf("expected the stream to stop after the first item without an error, got %d calls and %q", calls, logged.String())
+	}
+}
@@ -0,0 +1,237 @@
+package web
+
+import (
+	"context"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/go-playground/locales/en"
+	"github.com/go-playground/locales/es"
+	"github.com/go-playground/locales/ru"
+	ut "github.com/go-playground/universal-translator"
+	"github.com/go-playground/validator/v10"
+	"github.com/pkg/errors"
+)
+
+// validation holds the validator of an App and the translators of the
+// reasons given for fields breaking its rules
+type validation struct {
+	validate *validator.Validate
+	uni      *ut.UniversalTranslator
+}
+
+// defaultValidation validates values decoded outside of an App. Rules are
+// only registered on Apps so it keeps the built-in ones.
+var defaultValidation = newValidation()
+
+// newValidation returns a validation of the built-in rules with their
+// messages in every supported locale
+func newValidation() *validation {
+	// English is the fallback for clients accepting none of the locales
+	v := validation{
+		validate: validator.New(),
+		uni:      ut.New(en.New(), en.New(), ru.New(), es.New()),
+	}
+
+	for locale, register := range localeTranslations {
+		trans, _ := v.uni.GetTranslator(locale)
+		if err := register(v.validate, trans); err != nil {
+			panic(err)
+		}
+	}
+
+	// Uses JSON tag names for errors instead of GO struct names
+	v.validate.RegisterTagNameFunc(func(fld reflect.StructField) string {
+		name := strings.SplitN(fld.Tag.Get("json"), ",", 2)[0]
+
+		if name == "-" {
+			return ""
+		}
+
+		return name
+	})
+
+	return &v
+}
+
+// validationFor returns the validation of the App serving the request of ctx
+func validationFor(ctx context.Context) *validation {
+	if v, ok := ctx.Value(KeyValues).(*ContexValues); ok && v.app != nil {
+		return v.app.validation
+	}
+
+	return defaultValidation
+}
+
+// RegisterValidation adds a rule for the validate tag to the App. fn gets
+// the context of the request. messages are the reasons given for fields
+// breaking the rule by locale, see RegisterTranslations.
+//
+// The validator cannot be extended while it validates, rules have to be
+// registered at startup before the App serves requests.
+func (a *App) RegisterValidation(tag string, fn validator.FuncCtx, messages map[string]string) error {
+	if err := a.validation.validate.RegisterValidationCtx(tag, fn); err != nil {
+		return errors.Wrapf(err, "registering %s", tag)
+	}
+
+	return a.RegisterTranslations(tag, messages)
+}
+
+// RegisterStructValidation adds a validator for rules spanning several fields
+// of the types to the App. It reports the fields it rejects with a tag whose
+// messages are registered with RegisterTranslations, using their JSON names.
+func (a *App) RegisterStructValidation(fn validator.StructLevelFuncCtx, types ...interface{}) {
+	a.validation.validate.RegisterStructValidationCtx(fn, types...)
+}
+
+// RegisterTranslations sets the reasons given for fields breaking the rule
+// of tag by locale, for requests served by the App. {0} is replaced with the
+// name of the field and {1} with the parameter of the rule. The English
+// message is required and used for locales without a message of their own.
+func (a *App) RegisterTranslations(tag string, messages map[string]string) error {
+	fallback, ok := messages["en"]
+	if !ok {
+		return errors.Errorf("%s has no english message", tag)
+	}
+
+	for locale := range localeTranslations {
+		msg, ok := messages[locale]
+		if !ok {
+			msg = fallback
+		}
+
+		register := func(trans ut.Translator) error {
+			return trans.Add(tag, msg, true)
+		}
+		translate := func(trans ut.Translator, fe validator.FieldError) string {
+			reason, err := trans.T(fe.Tag(), fe.Field(), fe.Param())
+			if err != nil {
+				return fe.Error()
+			}
+			return reason
+		}
+
+		trans, _ := a.validation.uni.GetTranslator(locale)
+		if err := a.validation.validate.RegisterTranslation(tag, trans, register, translate); err != nil {
+			return errors.Wrapf(err, "registering %s message of %s", locale, tag)
+		}
+	}
+
+	return nil
+}
+
+// CheckFunc validates a decoded value against state outside of the request,
+// such as the database. It returns the tags of the rules the fields of val
+// break by their JSON names; Decode translates them with the messages
+// registered for the tags on the App. An error fails the request as a whole.
+type CheckFunc func(ctx context.Context, val interface{}) (FieldError, error)
+
+// checkSet holds the checks of an App by the type they check and the
+// messages of the tags they report by locale
+type checkSet struct {
+	sync.RWMutex
+	m        map[reflect.Type][]CheckFunc
+	messages map[string]map[string]string
+}
+
+// newCheckSet returns a checkSet without checks
+func newCheckSet() *checkSet {
+	return &checkSet{
+		m:        make(map[reflect.Type][]CheckFunc),
+		messages: make(map[string]map[string]string),
+	}
+}
+
+// RegisterCheck adds fn to the checks Decode runs for values of the type of
+// val once they pass their validate tags, for requests served by the App. fn
+// gets a pointer to the value. messages are the reasons given for fields
+// breaking the rule of tag by locale. {0} is replaced with the name of the
+// field; checks report no parameter so there is no {1}. The English message
+// is used for locales without a message of their own.
+func (a *App) RegisterCheck(val interface{}, fn CheckFunc, tag string, messages map[string]string) {
+	a.checks.Lock()
+	defer a.checks.Unlock()
+
+	t := indirect(reflect.TypeOf(val))
+	a.checks.m[t] = append(a.checks.m[t], fn)
+	a.checks.messages[tag] = messages
+}
+
+// checks returns the checks of the App serving the request of ctx
+func checks(ctx context.Context) *checkSet {
+	if v, ok := ctx.Value(KeyValues).(*ContexValues); ok && v.app != nil {
+		return v.app.checks
+	}
+
+	return newCheckSet()
+}
+
+// check runs the checks registered for the type of val and returns the tags
+// of the fields they reject
+func (c *checkSet) check(ctx context.Context, val interface{}) (FieldError, error) {
+	c.RLock()
+	fns := c.m[indirect(reflect.TypeOf(val))]
+	c.RUnlock()
+
+	broken := make(FieldError)
+	for _, fn := range fns {
+		fields, err := fn(ctx, val)
+		if err != nil {
+			return nil, err
+		}
+
+		// The first rule a field breaks is reported, as with validate tags
+		for field, tag := range fields {
+			if _, ok := broken[field]; !ok {
+				broken[field] = tag
+			}
+		}
+	}
+
+	return broken, nil
+}
+
+// message returns the reason for field breaking the rule of tag in locale.
+// Tags without messages are given as the reason.
+func (c *checkSet) message(locale, tag, field string) string {
+	c.RLock()
+	messages := c.messages[tag]
+	c.RUnlock()
+
+	msg, ok := messages[locale]
+	if !ok {
+		if msg, ok = messages["en"]; !ok {
+			return tag
+		}
+	}
+
+	return strings.ReplaceAll(msg, "{0}", field)
+}
+
+// indirect returns the type pointers of t point to
+func indirect(t reflect.Type) reflect.Type {
+	for t != nil && t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	return t
+}
+
+// params describes the rules broken by fields in locale
+func (c *checkSet) params(locale string, broken FieldError) []InvalidParam {
+	fields := make([]string, 0, len(broken))
+	for field := range broken {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+
+	params := make([]InvalidParam, 0, len(fields))
+	for _, field := range fields {
+		tag := broken[field]
+		params = append(params, InvalidParam{Name: field, Code: tag, Reason: c.message(locale, tag, field)})
+	}
+
+	return params
+}
@@ -0,0 +1,143 @@
+package web_test
+
+import (
+	"context"
+	"garagesale/internal/platform/web"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/google/go-cmp/cmp"
+	"github.com/pkg/errors"
+)
+
+type sale struct {
+	Name     string `json:"name" validate:"required"`
+	Quantity int    `json:"quantity" validate:"even"`
+	Paid     int    `json:"paid"`
+	MinPrice int    `json:"min_price"`
+}
+
+// takenKey marks names as taken in the context of a request
+type takenKey struct{}
+
+func TestCustomValidation(t *testing.T) {
+	// Rules, messages and checks only apply to requests served by the app
+	// they are registered on
+	app := web.NewApp(log.New(ioutil.Discard, "", 0))
+
+	err := app.RegisterValidation("even", func(ctx context.Context, fl validator.FieldLevel) bool {
+		return fl.Field().Int()%2 == 0
+	}, map[string]string{
+		"en": "{0} must be even",
+		"ru": "{0} должно быть чётным",
+	})
+	if err != nil {
+		t.Fatalf("registering rule: %v", err)
+	}
+
+	app.RegisterStructValidation(func(ctx context.Context, sl validator.StructLevel) {
+		s := sl.Current().Interface().(sale)
+		if min := s.Quantity * s.MinPrice; s.Paid < min {
+			sl.ReportError(s.Paid, "paid", "Paid", "min_total", strconv.Itoa(min))
+		}
+	}, sale{})
+
+	err = app.RegisterTranslations("min_total", map[string]string{
+		"en": "{0} must be at least {1}",
+		"es": "{0} debe ser al menos {1}",
+	})
+	if err != nil {
+		t.Fatalf("registering messages: %v", err)
+	}
+
+	app.RegisterCheck(sale{}, func(ctx context.Context, val interface{}) (web.FieldError, error) {
+		s := val.(*sale)
+		if s.Name == "broken" {
+			return nil, errors.New("database is down")
+		}
+		if ctx.Value(takenKey{}) == s.Name {
+			return web.FieldError{"name": "unique"}, nil
+		}
+		return nil, nil
+	}, "unique", map[string]string{"en": "{0} is already taken", "es": "{0} ya está ocupado"})
+	app.RegisterCheck(item{}, func(ctx context.Context, val interface{}) (web.FieldError, error) {
+		return nil, errors.New("database is down")
+	}, "unavailable", nil)
+
+	var decodeErr error
+	app.Handle(http.MethodPost, "/sales", func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+		decodeErr = web.Decode(ctx, r, &sale{})
+		return nil
+	})
+
+	decode := func(r *http.Request) error {
+		app.ServeHTTP(httptest.NewRecorder(), r)
+		return decodeErr
+	}
+
+	if err := app.RegisterTranslations("nameless", map[string]string{"ru": "нет"}); err == nil {
+		t.Error("expected messages without an english one to be rejected")
+	}
+
+	tests := []struct {
+		body     string
+		language string
+		want     web.FieldError
+	}{
+		{`{"name": "hat", "quantity": 2, "paid": 10, "min_price": 5}`, "", nil},
+		{`{"name": "hat", "quantity": 3, "paid": 15, "min_price": 5}`, "", web.FieldError{"quantity": "quantity must be even"}},
+		{`{"name": "hat", "quantity": 3, "paid": 15, "min_price": 5}`, "ru", web.FieldError{"quantity": "quantity должно быть чётным"}},
+		{`{"name": "hat", "quantity": 3, "paid": 15, "min_price": 5}`, "es", web.FieldError{"quantity": "quantity must be even"}},
+		{`{"name": "hat", "quantity": 2, "paid": 9, "min_price": 5}`, "", web.FieldError{"paid": "paid must be at least 10"}},
+		{`{"name": "hat", "quantity": 2, "paid": 9, "min_price": 5}`, "es", web.FieldError{"paid": "paid debe ser al menos 10"}},
+		{`{"name": "lamp", "quantity": 2, "paid": 10, "min_price": 5}`, "", web.FieldError{"name": "name is already taken"}},
+		{`{"name": "lamp", "quantity": 2, "paid": 10, "min_price": 5}`, "es", web.FieldError{"name": "name ya está ocupado"}},
+		{`{"name": "lamp", "quantity": 2, "paid": 10, "min_price": 5}`, "ru", web.FieldError{"name": "name is already taken"}},
+		{`{"name": "lamp", "quantity": 2, "paid": 9, "min_price": 5}`, "", web.FieldError{"paid": "paid must be at least 10"}},
+	}
+
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodPost, "/sales", strings.NewReader(tt.body))
+		ctx := context.WithValue(req.Context(), takenKey{}, "lamp")
+		if tt.language != "" {
+			req.Header.Set("Accept-Language", tt.language)
+		}
+
+		err := decode(req.WithContext(ctx))
+
+		if tt.want == nil {
+			if err != nil {
+				t.Errorf("%s: unexpected error: %v", tt.body, err)
+			}
+			continue
+		}
+
+		webErr, ok := err.(*web.Error)
+		if !ok || webErr.Status != http.StatusBadRequest {
+			t.Fatalf("%s: expected a validation error, got %v", tt.body, err)
+		}
+
+		if diff := cmp.Diff(tt.want, webErr.FieldError); diff != "" {
+			t.Errorf("%s %q: unexpected fields:\n%s", tt.body, tt.language, diff)
+		}
+	}
+
+	// Failing checks fail the request instead of rejecting a field
+	req := httptest.NewRequest(http.MethodPost, "/sales", strings.NewReader(`{"name": "broken", "quantity": 2}`))
+	err = decode(req)
+	if _, ok := errors.Cause(err).(*web.Error); ok || err == nil || !strings.Contains(err.Error(), "database is down") {
+		t.Errorf("expected the error of the check, got %v", err)
+	}
+
+	// Requests outside of the app do not run its checks
+	req = httptest.NewRequest(http.MethodPost, "/items", strings.NewReader(`{"name": "broken"}`))
+	if err := web.Decode(req.Context(), req, &item{}); err != nil {
+		t.Errorf("expected no check outside of the app, got %v", err)
+	}
+}
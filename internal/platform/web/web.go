@@ -3,8 +3,12 @@ package web
 import (
 	"context"
 	"errors"
+	"fmt"
+	"garagesale/internal/platform/trace"
 	"log"
 	"net/http"
+	"runtime/debug"
+	"strings"
 	"time"
 
 	"github.com/go-chi/chi"
@@ -24,6 +28,22 @@ const KeyValues ctxCommonValuesKey = "commonValues"
 type ContexValues struct {
 	StatusCode int
 	Start      time.Time
+
+	// TraceID identifies the request in logs, error responses and the
+	// calls made for it. It is taken from the X-Request-ID header of the
+	// request or generated
+	TraceID string
+
+	// Subject and Actor identify who made the request once it is
+	// authenticated. Actor is only set for impersonated sessions
+	Subject string
+	Actor   string
+
+	// encoder is negotiated from the Accept header of the request
+	encoder encoder
+
+	// app is the App serving the request
+	app *App
 }
 
 //Handler is a signature that all applications handlers will implement
@@ -34,15 +54,33 @@ type App struct {
 	mux *chi.Mux
 	log *log.Logger
 	mw  []Middleware
+
+	// validation, checks, codes and messages are registered on the App
+	// before it serves requests
+	validation *validation
+	checks     *checkSet
+	codes      *errorCodes
+	messages   *catalog
 }
 
 //NewApp knows how to construct internal state for an App
 func NewApp(log *log.Logger, mw ...Middleware) *App {
-	return &App{
-		mux: chi.NewRouter(),
-		log: log,
-		mw:  mw,
+	a := App{
+		mux:        chi.NewRouter(),
+		log:        log,
+		mw:         mw,
+		validation: newValidation(),
+		checks:     newCheckSet(),
+		codes:      &errorCodes{m: make(map[error]string)},
+		messages:   newCatalog(),
 	}
+
+	// Requests that match no route still go through the middleware of the
+	// app so they are logged and counted like any other
+	a.mux.NotFound(a.handler(notFound))
+	a.mux.MethodNotAllowed(a.handler(a.methodNotAllowed))
+
+	return &a
 }
 
 //Handle connects a method and URL pattern to a particular application handler
@@ -50,24 +88,168 @@ func (a *App) Handle(method, pattern string, h Handler, mw ...Middleware) {
 	// Add specific route middleware
 	h = wrapMiddleware(mw, h)
 
+	a.mux.MethodFunc(method, pattern, a.handler(h))
+}
+
+// Group returns a Group of routes below prefix that share the middleware.
+// The middleware runs inside the middleware of the app.
+func (a *App) Group(prefix string, mw ...Middleware) *Group {
+	return &Group{
+		app:    a,
+		prefix: prefix,
+		mw:     mw,
+	}
+}
+
+// Mount attaches a sub-router such as another App below prefix. It handles
+// every request below prefix with its own routes and middleware.
+func (a *App) Mount(prefix string, h http.Handler) {
+	a.mux.Mount(prefix, h)
+}
+
+func (a *App) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	a.mux.ServeHTTP(w, r)
+}
+
+// handler wraps h with the middleware of the app and adapts it to http
+func (a *App) handler(h Handler) http.HandlerFunc {
+	// Panics of routes are recovered inside the general middleware so they
+	// are logged and answered with an error like any other request
+	h = recoverPanic(negotiated(h))
+
 	// Add aplications general middleware
 	h = wrapMiddleware(a.mw, h)
 
 	fn := func(w http.ResponseWriter, r *http.Request) {
 		v := ContexValues{
-			Start: time.Now(),
+			Start:   time.Now(),
+			TraceID: r.Header.Get(trace.Header),
+			app:     a,
+		}
+		if !trace.Valid(v.TraceID) {
+			v.TraceID = trace.NewID()
 		}
+		w.Header().Set(trace.Header, v.TraceID)
 
 		ctx := context.WithValue(r.Context(), KeyValues, &v)
+		ctx = trace.NewContext(ctx, v.TraceID)
 
 		if err := h(ctx, w, r); err != nil {
-			a.log.Printf("ERROR: Unhandled error: %v", err)
+			a.log.Printf("%s : ERROR: Unhandled error: %v", v.TraceID, err)
 		}
 	}
 
-	a.mux.MethodFunc(method, pattern, fn)
+	return fn
 }
 
-func (a *App) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	a.mux.ServeHTTP(w, r)
+// Group is a set of routes below a common prefix that share middleware
+type Group struct {
+	app    *App
+	prefix string
+	mw     []Middleware
+}
+
+// Handle connects a method and a URL pattern below the prefix of the group
+// to a handler. Route middleware runs inside the middleware of the group.
+func (g *Group) Handle(method, pattern string, h Handler, mw ...Middleware) {
+	all := make([]Middleware, 0, len(g.mw)+len(mw))
+	all = append(all, g.mw...)
+	all = append(all, mw...)
+
+	g.app.Handle(method, g.prefix+pattern, h, all...)
+}
+
+// Group returns a nested Group below the prefix of g. Its middleware runs
+// inside the middleware of g.
+func (g *Group) Group(prefix string, mw ...Middleware) *Group {
+	all := make([]Middleware, 0, len(g.mw)+len(mw))
+	all = append(all, g.mw...)
+	all = append(all, mw...)
+
+	return &Group{
+		app:    g.app,
+		prefix: g.prefix + prefix,
+		mw:     all,
+	}
+}
+
+// notFound responds to requests that match no route
+func notFound(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	return NewRequestError(errors.New("resource not found"), http.StatusNotFound)
+}
+
+// methodNotAllowed responds to requests for a route that does not support
+// their method. The methods it does support are listed in the Allow header.
+func (a *App) methodNotAllowed(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	// Mounted apps only see the path below their prefix
+	path := r.URL.Path
+	if rctx := chi.RouteContext(r.Context()); rctx != nil && rctx.RoutePath != "" {
+		path = rctx.RoutePath
+	}
+
+	var allowed []string
+	for _, method := range methods {
+		if a.mux.Match(chi.NewRouteContext(), method, path) {
+			allowed = append(allowed, method)
+		}
+	}
+	w.Header().Set("Allow", strings.Join(allowed, ", "))
+
+	return NewRequestError(errors.New("method not allowed"), http.StatusMethodNotAllowed)
+}
+
+// methods are checked for the Allow header of MethodNotAllowed responses
+var methods = []string{
+	http.MethodGet,
+	http.MethodHead,
+	http.MethodPost,
+	http.MethodPut,
+	http.MethodPatch,
+	http.MethodDelete,
+	http.MethodOptions,
+}
+
+// negotiated picks the encoder for responses before h runs, so requests
+// nothing can be produced for are refused before they change anything.
+// Responses the encoder cannot represent are sent as JSON instead of failing
+// once h has run.
+func negotiated(h Handler) Handler {
+	f := func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+		enc, err := negotiate(r)
+		if err != nil {
+			return NewRequestError(err, http.StatusNotAcceptable)
+		}
+
+		if v, ok := ctx.Value(KeyValues).(*ContexValues); ok {
+			v.encoder = enc
+		}
+
+		return h(ctx, w, r)
+	}
+
+	return f
+}
+
+// recoverPanic turns a panic of the handler into an error carrying the stack
+// trace, so it is logged and answered like any other error
+func recoverPanic(h Handler) Handler {
+	f := func(ctx context.Context, w http.ResponseWriter, r *http.Request) (err error) {
+		defer func() {
+			rec := recover()
+			if rec == nil {
+				return
+			}
+
+			// The handler wants the connection aborted without a response
+			if rec == http.ErrAbortHandler {
+				panic(rec)
+			}
+
+			err = fmt.Errorf("panic: %v\n%s", rec, debug.Stack())
+		}()
+
+		return h(ctx, w, r)
+	}
+
+	return f
 }
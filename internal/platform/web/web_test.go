@@ -0,0 +1,396 @@
+package web_test
+
+import (
+	"context"
+	"encoding/json"
+	"garagesale/internal/middleware"
+	tracectx "garagesale/internal/platform/trace"
+	"garagesale/internal/platform/web"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/pkg/errors"
+)
+
+// trace returns middleware that records its name on every request
+func trace(name string, calls *[]string) web.Middleware {
+	return func(after web.Handler) web.Handler {
+		return func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+			*calls = append(*calls, name)
+			return after(ctx, w, r)
+		}
+	}
+}
+
+// status records the status code of every request as the Logger would
+func status(codes *[]int) web.Middleware {
+	return func(after web.Handler) web.Handler {
+		return func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+			err := after(ctx, w, r)
+			*codes = append(*codes, ctx.Value(web.KeyValues).(*web.ContexValues).StatusCode)
+			return err
+		}
+	}
+}
+
+func ok(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	return web.Respond(ctx, w, r.URL.Path, http.StatusOK)
+}
+
+func TestGroups(t *testing.T) {
+	var calls []string
+	var codes []int
+
+	discard := log.New(ioutil.Discard, "", 0)
+	app := web.NewApp(discard, status(&codes), middleware.Errors(discard), trace("app", &calls))
+
+	v1 := app.Group("/v1", trace("v1", &calls))
+	users := v1.Group("/users", trace("users", &calls))
+	users.Handle(http.MethodGet, "/{id}", ok, trace("route", &calls))
+
+	sub := web.NewApp(discard, trace("sub", &calls))
+	sub.Handle(http.MethodGet, "/ping", ok)
+	app.Mount("/internal", sub)
+
+	tests := []struct {
+		method string
+		path   string
+		status int
+		calls  string
+	}{
+		{http.MethodGet, "/v1/users/1", http.StatusOK, "app v1 users route"},
+		{http.MethodGet, "/v1/products", http.StatusNotFound, "app"},
+		{http.MethodPost, "/v1/users/1", http.StatusMethodNotAllowed, "app"},
+		{http.MethodGet, "/internal/ping", http.StatusOK, "sub"},
+	}
+
+	for _, tt := range tests {
+		calls, codes = nil, nil
+
+		resp := httptest.NewRecorder()
+		app.ServeHTTP(resp, httptest.NewRequest(tt.method, tt.path, nil))
+
+		if resp.Code != tt.status {
+			t.Errorf("%s %s: expected status %d, got %d", tt.method, tt.path, tt.status, resp.Code)
+		}
+
+		if got := strings.Join(calls, " "); got != tt.calls {
+			t.Errorf("%s %s: expected middleware %q, got %q", tt.method, tt.path, tt.calls, got)
+		}
+
+		// The mounted app has its own middleware
+		if tt.calls != "sub" && (len(codes) != 1 || codes[0] != tt.status) {
+			t.Errorf("%s %s: expected status %d to be recorded, got %v", tt.method, tt.path, tt.status, codes)
+		}
+	}
+}
+
+func TestErrorResponses(t *testing.T) {
+	var codes []int
+	discard := log.New(ioutil.Discard, "", 0)
+
+	var logged strings.Builder
+	app := web.NewApp(discard, status(&codes), middleware.Errors(log.New(&logged, "", 0)))
+	app.Handle(http.MethodGet, "/products/{id}", ok)
+	app.Handle(http.MethodDelete, "/products/{id}", ok)
+	app.Handle(http.MethodGet, "/panic", func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+		panic("boom")
+	})
+
+	tests := []struct {
+		method string
+		path   string
+		status int
+		allow  string
+		error  string
+	}{
+		{http.MethodGet, "/missing", http.StatusNotFound, "", "resource not found"},
+		{http.MethodPost, "/products/1", http.StatusMethodNotAllowed, "GET, DELETE", "method not allowed"},
+		{http.MethodGet, "/panic", http.StatusInternalServerError, "", http.StatusText(http.StatusInternalServerError)},
+	}
+
+	for _, tt := range tests {
+		codes = nil
+
+		resp := httptest.NewRecorder()
+		app.ServeHTTP(resp, httptest.NewRequest(tt.method, tt.path, nil))
+
+		if resp.Code != tt.status || len(codes) != 1 || codes[0] != tt.status {
+			t.Errorf("%s %s: expected status %d, got %d recorded as %v", tt.method, tt.path, tt.status, resp.Code, codes)
+		}
+
+		if got := resp.Header().Get("Allow"); got != tt.allow {
+			t.Errorf("%s %s: expected Allow %q, got %q", tt.method, tt.path, tt.allow, got)
+		}
+
+		var body web.ErrorResponse
+		if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+			t.Fatalf("%s %s: decoding error response: %v", tt.method, tt.path, err)
+		}
+
+		if body.Error != tt.error {
+			t.Errorf("%s %s: expected error %q, got %q", tt.method, tt.path, tt.error, body.Error)
+		}
+	}
+
+	if !strings.Contains(logged.String(), "panic: boom") || !strings.Contains(logged.String(), "runtime/debug.Stack") {
+		t.Errorf("expected panic to be logged with a stack trace, got %q", logged.String())
+	}
+}
+
+func TestProblemResponses(t *testing.T) {
+	errGone := errors.New("product was sold")
+
+	discard := log.New(ioutil.Discard, "", 0)
+	app := web.NewApp(discard, middleware.Errors(discard))
+	app.RegisterErrorCodes(map[error]string{errGone: "product_sold"})
+	app.Handle(http.MethodGet, "/products/{id}", func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+		return web.NewRequestError(errors.Wrap(errGone, "looking for product"), http.StatusGone)
+	})
+	app.Handle(http.MethodPost, "/products", func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+		var np struct {
+			Name string `json:"name" validate:"required"`
+		}
+		return web.Decode(ctx, r, &np)
+	})
+
+	tests := []struct {
+		method string
+		path   string
+		body   string
+		want   web.Problem
+	}{
+		{
+			http.MethodGet, "/products/1", "",
+			web.Problem{
+				Type:     "urn:garagesale:problem:product_sold",
+				Title:    "Gone",
+				Status:   http.StatusGone,
+				Detail:   "looking for product: product was sold",
+				Instance: "/products/1",
+				Code:     "product_sold",
+				TraceID:  "req-1",
+			},
+		},
+		{
+			http.MethodPost, "/products", "{}",
+			web.Problem{
+				Type:     "urn:garagesale:problem:validation_failed",
+				Title:    "Bad Request",
+				Status:   http.StatusBadRequest,
+				Detail:   "field validation error",
+				Instance: "/products",
+				Code:     "validation_failed",
+				InvalidParams: []web.InvalidParam{
+					{Name: "name", Code: "required", Reason: "name is a required field"},
+				},
+				TraceID: "req-1",
+			},
+		},
+		{
+			http.MethodGet, "/missing", "",
+			web.Problem{
+				Type:     "urn:garagesale:problem:not_found",
+				Title:    "Not Found",
+				Status:   http.StatusNotFound,
+				Detail:   "resource not found",
+				Instance: "/missing",
+				Code:     "not_found",
+				TraceID:  "req-1",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		req := httptest.NewRequest(tt.method, tt.path, strings.NewReader(tt.body))
+		req.Header.Set("Accept", "application/json, application/problem+json;q=0.9")
+		req.Header.Set("X-Request-ID", "req-1")
+
+		resp := httptest.NewRecorder()
+		app.ServeHTTP(resp, req)
+
+		if got := resp.Header().Get("Content-Type"); got != web.ProblemContentType {
+			t.Errorf("%s %s: expected content type %q, got %q", tt.method, tt.path, web.ProblemContentType, got)
+		}
+
+		var got web.Problem
+		if err := json.NewDecoder(resp.Body).Decode(&got); err != nil {
+			t.Fatalf("%s %s: decoding problem: %v", tt.method, tt.path, err)
+		}
+
+		if diff := cmp.Diff(tt.want, got); diff != "" {
+			t.Errorf("%s %s: unexpected problem:\n%s", tt.method, tt.path, diff)
+		}
+	}
+
+	// Clients that do not ask for problems keep getting the old format
+	resp := httptest.NewRecorder()
+	app.ServeHTTP(resp, httptest.NewRequest(http.MethodGet, "/products/1", nil))
+
+	var body web.ErrorResponse
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		t.Fatalf("decoding error response: %v", err)
+	}
+
+	if body.Error != "looking for product: product was sold" || body.Code != "product_sold" {
+		t.Errorf("unexpected error response: %+v", body)
+	}
+}
+
+func TestRequestID(t *testing.T) {
+	var logged strings.Builder
+	var seen string
+
+	l := log.New(&logged, "", 0)
+	app := web.NewApp(l, middleware.Logger(l), middleware.Errors(l))
+	app.Handle(http.MethodGet, "/ok", func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+		seen = tracectx.FromContext(ctx)
+		return web.Respond(ctx, w, nil, http.StatusNoContent)
+	})
+
+	tests := []struct {
+		header string
+		keep   bool
+	}{
+		{"req-1", true},
+		{"", false},
+		{"*/ DROP TABLE users; /*", false},
+		{strings.Repeat("a", 200), false},
+	}
+
+	for _, tt := range tests {
+		seen = ""
+		logged.Reset()
+
+		req := httptest.NewRequest(http.MethodGet, "/ok", nil)
+		if tt.header != "" {
+			req.Header.Set("X-Request-ID", tt.header)
+		}
+
+		resp := httptest.NewRecorder()
+		app.ServeHTTP(resp, req)
+
+		id := resp.Header().Get("X-Request-ID")
+		if tt.keep && id != tt.header || !tt.keep && (id == "" || id == tt.header) {
+			t.Errorf("%q: unexpected request id %q", tt.header, id)
+		}
+
+		if seen != id {
+			t.Errorf("%q: expected handler context to carry %q, got %q", tt.header, id, seen)
+		}
+
+		if !strings.HasPrefix(logged.String(), id+" : 204 GET /ok") {
+			t.Errorf("%q: expected log line to start with the request id, got %q", tt.header, logged.String())
+		}
+	}
+
+	// Errors are logged and answered with the id
+	logged.Reset()
+	req := httptest.NewRequest(http.MethodGet, "/missing", nil)
+	req.Header.Set("X-Request-ID", "req-2")
+
+	resp := httptest.NewRecorder()
+	app.ServeHTTP(resp, req)
+
+	var body web.ErrorResponse
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		t.Fatalf("decoding error response: %v", err)
+	}
+
+	if body.TraceID != "req-2" {
+		t.Errorf("expected error response to carry the request id, got %+v", body)
+	}
+
+	if !strings.Contains(logged.String(), "req-2 : ERROR: resource not found") || !strings.Contains(logged.String(), "req-2 : 404 GET /missing") {
+		t.Errorf("expected every log line to carry the request id, got %q", logged.String())
+	}
+}
+
+func TestLocalizedErrors(t *testing.T) {
+	errSold := errors.New("product was sold")
+
+	discard := log.New(ioutil.Discard, "", 0)
+	app := web.NewApp(discard, middleware.Errors(discard))
+	app.RegisterErrorCodes(map[error]string{errSold: "product_sold_out"})
+	app.RegisterMessages("es", map[string]string{"product_sold_out": "el producto fue vendido"})
+	app.Handle(http.MethodPost, "/products", func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+		var np struct {
+			Name string `json:"name" validate:"required"`
+		}
+		return web.Decode(ctx, r, &np)
+	})
+	app.Handle(http.MethodGet, "/products/{id}", func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+		return web.NewRequestError(errSold, http.StatusGone)
+	})
+	app.Handle(http.MethodGet, "/private", func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+		return web.NewRequestError(errors.New("missing token"), http.StatusUnauthorized)
+	})
+
+	tests := []struct {
+		method   string
+		path     string
+		language string
+		error    string
+		field    string
+		content  string
+	}{
+		{http.MethodPost, "/products", "", "field validation error", "name is a required field", "en"},
+		{http.MethodPost, "/products", "ru", "ошибка проверки полей", "name обязательное поле", "ru"},
+		{http.MethodPost, "/products", "es-MX, en;q=0.5", "error de validación de campos", "name es un campo requerido", "es"},
+		{http.MethodPost, "/products", "de, ru;q=0.8", "ошибка проверки полей", "name обязательное поле", "ru"},
+		{http.MethodPost, "/products", "ru;q=0, fr", "field validation error", "name is a required field", "en"},
+		{http.MethodGet, "/products/1", "es", "el producto fue vendido", "", "es"},
+		{http.MethodGet, "/products/1", "ru", "product was sold", "", ""},
+		{http.MethodGet, "/private", "ru", "требуется аутентификация", "", "ru"},
+		{http.MethodGet, "/private", "es", "se requiere autenticación", "", "es"},
+		{http.MethodGet, "/private", "", "missing token", "", ""},
+	}
+
+	for _, tt := range tests {
+		req := httptest.NewRequest(tt.method, tt.path, strings.NewReader("{}"))
+		if tt.language != "" {
+			req.Header.Set("Accept-Language", tt.language)
+		}
+
+		resp := httptest.NewRecorder()
+		app.ServeHTTP(resp, req)
+
+		var body web.ErrorResponse
+		if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+			t.Fatalf("%s %q: decoding error response: %v", tt.path, tt.language, err)
+		}
+
+		if body.Error != tt.error || body.FieldError["name"] != tt.field {
+			t.Errorf("%s %q: expected %q and field %q, got %+v", tt.path, tt.language, tt.error, tt.field, body)
+		}
+
+		if got := resp.Header().Get("Content-Language"); got != tt.content {
+			t.Errorf("%s %q: expected Content-Language %q, got %q", tt.path, tt.language, tt.content, got)
+		}
+	}
+
+	// Codes and messages are registered for the app alone
+	other := web.NewApp(discard, middleware.Errors(discard))
+	other.Handle(http.MethodGet, "/products/{id}", func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+		return web.NewRequestError(errSold, http.StatusGone)
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/products/1", nil)
+	req.Header.Set("Accept-Language", "es")
+	resp := httptest.NewRecorder()
+	other.ServeHTTP(resp, req)
+
+	var body web.ErrorResponse
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		t.Fatalf("decoding error response: %v", err)
+	}
+
+	if body.Code != "gone" || body.Error != "product was sold" {
+		t.Errorf("expected code and message of another app to stay unregistered, got %+v", body)
+	}
+}
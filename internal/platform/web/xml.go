@@ -0,0 +1,342 @@
+package web
+
+import (
+	"bytes"
+	"encoding"
+	"encoding/json"
+	"encoding/xml"
+	"io"
+	"reflect"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// encodeXML writes the JSON view of val as XML so fields hidden from JSON
+// stay hidden. Objects become elements named after their keys and lists
+// repeat the element of their key. The document is wrapped in a response
+// element whose items are named item when val is a list.
+func encodeXML(w io.Writer, val interface{}) error {
+	data, err := json.Marshal(val)
+	if err != nil {
+		return err
+	}
+
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+
+	enc := xml.NewEncoder(w)
+	root := xml.StartElement{Name: xml.Name{Local: "response"}}
+
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+
+	switch bytes.TrimSpace(data)[0] {
+	case '[':
+		if err := enc.EncodeToken(root); err != nil {
+			return err
+		}
+		if err := jsonToXML(dec, enc, xmlElement("item"), false); err != nil {
+			return err
+		}
+		if err := enc.EncodeToken(root.End()); err != nil {
+			return err
+		}
+	case 'n':
+		if err := enc.EncodeToken(root); err != nil {
+			return err
+		}
+		if err := enc.EncodeToken(root.End()); err != nil {
+			return err
+		}
+	default:
+		if err := jsonToXML(dec, enc, root, false); err != nil {
+			return err
+		}
+	}
+
+	return enc.Flush()
+}
+
+// jsonToXML copies the next JSON value of dec to enc as the element start.
+// Lists repeat start for each of their entries, lists in lists get an
+// element of their own with the entries named item. Nulls are left out.
+func jsonToXML(dec *json.Decoder, enc *xml.Encoder, start xml.StartElement, inList bool) error {
+	tok, err := dec.Token()
+	if err != nil {
+		return err
+	}
+
+	switch t := tok.(type) {
+	case nil:
+		return nil
+
+	case json.Delim:
+		switch t {
+		case '{':
+			if err := enc.EncodeToken(start); err != nil {
+				return err
+			}
+			for dec.More() {
+				key, err := dec.Token()
+				if err != nil {
+					return err
+				}
+				if err := jsonToXML(dec, enc, xmlElement(key.(string)), false); err != nil {
+					return err
+				}
+			}
+			if _, err := dec.Token(); err != nil {
+				return err
+			}
+			return enc.EncodeToken(start.End())
+
+		case '[':
+			item := start
+			if inList {
+				if err := enc.EncodeToken(start); err != nil {
+					return err
+				}
+				item = xmlElement("item")
+			}
+			for dec.More() {
+				if err := jsonToXML(dec, enc, item, true); err != nil {
+					return err
+				}
+			}
+			if _, err := dec.Token(); err != nil {
+				return err
+			}
+			if inList {
+				return enc.EncodeToken(start.End())
+			}
+			return nil
+		}
+	}
+
+	var text string
+	switch t := tok.(type) {
+	case string:
+		text = t
+	case json.Number:
+		text = t.String()
+	case bool:
+		text = "false"
+		if t {
+			text = "true"
+		}
+	}
+
+	if err := enc.EncodeToken(start); err != nil {
+		return err
+	}
+	if err := enc.EncodeToken(xml.CharData(text)); err != nil {
+		return err
+	}
+	return enc.EncodeToken(start.End())
+}
+
+// xmlElement returns the element for a JSON key. Keys that are no valid XML
+// names, such as those of free-form maps, become entry elements with a key
+// attribute.
+func xmlElement(key string) xml.StartElement {
+	if validXMLName(key) {
+		return xml.StartElement{Name: xml.Name{Local: key}}
+	}
+
+	return xml.StartElement{
+		Name: xml.Name{Local: "entry"},
+		Attr: []xml.Attr{{Name: xml.Name{Local: "key"}, Value: key}},
+	}
+}
+
+// validXMLName reports whether s can be used as the name of an element
+func validXMLName(s string) bool {
+	if s == "" || strings.HasPrefix(strings.ToLower(s), "xml") {
+		return false
+	}
+
+	for i, c := range s {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c == '_':
+		case i > 0 && (c >= '0' && c <= '9' || c == '-' || c == '.'):
+		default:
+			return false
+		}
+	}
+
+	return true
+}
+
+// xmlNode is an element of a decoded XML document
+type xmlNode struct {
+	text     string
+	children map[string][]*xmlNode
+}
+
+// decodeXML reads documents as written by encodeXML into val, matching
+// elements to the JSON names of fields. Unknown elements are rejected.
+func decodeXML(r io.Reader, val interface{}) error {
+	v := reflect.ValueOf(val)
+	if v.Kind() != reflect.Ptr || v.IsNil() {
+		return errors.New("XML can only be decoded into a pointer")
+	}
+
+	dec := xml.NewDecoder(r)
+	for {
+		tok, err := dec.Token()
+		if err != nil {
+			return err
+		}
+
+		if _, ok := tok.(xml.StartElement); ok {
+			root, err := readXMLNode(dec)
+			if err != nil {
+				return err
+			}
+			return setXMLValue(v.Elem(), root)
+		}
+	}
+}
+
+// readXMLNode reads the content of the element just started in dec
+func readXMLNode(dec *xml.Decoder) (*xmlNode, error) {
+	n := xmlNode{children: make(map[string][]*xmlNode)}
+
+	for {
+		tok, err := dec.Token()
+		if err != nil {
+			return nil, err
+		}
+
+		switch t := tok.(type) {
+		case xml.StartElement:
+			name := t.Name.Local
+			for _, attr := range t.Attr {
+				if name == "entry" && attr.Name.Local == "key" {
+					name = attr.Value
+				}
+			}
+
+			child, err := readXMLNode(dec)
+			if err != nil {
+				return nil, err
+			}
+			n.children[name] = append(n.children[name], child)
+
+		case xml.CharData:
+			n.text += string(t)
+
+		case xml.EndElement:
+			return &n, nil
+		}
+	}
+}
+
+// setXMLValue stores the element n in v
+func setXMLValue(v reflect.Value, n *xmlNode) error {
+	if v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			v.Set(reflect.New(v.Type().Elem()))
+		}
+		return setXMLValue(v.Elem(), n)
+	}
+
+	if _, ok := v.Addr().Interface().(encoding.TextUnmarshaler); ok {
+		return setCSVValue(v, n.text)
+	}
+
+	switch v.Kind() {
+	case reflect.Interface:
+		v.Set(reflect.ValueOf(n.text))
+		return nil
+
+	case reflect.Struct:
+		cols := make(map[string][]int)
+		for _, c := range csvColumns(v.Type()) {
+			cols[c.name] = c.index
+		}
+
+		for name, nodes := range n.children {
+			index, ok := cols[name]
+			if !ok {
+				return errors.Errorf("unknown field %q", name)
+			}
+
+			if err := setXMLField(v.FieldByIndex(index), nodes); err != nil {
+				return errors.Wrapf(err, "field %q", name)
+			}
+		}
+		return nil
+
+	case reflect.Map:
+		if v.Type().Key().Kind() != reflect.String {
+			return errors.Errorf("XML cannot be decoded into %s", v.Type())
+		}
+		if v.IsNil() {
+			v.Set(reflect.MakeMap(v.Type()))
+		}
+
+		for key, nodes := range n.children {
+			e := reflect.New(v.Type().Elem()).Elem()
+			if err := setXMLField(e, nodes); err != nil {
+				return errors.Wrapf(err, "key %q", key)
+			}
+			v.SetMapIndex(reflect.ValueOf(key).Convert(v.Type().Key()), e)
+		}
+		return nil
+
+	case reflect.Slice:
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			return setCSVValue(v, n.text)
+		}
+
+		for name := range n.children {
+			if name != "item" {
+				return errors.Errorf("unknown element %q in list", name)
+			}
+		}
+		return setXMLList(v, n.children["item"])
+	}
+
+	return setCSVValue(v, n.text)
+}
+
+// setXMLField stores the elements of a field in f. Only lists take more
+// than one.
+func setXMLField(f reflect.Value, nodes []*xmlNode) error {
+	t := f.Type()
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	if t.Kind() == reflect.Slice && t.Elem().Kind() != reflect.Uint8 {
+		for f.Kind() == reflect.Ptr {
+			if f.IsNil() {
+				f.Set(reflect.New(f.Type().Elem()))
+			}
+			f = f.Elem()
+		}
+		return setXMLList(f, nodes)
+	}
+
+	if len(nodes) != 1 {
+		return errors.Errorf("expected one element, got %d", len(nodes))
+	}
+
+	return setXMLValue(f, nodes[0])
+}
+
+// setXMLList stores one element per entry of the list v
+func setXMLList(v reflect.Value, nodes []*xmlNode) error {
+	list := reflect.MakeSlice(v.Type(), len(nodes), len(nodes))
+	for i, node := range nodes {
+		if err := setXMLValue(list.Index(i), node); err != nil {
+			return errors.Wrapf(err, "item %d", i+1)
+		}
+	}
+	v.Set(list)
+
+	return nil
+}
@@ -11,6 +11,7 @@ type Product struct {
 	Name        string       `db:"name" json:"name"`
 	Quantity    int          `db:"quantity" json:"quantity"`
 	UserID      string       `db:"user_id" json:"user_id"`
+	OrgID       string       `db:"org_id" json:"org_id"`
 	Cost        int          `db:"cost" json:"cost"`
 	Sold        int          `db:"sold" json:"sold"`
 	Revenue     int          `db:"revenue" json:"revenue"`
@@ -40,6 +41,7 @@ type UpdateProduct struct {
 type Sale struct {
 	ID          string    `db:"sale_id" json:"id"`
 	ProductID   int       `db:"product_id" json:"product_id"`
+	OrgID       string    `db:"org_id" json:"org_id"`
 	Quantity    int       `db:"quantity" json:"quantity"`
 	Paid        int       `db:"paid" json:"paid"`
 	DateCreated time.Time `db:"date_created" json:"date_created"`
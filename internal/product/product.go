@@ -18,48 +18,69 @@ var (
 	ErrForbidden = errors.New("attempted action is not allowed")
 )
 
-//List returns all known Products
-func List(ctx context.Context, db *sqlx.DB) ([]Product, error) {
+// orgScope returns the organization every query made for the claims is
+// limited to. Claims without an organization are not allowed to see anything.
+func orgScope(claims auth.Claims) (string, error) {
+	if claims.OrgID == "" {
+		return "", ErrForbidden
+	}
+
+	return claims.OrgID, nil
+}
+
+//List returns all known Products of the caller's organization
+func List(ctx context.Context, db *sqlx.DB, claims auth.Claims) ([]Product, error) {
+	orgID, err := orgScope(claims)
+	if err != nil {
+		return nil, err
+	}
+
 	list := []Product{}
 
 	const q = `
 		SELECT 
-			p.product_id, p.name, p.quantity, p.cost,
+			p.product_id, p.name, p.quantity, p.cost, p.org_id,
 			COALESCE(SUM(s.quantity), 0) AS sold,
 			COALESCE(SUM(s.paid), 0) AS revenue,
 			p.date_created, p.date_updated
 		FROM products AS p
 		LEFT JOIN sales AS s ON s.product_id = p.product_id
+		WHERE p.org_id = $1
 		GROUP BY p.product_id
 	`
-	if err := db.SelectContext(ctx, &list, q); err != nil {
+	if err := db.SelectContext(ctx, &list, q, orgID); err != nil {
 		return nil, errors.Wrap(err, "selecting products")
 	}
 
 	return list, nil
 }
 
-//Retrieve returns a single Product
-func Retrieve(ctx context.Context, db *sqlx.DB, id string) (*Product, error) {
+//Retrieve returns a single Product of the caller's organization
+func Retrieve(ctx context.Context, db *sqlx.DB, claims auth.Claims, id string) (*Product, error) {
 	var prod Product
 
 	if _, err := strconv.Atoi(id); err != nil {
 		return nil, ErrInvalidId
 	}
 
+	orgID, err := orgScope(claims)
+	if err != nil {
+		return nil, err
+	}
+
 	const q = `
 		SELECT 
-		p.product_id, p.name, p.quantity, p.user_id, p.cost,
+		p.product_id, p.name, p.quantity, p.user_id, p.org_id, p.cost,
 		COALESCE(SUM(s.quantity), 0) AS sold,
 		COALESCE(SUM(s.paid), 0) AS revenue,
 		p.date_created, p.date_updated
 	FROM products AS p
 	LEFT JOIN sales AS s ON s.product_id = p.product_id
-	WHERE p.product_id = $1
+	WHERE p.product_id = $1 AND p.org_id = $2
 	GROUP BY p.product_id
 	`
 
-	if err := db.GetContext(ctx, &prod, q, id); err != nil {
+	if err := db.GetContext(ctx, &prod, q, id, orgID); err != nil {
 		if err == sql.ErrNoRows {
 			return nil, ErrNotFound
 		}
@@ -70,18 +91,23 @@ func Retrieve(ctx context.Context, db *sqlx.DB, id string) (*Product, error) {
 	return &prod, nil
 }
 
-// Create makes a new product
+// Create makes a new product in the caller's organization
 func Create(ctx context.Context, db *sqlx.DB, claims auth.Claims, np NewProduct, now time.Time) (*Product, error) {
 	var p Product
 
+	orgID, err := orgScope(claims)
+	if err != nil {
+		return nil, err
+	}
+
 	const q = `
 		INSERT INTO products
-		(name, cost, quantity, user_id, date_created, date_updated)
-		VALUES ($1, $2, $3, $4, $5, $6)
+		(name, cost, quantity, user_id, org_id, date_created, date_updated)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
 		RETURNING *
 	`
 	if err := db.QueryRowxContext(
-		ctx, q, np.Name, np.Cost, np.Quantity, claims.Subject, now.UTC(), now.UTC(),
+		ctx, q, np.Name, np.Cost, np.Quantity, claims.Subject, orgID, now.UTC(), now.UTC(),
 	).StructScan(&p); err != nil {
 		return nil, errors.Wrapf(err, "inserting products: %v \nNow: %v", p, now)
 	}
@@ -96,12 +122,12 @@ func Update(ctx context.Context, db *sqlx.DB, claims auth.Claims, id string, upd
 		return nil, ErrInvalidId
 	}
 
-	p, err := Retrieve(ctx, db, id)
+	p, err := Retrieve(ctx, db, claims, id)
 	if err != nil {
 		return nil, err
 	}
 
-	if !claims.HasRoles(auth.RoleAdmin) && claims.Subject != p.UserID {
+	if !claims.HasPermission(auth.PermProductUpdateAny) && claims.Subject != p.UserID {
 		return nil, ErrForbidden
 	}
 
@@ -122,11 +148,11 @@ func Update(ctx context.Context, db *sqlx.DB, claims auth.Claims, id string, upd
 		cost = $3,
 		quantity = $4,
 		date_updated = $5
-		WHERE product_id = $1
+		WHERE product_id = $1 AND org_id = $6
 	`
 
 	_, err = db.ExecContext(ctx, q, p.ID,
-		p.Name, p.Cost, p.Quantity, p.DateUpdated.Time,
+		p.Name, p.Cost, p.Quantity, p.DateUpdated.Time, p.OrgID,
 	)
 	if err != nil {
 		return nil, errors.Wrap(err, "updating product")
@@ -135,19 +161,24 @@ func Update(ctx context.Context, db *sqlx.DB, claims auth.Claims, id string, upd
 	return p, nil
 }
 
-// Delete remove a Product. It will error if the specified ID
+// Delete remove a Product of the caller's organization. It will error if the specified ID
 // is invalid or does not reference an existing Product.
-func Delete(ctx context.Context, db *sqlx.DB, id string) error {
+func Delete(ctx context.Context, db *sqlx.DB, claims auth.Claims, id string) error {
 	if _, err := strconv.Atoi(id); err != nil {
 		return ErrInvalidId
 	}
 
+	orgID, err := orgScope(claims)
+	if err != nil {
+		return err
+	}
+
 	q := `
 		DELETE FROM products
-		WHERE product_id = $1
+		WHERE product_id = $1 AND org_id = $2
 	`
 
-	if _, err := db.ExecContext(ctx, q, id); err != nil {
+	if _, err := db.ExecContext(ctx, q, id, orgID); err != nil {
 		return errors.Wrap(err, "deleting product")
 	}
 
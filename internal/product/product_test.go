@@ -4,6 +4,7 @@ import (
 	"context"
 	"garagesale/internal/platform/auth"
 	"garagesale/internal/platform/database/databasetest"
+	"garagesale/internal/platform/organization"
 	"garagesale/internal/product"
 	"strconv"
 	"testing"
@@ -12,6 +13,9 @@ import (
 	"github.com/google/go-cmp/cmp"
 )
 
+// claims scopes the tests to the organization created by the migrations
+var claims = auth.Claims{OrgID: organization.DefaultID}
+
 func TestProducts(t *testing.T) {
 	db, teardown := databasetest.Setup(t)
 	t.Cleanup(teardown)
@@ -25,12 +29,12 @@ func TestProducts(t *testing.T) {
 
 	now := time.Now()
 
-	p, err := product.Create(ctx, db, auth.Claims{}, np, now)
+	p, err := product.Create(ctx, db, claims, np, now)
 	if err != nil {
 		t.Fatalf("could not create product %v", err)
 	}
 
-	saved, err := product.Retrieve(ctx, db, strconv.Itoa(p.ID))
+	saved, err := product.Retrieve(ctx, db, claims, strconv.Itoa(p.ID))
 	if err != nil {
 		t.Fatalf("could not retrieve product, id: %v", p.ID)
 	}
@@ -62,7 +66,7 @@ func TestProductList(t *testing.T) {
 	var savedProducts []product.Product
 
 	for _, value := range newProducts {
-		saved, err := product.Create(ctx, db, auth.Claims{}, value, now)
+		saved, err := product.Create(ctx, db, claims, value, now)
 		if err != nil {
 			t.Fatalf("could not create product %v", err)
 		}
@@ -75,7 +79,7 @@ func TestProductList(t *testing.T) {
 	}
 
 	for _, value := range savedProducts {
-		retrieve, err := product.Retrieve(ctx, db, strconv.Itoa(value.ID))
+		retrieve, err := product.Retrieve(ctx, db, claims, strconv.Itoa(value.ID))
 		if err != nil {
 			t.Fatalf("could not retrieve product, ID: %d", value.ID)
 		}
@@ -97,7 +101,7 @@ func TestProductUpdate(t *testing.T) {
 		Quantity: 10,
 		Cost:     10,
 	}
-	createdProduct, err := product.Create(ctx, db, auth.Claims{}, newProduct, createdTime)
+	createdProduct, err := product.Create(ctx, db, claims, newProduct, createdTime)
 	if err != nil {
 		t.Fatal("could not create a product")
 	}
@@ -111,7 +115,7 @@ func TestProductUpdate(t *testing.T) {
 		Cost:     &updateCost,
 		Quantity: &updateQuantity,
 	}
-	got, err := product.Update(ctx, db, auth.Claims{}, strconv.Itoa(createdProduct.ID), update, updatedTime)
+	got, err := product.Update(ctx, db, claims, strconv.Itoa(createdProduct.ID), update, updatedTime)
 	if err != nil {
 		t.Fatalf("could not update product: %v", err)
 	}
@@ -125,6 +129,7 @@ func TestProductUpdate(t *testing.T) {
 
 	want := product.Product{
 		ID:          createdProduct.ID,
+		OrgID:       createdProduct.OrgID,
 		Name:        *update.Name,
 		Quantity:    *update.Quantity,
 		Cost:        *update.Cost,
@@ -150,22 +155,67 @@ func TestProductDelete(t *testing.T) {
 		Cost:     1,
 	}
 
-	created, err := product.Create(ctx, db, auth.Claims{}, np, time.Now())
+	created, err := product.Create(ctx, db, claims, np, time.Now())
 	if err != nil {
 		t.Fatal("could not create")
 	}
 
 	id := strconv.Itoa(created.ID)
 
-	if _, err := product.Retrieve(ctx, db, id); err != nil {
+	if _, err := product.Retrieve(ctx, db, claims, id); err != nil {
 		t.Fatal("could not retrieve")
 	}
-	if err := product.Delete(ctx, db, id); err != nil {
+	if err := product.Delete(ctx, db, claims, id); err != nil {
 		t.Fatalf("could not delete: %v", err)
 	}
 
-	_, err = product.Retrieve(ctx, db, id)
+	_, err = product.Retrieve(ctx, db, claims, id)
 	if err != product.ErrNotFound {
 		t.Fatal(err)
 	}
 }
+
+func TestProductOrgScope(t *testing.T) {
+	db, teardown := databasetest.Setup(t)
+	t.Cleanup(teardown)
+	ctx := context.Background()
+
+	org, err := organization.Create(ctx, db, organization.NewOrganization{Name: "other"}, time.Now())
+	if err != nil {
+		t.Fatalf("could not create organization: %v", err)
+	}
+	other := auth.Claims{OrgID: org.ID}
+
+	np := product.NewProduct{
+		Name:     "scoped",
+		Quantity: 1,
+		Cost:     1,
+	}
+
+	p, err := product.Create(ctx, db, claims, np, time.Now())
+	if err != nil {
+		t.Fatalf("could not create product: %v", err)
+	}
+	id := strconv.Itoa(p.ID)
+
+	if _, err := product.Retrieve(ctx, db, other, id); err != product.ErrNotFound {
+		t.Fatalf("expected %v for other organization, got %v", product.ErrNotFound, err)
+	}
+
+	ns := product.NewSale{Quantity: 1, Paid: 1}
+	if _, err := product.AddSale(ctx, db, other, ns, id, time.Now()); err != product.ErrNotFound {
+		t.Fatalf("expected %v for other organization, got %v", product.ErrNotFound, err)
+	}
+
+	list, err := product.List(ctx, db, other)
+	if err != nil {
+		t.Fatalf("could not list products: %v", err)
+	}
+	if len(list) != 0 {
+		t.Fatalf("expected no products for other organization, got %d", len(list))
+	}
+
+	if _, err := product.List(ctx, db, auth.Claims{}); err != product.ErrForbidden {
+		t.Fatalf("expected %v without organization, got %v", product.ErrForbidden, err)
+	}
+}
@@ -2,6 +2,8 @@ package product
 
 import (
 	"context"
+	"database/sql"
+	"garagesale/internal/platform/auth"
 	"strconv"
 	"time"
 
@@ -10,48 +12,65 @@ import (
 	"github.com/pkg/errors"
 )
 
-// AddSale records a Sale transaction for a single Product.
-func AddSale(ctx context.Context, db *sqlx.DB, ns NewSale, productID string, now time.Time) (*Sale, error) {
+// AddSale records a Sale transaction for a single Product of the caller's organization.
+func AddSale(ctx context.Context, db *sqlx.DB, claims auth.Claims, ns NewSale, productID string, now time.Time) (*Sale, error) {
 	id, err := strconv.Atoi(productID)
 	if err != nil {
 		return nil, ErrInvalidId
 	}
 
+	orgID, err := orgScope(claims)
+	if err != nil {
+		return nil, err
+	}
+
 	s := Sale{
 		ID:          uuid.New().String(),
 		ProductID:   id,
+		OrgID:       orgID,
 		Quantity:    ns.Quantity,
 		Paid:        ns.Paid,
 		DateCreated: now.UTC(),
 	}
 
+	// The sale is only inserted when the product belongs to the organization
 	q := `
 	INSERT INTO sales
-	(sale_id, product_id, quantity, paid, date_created)
-	VALUES
-	($1, $2, $3, $4, $5)
+	(sale_id, product_id, quantity, paid, date_created, org_id)
+	SELECT $1, p.product_id, $3, $4, $5, p.org_id
+	FROM products AS p
+	WHERE p.product_id = $2 AND p.org_id = $6
 	RETURNING *
 	`
 
 	var result Sale
-	if err := db.QueryRowxContext(ctx, q, s.ID, s.ProductID, s.Quantity, s.Paid, s.DateCreated).StructScan(&result); err != nil {
+	if err := db.QueryRowxContext(ctx, q, s.ID, s.ProductID, s.Quantity, s.Paid, s.DateCreated, s.OrgID).StructScan(&result); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrNotFound
+		}
+
 		return nil, errors.Wrapf(err, "inserting sales: %v", s)
 	}
 
 	return &result, nil
 }
 
-// ListSales gives all Sales for a Product
-func ListSales(ctx context.Context, db *sqlx.DB, productID string) ([]Sale, error) {
+// ListSales gives all Sales for a Product of the caller's organization
+func ListSales(ctx context.Context, db *sqlx.DB, claims auth.Claims, productID string) ([]Sale, error) {
 	id, err := strconv.Atoi(productID)
 	if err != nil {
 		return nil, ErrInvalidId
 	}
 
+	orgID, err := orgScope(claims)
+	if err != nil {
+		return nil, err
+	}
+
 	sales := []Sale{}
 
-	const q = `SELECT * FROM sales WHERE product_id = $1`
-	if err := db.SelectContext(ctx, &sales, q, id); err != nil {
+	const q = `SELECT * FROM sales WHERE product_id = $1 AND org_id = $2`
+	if err := db.SelectContext(ctx, &sales, q, id, orgID); err != nil {
 		return nil, errors.Wrapf(err, "selecting sales. Product id: %v", id)
 	}
 
@@ -2,7 +2,6 @@ package product_test
 
 import (
 	"context"
-	"garagesale/internal/platform/auth"
 	"garagesale/internal/platform/database/databasetest"
 	"garagesale/internal/product"
 	"strconv"
@@ -30,12 +29,12 @@ func TestAddSale(t *testing.T) {
 		Cost:     10,
 	}
 
-	p, err := product.Create(ctx, db, auth.Claims{}, np, now)
+	p, err := product.Create(ctx, db, claims, np, now)
 	if err != nil {
 		t.Fatalf("could not create product %v", err)
 	}
 
-	createdSale, err := product.AddSale(ctx, db, ns, strconv.Itoa(p.ID), now)
+	createdSale, err := product.AddSale(ctx, db, claims, ns, strconv.Itoa(p.ID), now)
 	if err != nil {
 		t.Fatalf("could not create sale: %v", err)
 	}
@@ -80,18 +79,18 @@ func TestSalesList(t *testing.T) {
 		Cost:     10,
 	}
 
-	p, err := product.Create(ctx, db, auth.Claims{}, np, now)
+	p, err := product.Create(ctx, db, claims, np, now)
 	if err != nil {
 		t.Fatalf("could not create product %v", err)
 	}
 
 	for _, s := range sales {
-		if _, err := product.AddSale(ctx, db, s, strconv.Itoa(p.ID), now); err != nil {
+		if _, err := product.AddSale(ctx, db, claims, s, strconv.Itoa(p.ID), now); err != nil {
 			t.Fatalf("could not create sale: %v", err)
 		}
 	}
 
-	got, err := product.ListSales(ctx, db, strconv.Itoa(p.ID))
+	got, err := product.ListSales(ctx, db, claims, strconv.Itoa(p.ID))
 	if err != nil {
 		t.Fatalf("could not get product list %v", err)
 	}
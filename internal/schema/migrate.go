@@ -72,6 +72,304 @@ CREATE TABLE products (
 		FOREIGN KEY (user_id) REFERENCES users(user_id);
 		`,
 	},
+	{
+		Version:     6,
+		Description: "Add profile columns to users",
+		Script: `
+		ALTER TABLE users
+		ADD COLUMN preferences JSONB NOT NULL DEFAULT '{}',
+		ADD COLUMN email_verified BOOLEAN NOT NULL DEFAULT FALSE,
+		ADD COLUMN pending_email TEXT NULL,
+		ADD COLUMN email_verification_hash TEXT NULL;
+		`,
+	},
+	{
+		Version:     7,
+		Description: "Add status and token version to users",
+		Script: `
+		ALTER TABLE users
+		ADD COLUMN status TEXT NOT NULL DEFAULT 'active',
+		ADD COLUMN token_version INT NOT NULL DEFAULT 0;
+		`,
+	},
+	{
+		Version:     8,
+		Description: "Add organizations and memberships",
+		Script: `
+		CREATE TABLE organizations (
+			org_id UUID,
+			name TEXT UNIQUE NOT NULL,
+			date_created TIMESTAMP,
+			date_updated TIMESTAMP,
+
+			PRIMARY KEY (org_id)
+		);
+
+		INSERT INTO organizations (org_id, name, date_created, date_updated)
+		VALUES ('00000000-0000-0000-0000-000000000001', 'default', NOW(), NOW());
+
+		CREATE TABLE memberships (
+			org_id UUID,
+			user_id UUID,
+			roles TEXT[],
+			date_created TIMESTAMP,
+
+			PRIMARY KEY (org_id, user_id),
+			FOREIGN KEY (org_id) REFERENCES organizations (org_id) ON DELETE CASCADE,
+			FOREIGN KEY (user_id) REFERENCES users (user_id) ON DELETE CASCADE
+		);
+
+		INSERT INTO memberships (org_id, user_id, roles, date_created)
+		SELECT '00000000-0000-0000-0000-000000000001', user_id, roles, NOW() FROM users;
+
+		ALTER TABLE users DROP COLUMN roles;
+		`,
+	},
+	{
+		Version:     9,
+		Description: "Scope products and sales to organizations",
+		Script: `
+		ALTER TABLE products
+		ADD COLUMN org_id UUID NOT NULL DEFAULT '00000000-0000-0000-0000-000000000001'
+		REFERENCES organizations (org_id) ON DELETE CASCADE;
+		ALTER TABLE products ALTER COLUMN org_id DROP DEFAULT;
+		ALTER TABLE products ADD CONSTRAINT UQ_product_org UNIQUE (product_id, org_id);
+
+		ALTER TABLE sales ADD COLUMN org_id UUID;
+		UPDATE sales SET org_id = p.org_id FROM products AS p WHERE p.product_id = sales.product_id;
+		ALTER TABLE sales ALTER COLUMN org_id SET NOT NULL;
+		ALTER TABLE sales
+		ADD CONSTRAINT FK_sales_product_org
+		FOREIGN KEY (product_id, org_id) REFERENCES products (product_id, org_id) ON DELETE CASCADE;
+		`,
+	},
+	{
+		Version:     10,
+		Description: "Add roles",
+		Script: `
+		CREATE TABLE roles (
+			role_id UUID,
+			org_id UUID NULL,
+			name TEXT NOT NULL,
+			permissions TEXT[] NOT NULL DEFAULT '{}',
+			date_created TIMESTAMP,
+			date_updated TIMESTAMP,
+
+			PRIMARY KEY (role_id),
+			FOREIGN KEY (org_id) REFERENCES organizations (org_id) ON DELETE CASCADE
+		);
+
+		CREATE UNIQUE INDEX UQ_roles_org_name
+		ON roles (COALESCE(org_id, '00000000-0000-0000-0000-000000000000'), name);
+
+		INSERT INTO roles (role_id, org_id, name, permissions, date_created, date_updated)
+		VALUES
+		(
+			'7d4fdc3c-3a4e-4c59-9a47-4b7a8f0c2a01', NULL, 'ADMIN',
+			'{product:read,product:create,product:update,product:update:any,product:delete,sale:read,sale:create,sale:refund,user:manage,role:manage}',
+			NOW(), NOW()
+		),
+		(
+			'7d4fdc3c-3a4e-4c59-9a47-4b7a8f0c2a02', NULL, 'USER',
+			'{product:read,product:create,product:update,sale:read,sale:create}',
+			NOW(), NOW()
+		);
+		`,
+	},
+	{
+		Version:     11,
+		Description: "Add api keys",
+		Script: `
+		CREATE TABLE api_keys (
+			key_id UUID,
+			user_id UUID NOT NULL,
+			org_id UUID NOT NULL,
+			name TEXT NOT NULL,
+			prefix TEXT NOT NULL,
+			key_hash TEXT UNIQUE NOT NULL,
+			permissions TEXT[] NOT NULL DEFAULT '{}',
+			expires_at TIMESTAMP NULL,
+			last_used TIMESTAMP NULL,
+			date_revoked TIMESTAMP NULL,
+			date_created TIMESTAMP,
+
+			PRIMARY KEY (key_id),
+			FOREIGN KEY (user_id) REFERENCES users (user_id) ON DELETE CASCADE,
+			FOREIGN KEY (org_id) REFERENCES organizations (org_id) ON DELETE CASCADE
+		);
+		`,
+	},
+	{
+		Version:     12,
+		Description: "Add two-factor authentication",
+		Script: `
+		ALTER TABLE users
+		ADD COLUMN totp_secret TEXT NULL,
+		ADD COLUMN totp_enabled BOOLEAN NOT NULL DEFAULT FALSE,
+		ADD COLUMN totp_last_step BIGINT NOT NULL DEFAULT 0;
+
+		CREATE TABLE recovery_codes (
+			user_id UUID,
+			code_hash TEXT,
+			date_used TIMESTAMP NULL,
+
+			PRIMARY KEY (user_id, code_hash),
+			FOREIGN KEY (user_id) REFERENCES users (user_id) ON DELETE CASCADE
+		);
+		`,
+	},
+	{
+		Version:     13,
+		Description: "Add refresh tokens",
+		Script: `
+		CREATE TABLE refresh_tokens (
+			token_id UUID,
+			family_id UUID NOT NULL,
+			user_id UUID NOT NULL,
+			org_id UUID NOT NULL,
+			token_hash TEXT UNIQUE NOT NULL,
+			token_version INT NOT NULL,
+			expires_at TIMESTAMP NOT NULL,
+			date_used TIMESTAMP NULL,
+			date_revoked TIMESTAMP NULL,
+			date_created TIMESTAMP,
+
+			PRIMARY KEY (token_id),
+			FOREIGN KEY (user_id) REFERENCES users (user_id) ON DELETE CASCADE,
+			FOREIGN KEY (org_id) REFERENCES organizations (org_id) ON DELETE CASCADE
+		);
+
+		CREATE INDEX IX_refresh_tokens_family ON refresh_tokens (family_id);
+		`,
+	},
+	{
+		Version:     14,
+		Description: "Add revoked tokens",
+		Script: `
+		CREATE TABLE revoked_tokens (
+			jti TEXT,
+			expires_at TIMESTAMP NOT NULL,
+
+			PRIMARY KEY (jti)
+		);
+
+		CREATE INDEX IX_revoked_tokens_expires ON revoked_tokens (expires_at);
+		`,
+	},
+	{
+		Version:     15,
+		Description: "Add external identities",
+		Script: `
+		CREATE TABLE external_identities (
+			issuer TEXT,
+			subject TEXT,
+			user_id UUID NOT NULL,
+			date_created TIMESTAMP,
+
+			PRIMARY KEY (issuer, subject),
+			FOREIGN KEY (user_id) REFERENCES users (user_id) ON DELETE CASCADE
+		);
+		`,
+	},
+	{
+		Version:     16,
+		Description: "Add browser sessions",
+		Script: `
+		CREATE TABLE sessions (
+			session_id UUID,
+			token_hash TEXT UNIQUE NOT NULL,
+			user_id UUID NOT NULL,
+			claims JSONB NOT NULL,
+			expires_at TIMESTAMP NOT NULL,
+			date_created TIMESTAMP,
+
+			PRIMARY KEY (session_id),
+			FOREIGN KEY (user_id) REFERENCES users (user_id) ON DELETE CASCADE
+		);
+		`,
+	},
+	{
+		Version:     17,
+		Description: "Add client certificates",
+		Script: `
+		CREATE TABLE client_certificates (
+			subject TEXT,
+			user_id UUID NOT NULL,
+			org_id UUID NOT NULL,
+			date_created TIMESTAMP,
+
+			PRIMARY KEY (subject),
+			FOREIGN KEY (user_id) REFERENCES users (user_id) ON DELETE CASCADE,
+			FOREIGN KEY (org_id) REFERENCES organizations (org_id) ON DELETE CASCADE
+		);
+		`,
+	},
+	{
+		Version:     18,
+		Description: "Grant impersonation to admins",
+		Script: `
+		UPDATE roles SET
+		permissions = array_append(permissions, 'user:impersonate')
+		WHERE role_id = '7d4fdc3c-3a4e-4c59-9a47-4b7a8f0c2a01';
+		`,
+	},
+	{
+		Version:     19,
+		Description: "Add two-factor challenges",
+		Script: `
+		CREATE TABLE two_factor_challenges (
+			jti TEXT,
+			user_id UUID NOT NULL,
+			attempts INT NOT NULL DEFAULT 0,
+			expires_at TIMESTAMP NOT NULL,
+			date_created TIMESTAMP,
+
+			PRIMARY KEY (jti),
+			FOREIGN KEY (user_id) REFERENCES users (user_id) ON DELETE CASCADE
+		);
+
+		CREATE INDEX IX_two_factor_challenges_user ON two_factor_challenges (user_id, date_created);
+		`,
+	},
+	{
+		Version:     20,
+		Description: "Add external tokens",
+		Script: `
+		CREATE TABLE external_tokens (
+			token_hash TEXT,
+			jti TEXT UNIQUE NOT NULL,
+			user_id UUID NOT NULL,
+			org_id UUID NOT NULL,
+			token_version INT NOT NULL,
+			expires_at TIMESTAMP NOT NULL,
+			date_created TIMESTAMP,
+
+			PRIMARY KEY (token_hash),
+			FOREIGN KEY (user_id) REFERENCES users (user_id) ON DELETE CASCADE,
+			FOREIGN KEY (org_id) REFERENCES organizations (org_id) ON DELETE CASCADE
+		);
+		`,
+	},
+	{
+		Version:     21,
+		Description: "Store the organization and token version of sessions instead of their claims",
+		Script: `
+		ALTER TABLE sessions
+		ADD COLUMN org_id UUID,
+		ADD COLUMN token_version INT NOT NULL DEFAULT 0;
+
+		UPDATE sessions SET
+		org_id = NULLIF(claims->>'org', '')::UUID,
+		token_version = COALESCE((claims->>'tv')::INT, 0);
+
+		DELETE FROM sessions WHERE org_id IS NULL;
+
+		ALTER TABLE sessions
+		DROP COLUMN claims,
+		ALTER COLUMN org_id SET NOT NULL,
+		ADD FOREIGN KEY (org_id) REFERENCES organizations (org_id) ON DELETE CASCADE;
+		`,
+	},
 }
 
 func Migrate(db *sqlx.DB) error {
@@ -3,13 +3,19 @@ package schema
 import "github.com/jmoiron/sqlx"
 
 const seeds = `
-	INSERT INTO products (name, cost, quantity) VALUES
-	('Book', 12, 3);
+	INSERT INTO products (name, cost, quantity, org_id) VALUES
+	('Book', 12, 3, '00000000-0000-0000-0000-000000000001');
 
 	INSERT INTO users
-	(user_id, name, email, roles, password_hash, date_created, date_updated)
+	(user_id, name, email, password_hash, date_created, date_updated)
 	VALUES
-	('3888f3a5-7b53-4674-96b7-ef5c1c758ef8', 'Ilia', 'nalimvp@gmail.com', '{ADMIN,USER}', '$2a$10$6SkK1KmGpnQ6uesDqVkLYO/h8LjEKSFM9XkXJ2Djiv3IAejneH6Mu', '2022-01-01 12:00:00', '2022-01-01 12:00:00')
+	('3888f3a5-7b53-4674-96b7-ef5c1c758ef8', 'Ilia', 'nalimvp@gmail.com', '$2a$10$6SkK1KmGpnQ6uesDqVkLYO/h8LjEKSFM9XkXJ2Djiv3IAejneH6Mu', '2022-01-01 12:00:00', '2022-01-01 12:00:00')
+	ON CONFLICT DO NOTHING;
+
+	INSERT INTO memberships
+	(org_id, user_id, roles, date_created)
+	VALUES
+	('00000000-0000-0000-0000-000000000001', '3888f3a5-7b53-4674-96b7-ef5c1c758ef8', '{ADMIN,USER}', '2022-01-01 12:00:00')
 	ON CONFLICT DO NOTHING;
 `
 